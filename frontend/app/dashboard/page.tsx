@@ -31,7 +31,7 @@ const DashboardPage: React.FC = () => {
     if (!user?.id) return;
     try {
       setLoading(true);
-      const data = await urlAPI.getUserURLs(user.id);
+      const data = await urlAPI.getUserURLs();
       setUrls(data || []);
     } catch (err: any) {
       setError(err.response?.data?.error || 'Failed to load URLs');
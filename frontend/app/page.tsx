@@ -9,7 +9,7 @@ import { toast, ToastContainer } from 'react-toastify';
 import 'react-toastify/dist/ReactToastify.css';
 
 const HomePage: React.FC = () => {
-    const { user, isAuthenticated } = useAuth();
+    const { isAuthenticated } = useAuth();
     const [url, setUrl] = useState('');
     const [minifyUrl, setMinifyUrl] = useState<MinifyResponse | null>(null);
     const [loading, setLoading] = useState(false);
@@ -32,7 +32,6 @@ const HomePage: React.FC = () => {
         try {
             const result = await urlAPI.minify({
                 url: url.trim(),
-                user_id: user?.id,
             });
             setMinifyUrl(result);
             setUrl('');
@@ -87,8 +87,8 @@ export const urlAPI = {
         return response.data;
     },
 
-    getUserURLs: async (userId: number): Promise<URL[]> => {
-        const response = await api.get(`/api/v1/urls?user_id=${userId}`);
+    getUserURLs: async (): Promise<URL[]> => {
+        const response = await api.get('/api/v1/urls');
         return response.data;
     },
 };
@@ -17,7 +17,7 @@ export interface URL {
 
 export interface MinifyRequest {
   url: string;
-  user_id?: number;
+  alias?: string;
 }
 
 export interface MinifyResponse {
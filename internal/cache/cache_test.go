@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRU[int](2)
+	c.Set("a", 1, time.Minute)
+	c.Set("b", 2, time.Minute)
+
+	t.Log("Reading a makes b the least recently used entry")
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = %d, %t, want 1, true", v, ok)
+	}
+	c.Set("c", 3, time.Minute)
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("Expected b to be evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("Expected a to survive eviction")
+	}
+	if c.Len() != 2 {
+		t.Fatalf("Expected 2 entries, got %d", c.Len())
+	}
+}
+
+func TestLRUExpiry(t *testing.T) {
+	c := NewLRU[string](10)
+	c.Set("short", "x", time.Millisecond)
+	c.Set("long", "y", time.Minute)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := c.Get("short"); ok {
+		t.Fatal("Expected expired entry to be a miss")
+	}
+	if _, ok := c.Get("long"); !ok {
+		t.Fatal("Expected unexpired entry to be a hit")
+	}
+
+	c.Delete("long")
+	if _, ok := c.Get("long"); ok {
+		t.Fatal("Expected deleted entry to be a miss")
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	ctx := context.Background()
+
+	store, err := NewRedisStore(ctx, "redis://"+server.Addr(), "test:")
+	if err != nil {
+		t.Fatalf("NewRedisStore failed: %v", err)
+	}
+	defer store.Close()
+
+	if _, ok, err := store.Get(ctx, "abc"); ok || err != nil {
+		t.Fatalf("Expected miss for unknown key, got %t, %v", ok, err)
+	}
+
+	if err := store.Set(ctx, "abc", []byte("value"), time.Minute); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if !server.Exists("test:abc") {
+		t.Fatal("Expected key to be stored with prefix")
+	}
+	value, ok, err := store.Get(ctx, "abc")
+	if !ok || err != nil || string(value) != "value" {
+		t.Fatalf("Get = %q, %t, %v, want value, true, nil", value, ok, err)
+	}
+
+	server.FastForward(2 * time.Minute)
+	if _, ok, _ := store.Get(ctx, "abc"); ok {
+		t.Fatal("Expected key to expire")
+	}
+
+	store.Set(ctx, "abc", []byte("value"), time.Minute)
+	if err := store.Delete(ctx, "abc"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, ok, _ := store.Get(ctx, "abc"); ok {
+		t.Fatal("Expected deleted key to be a miss")
+	}
+}
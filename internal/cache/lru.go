@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU is an in-process cache holding at most size entries, each expiring after its own TTL.
+// The least recently used entry is evicted when the cache is full
+type LRU[V any] struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key       string
+	value     V
+	expiresAt time.Time
+}
+
+func NewLRU[V any](size int) *LRU[V] {
+	return &LRU[V]{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// Get returns the value for key if it's present and hasn't expired
+func (c *LRU[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	el, ok := c.entries[key]
+	if !ok {
+		return zero, false
+	}
+
+	entry := el.Value.(*lruEntry[V])
+	if time.Now().After(entry.expiresAt) {
+		c.remove(el)
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+
+	return entry.value, true
+}
+
+// Set stores value under key for ttl, evicting the least recently used entry if the cache is full
+func (c *LRU[V]) Set(key string, value V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := time.Now().Add(ttl)
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*lruEntry[V])
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(el)
+
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry[V]{key: key, value: value, expiresAt: expiresAt})
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Delete removes key from the cache
+func (c *LRU[V]) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+}
+
+// Len returns the number of entries, including expired ones that haven't been evicted yet
+func (c *LRU[V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+func (c *LRU[V]) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*lruEntry[V]).key)
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store is a shared cache tier consulted when the in-process cache misses, so several
+// instances can share lookups. Implementations must be safe for concurrent use
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// RedisStore is a Store backed by Redis, or anything speaking its protocol
+type RedisStore struct {
+	client *redis.Client
+	prefix string // namespaces keys when the server is shared
+}
+
+// NewRedisStore connects to the server at a redis:// URL and checks that it's reachable
+func NewRedisStore(ctx context.Context, redisURL, prefix string) (*RedisStore, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	return &RedisStore{client: client, prefix: prefix}, nil
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, s.prefix+key, value, ttl).Err()
+}
+
+func (s *RedisStore) Delete(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.prefix+key).Err()
+}
+
+// Close closes the connection pool
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
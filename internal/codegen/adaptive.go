@@ -0,0 +1,81 @@
+package codegen
+
+import "sync"
+
+const (
+	adaptiveWindow       = 100  // codes generated per collision rate sample
+	adaptiveMaxRate      = 0.05 // grow once more than 5% of codes in a window collide
+	adaptiveMaxStreak    = 3    // or once this many codes in a row collide
+	adaptiveMaxExtraChar = 8    // never grow more than this beyond the starting length
+	maxCodeLength        = 32   // matches urls.short_code column width
+)
+
+// Adaptive generates random codes that start short and grow by one character whenever
+// collisions become frequent, which happens as the code space fills up
+type Adaptive struct {
+	mu           sync.Mutex
+	length       int
+	maxLength    int
+	generated    int
+	collisions   int
+	streak       int
+	lastCollided bool
+}
+
+func NewAdaptive(length int) *Adaptive {
+	maxLength := length + adaptiveMaxExtraChar
+	if maxLength > maxCodeLength {
+		maxLength = maxCodeLength
+	}
+
+	return &Adaptive{length: length, maxLength: maxLength}
+}
+
+func (g *Adaptive) Generate() (string, error) {
+	g.mu.Lock()
+	// the streak only continues while every code collides
+	if !g.lastCollided {
+		g.streak = 0
+	}
+	g.lastCollided = false
+
+	g.generated++
+	if g.generated >= adaptiveWindow {
+		if float64(g.collisions)/float64(g.generated) > adaptiveMaxRate {
+			g.grow()
+		}
+		g.generated, g.collisions = 0, 0
+	}
+	length := g.length
+	g.mu.Unlock()
+
+	return randomCode(length)
+}
+
+func (g *Adaptive) Collided() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.collisions++
+	g.streak++
+	g.lastCollided = true
+	if g.streak >= adaptiveMaxStreak {
+		g.grow()
+	}
+}
+
+// Length returns the current code length
+func (g *Adaptive) Length() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.length
+}
+
+// grow must be called with mu held
+func (g *Adaptive) grow() {
+	if g.length < g.maxLength {
+		g.length++
+	}
+	g.streak = 0
+}
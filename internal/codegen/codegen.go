@@ -0,0 +1,122 @@
+package codegen
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Alphabet is the url safe base62 charset used by every strategy
+const Alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// supported strategy names for config.Config.ShortCodeStrategy
+const (
+	StrategyRandom   = "random"
+	StrategyCounter  = "counter"
+	StrategyHashids  = "hashids"
+	StrategyAdaptive = "adaptive"
+)
+
+// CodeGenerator produces candidate short codes for new links. Uniqueness is enforced by the
+// urls.short_code constraint, so callers retry with a new code when an insert conflicts
+type CodeGenerator interface {
+	// Generate returns the next candidate short code
+	Generate() (string, error)
+
+	// Collided reports that the last generated code was already taken, strategies may use it to adapt
+	Collided()
+}
+
+// New builds the generator for the given strategy, length is the code length (or minimum length for
+// sequence based strategies) and salt shuffles the hashids alphabet
+func New(strategy string, length int, salt string, db *sql.DB) (CodeGenerator, error) {
+	if length <= 0 || length > maxCodeLength {
+		return nil, fmt.Errorf("short code length must be between 1 and %d, got %d", maxCodeLength, length)
+	}
+	if strategy == StrategyHashids && length > maxHashidsLength {
+		return nil, fmt.Errorf("hashids short codes can be at most %d characters, got %d", maxHashidsLength, length)
+	}
+
+	switch strategy {
+	case StrategyRandom, "":
+		return NewRandom(length), nil
+	case StrategyCounter:
+		return NewCounter(db, length), nil
+	case StrategyHashids:
+		return NewHashids(db, length, salt), nil
+	case StrategyAdaptive:
+		return NewAdaptive(length), nil
+	default:
+		return nil, fmt.Errorf("unknown short code strategy: %s", strategy)
+	}
+}
+
+// Random generates fixed length codes from crypto/rand
+type Random struct {
+	length int
+}
+
+func NewRandom(length int) *Random {
+	return &Random{length: length}
+}
+
+func (g *Random) Generate() (string, error) {
+	return randomCode(g.length)
+}
+
+func (g *Random) Collided() {}
+
+// randomCode creates a random, url safe alphanumeric code of the given length
+func randomCode(length int) (string, error) {
+	b := make([]byte, length)
+	max := big.NewInt(int64(len(Alphabet)))
+
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = Alphabet[n.Int64()]
+	}
+
+	return string(b), nil
+}
+
+// encodeBase62 writes n in the given alphabet, left padded with its first character to minLength
+func encodeBase62(n uint64, alphabet string, minLength int) string {
+	base := uint64(len(alphabet))
+
+	var b []byte
+	for n > 0 {
+		b = append(b, alphabet[n%base])
+		n /= base
+	}
+	for len(b) < minLength {
+		b = append(b, alphabet[0])
+	}
+
+	// digits were appended least significant first
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+
+	return string(b)
+}
+
+// decodeBase62 is the inverse of encodeBase62
+func decodeBase62(s, alphabet string) (uint64, error) {
+	base := uint64(len(alphabet))
+
+	var n uint64
+	for _, c := range s {
+		i := strings.IndexRune(alphabet, c)
+		if i < 0 {
+			return 0, fmt.Errorf("invalid character %q in code", c)
+		}
+		n = n*base + uint64(i)
+	}
+
+	return n, nil
+}
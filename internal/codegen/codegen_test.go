@@ -0,0 +1,107 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRandomGenerate(t *testing.T) {
+	g := NewRandom(8)
+
+	for i := 0; i < 100; i++ {
+		code, err := g.Generate()
+		if err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		if len(code) != 8 {
+			t.Fatalf("Expected 8 character code, got %q", code)
+		}
+		for _, c := range code {
+			if !strings.ContainsRune(Alphabet, c) {
+				t.Fatalf("Code %q contains non-base62 character %q", code, c)
+			}
+		}
+	}
+}
+
+func TestBase62RoundTrip(t *testing.T) {
+	for _, n := range []uint64{0, 1, 61, 62, 3843, 1 << 40} {
+		code := encodeBase62(n, Alphabet, 4)
+		if len(code) < 4 {
+			t.Fatalf("Expected code padded to 4 characters, got %q", code)
+		}
+
+		got, err := decodeBase62(code, Alphabet)
+		if err != nil || got != n {
+			t.Fatalf("decodeBase62(%q) = %d, %v, want %d", code, got, err, n)
+		}
+	}
+}
+
+func TestHashidsRoundTrip(t *testing.T) {
+	g := NewHashids(nil, 8, "test-salt")
+	seen := make(map[string]bool)
+
+	for id := uint64(1); id <= 1000; id++ {
+		code, err := g.Encode(id)
+		if err != nil {
+			t.Fatalf("Encode(%d) failed: %v", id, err)
+		}
+		if len(code) != 8 {
+			t.Fatalf("Expected 8 character code, got %q", code)
+		}
+		if seen[code] {
+			t.Fatalf("Code %q generated twice", code)
+		}
+		seen[code] = true
+
+		got, err := g.Decode(code)
+		if err != nil || got != id {
+			t.Fatalf("Decode(%q) = %d, %v, want %d", code, got, err, id)
+		}
+	}
+}
+
+func TestHashidsSaltChangesCodes(t *testing.T) {
+	a, _ := NewHashids(nil, 8, "salt-a").Encode(42)
+	b, _ := NewHashids(nil, 8, "salt-b").Encode(42)
+
+	if a == b {
+		t.Fatalf("Expected different salts to produce different codes, both got %q", a)
+	}
+}
+
+func TestAdaptiveGrowsOnCollisions(t *testing.T) {
+	g := NewAdaptive(4)
+
+	t.Log("Successful generations keep the starting length")
+	for i := 0; i < 10; i++ {
+		g.Generate()
+	}
+	if g.Length() != 4 {
+		t.Fatalf("Expected length 4 without collisions, got %d", g.Length())
+	}
+
+	t.Log("A streak of collisions grows the code length")
+	for i := 0; i < adaptiveMaxStreak; i++ {
+		g.Generate()
+		g.Collided()
+	}
+	if g.Length() != 5 {
+		t.Fatalf("Expected length 5 after a collision streak, got %d", g.Length())
+	}
+
+	code, _ := g.Generate()
+	if len(code) != 5 {
+		t.Fatalf("Expected 5 character code, got %q", code)
+	}
+}
+
+func TestNewRejectsUnknownStrategy(t *testing.T) {
+	if _, err := New("sequential", 8, "", nil); err == nil {
+		t.Fatal("Expected unknown strategy to be rejected")
+	}
+	if _, err := New(StrategyHashids, 12, "salt", nil); err == nil {
+		t.Fatal("Expected hashids length over the maximum to be rejected")
+	}
+}
@@ -0,0 +1,152 @@
+package codegen
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// SequenceName is the postgres sequence backing the counter and hashids strategies
+const SequenceName = "short_code_seq"
+
+// maxHashidsLength keeps 62^length within a uint64
+const maxHashidsLength = 10
+
+// Counter encodes the next value of a postgres sequence in base62, so codes are short and never
+// collide with each other (only with custom aliases)
+type Counter struct {
+	db        *sql.DB
+	minLength int
+}
+
+func NewCounter(db *sql.DB, minLength int) *Counter {
+	return &Counter{db: db, minLength: minLength}
+}
+
+func (g *Counter) Generate() (string, error) {
+	n, err := nextSequenceValue(g.db)
+	if err != nil {
+		return "", err
+	}
+
+	return encodeBase62(n, Alphabet, g.minLength), nil
+}
+
+func (g *Counter) Collided() {}
+
+// Hashids maps sequence values to fixed length codes that don't reveal how many links exist.
+// The mapping is a salted affine permutation mod 62^length, so it's reversible with Decode
+type Hashids struct {
+	db       *sql.DB
+	length   int
+	alphabet string
+	modulus  *big.Int
+	mult     *big.Int
+	inverse  *big.Int
+	offset   *big.Int
+}
+
+func NewHashids(db *sql.DB, length int, salt string) *Hashids {
+	if length > maxHashidsLength {
+		length = maxHashidsLength
+	}
+
+	modulus := new(big.Int).Exp(big.NewInt(int64(len(Alphabet))), big.NewInt(int64(length)), nil)
+	sum := sha256.Sum256([]byte(salt))
+
+	// the multiplier has to be coprime with 62^length (odd and not a multiple of 31) to be invertible
+	mult := new(big.Int).SetBytes(sum[:16])
+	mult.Mod(mult, modulus)
+	if mult.Bit(0) == 0 {
+		mult.Add(mult, big.NewInt(1))
+	}
+	for new(big.Int).Mod(mult, big.NewInt(31)).Sign() == 0 {
+		mult.Add(mult, big.NewInt(2))
+	}
+
+	offset := new(big.Int).SetBytes(sum[16:])
+	offset.Mod(offset, modulus)
+
+	return &Hashids{
+		db:       db,
+		length:   length,
+		alphabet: shuffle(Alphabet, salt),
+		modulus:  modulus,
+		mult:     mult,
+		inverse:  new(big.Int).ModInverse(mult, modulus),
+		offset:   offset,
+	}
+}
+
+func (g *Hashids) Generate() (string, error) {
+	n, err := nextSequenceValue(g.db)
+	if err != nil {
+		return "", err
+	}
+
+	return g.Encode(n)
+}
+
+func (g *Hashids) Collided() {}
+
+// Encode obfuscates an id into a short code
+func (g *Hashids) Encode(id uint64) (string, error) {
+	n := new(big.Int).SetUint64(id)
+	if n.Cmp(g.modulus) >= 0 {
+		return "", fmt.Errorf("id %d does not fit in a %d character code", id, g.length)
+	}
+
+	n.Mul(n, g.mult)
+	n.Add(n, g.offset)
+	n.Mod(n, g.modulus)
+
+	return encodeBase62(n.Uint64(), g.alphabet, g.length), nil
+}
+
+// Decode recovers the id a code was generated from
+func (g *Hashids) Decode(code string) (uint64, error) {
+	if len(code) != g.length {
+		return 0, errors.New("code has the wrong length")
+	}
+
+	m, err := decodeBase62(code, g.alphabet)
+	if err != nil {
+		return 0, err
+	}
+
+	n := new(big.Int).SetUint64(m)
+	n.Sub(n, g.offset)
+	n.Mul(n, g.inverse)
+	n.Mod(n, g.modulus)
+
+	return n.Uint64(), nil
+}
+
+func nextSequenceValue(db *sql.DB) (uint64, error) {
+	var n int64
+	if err := db.QueryRow(`SELECT nextval('` + SequenceName + `')`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to get next sequence value: %w", err)
+	}
+
+	return uint64(n), nil
+}
+
+// shuffle reorders the alphabet deterministically from the salt (the hashids "consistent shuffle")
+func shuffle(alphabet, salt string) string {
+	if salt == "" {
+		return alphabet
+	}
+
+	a := []byte(alphabet)
+	for i, v, p := len(a)-1, 0, 0; i > 0; i-- {
+		v %= len(salt)
+		p += int(salt[v])
+		j := (int(salt[v]) + v + p) % i
+		a[i], a[j] = a[j], a[i]
+		v++
+	}
+
+	return string(a)
+}
@@ -5,16 +5,49 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	Port        string
-	BaseURL     string
-	FrontendURL string
-	DatabaseURL string
-	JWTSecret   string
+	Port           string
+	BaseURL        string
+	FrontendURL    string
+	DatabaseURL    string
+	JWTSecret      string
+	AdminUserIDs   []int         // accounts allowed on operator endpoints, like the service-wide click stream
+	ExpiredLinkURL string        // where expired links redirect to, 410 Gone is returned if empty
+	ReaperInterval time.Duration // how often expired links are marked in the db
+	RestoreWindow  time.Duration // how long deleted links can be restored before they're purged
+	GeoIPDBPath    string        // MaxMind-format .mmdb file, geo targeting and country stats are off if empty
+
+	CacheSize        int           // max links held in memory for redirects, 0 disables caching
+	CacheTTL         time.Duration // how long a resolved link is cached
+	CacheNegativeTTL time.Duration // how long an unknown short code is cached
+	RedisURL         string        // optional shared cache tier behind the in-memory cache
+
+	ClickQueueSize     int           // clicks buffered before redirects start waiting and dropping them
+	ClickWorkers       int           // goroutines writing click batches
+	ClickBatchSize     int           // max clicks written per batch
+	ClickFlushInterval time.Duration // how often partial batches are written
+	CountBots          bool          // count crawler clicks in urls.clicks and analytics totals
+	VisitorSalt        string        // keys visitor hashes for unique visitor counts, defaults to JWT_SECRET
+
+	RollupInterval time.Duration // how often new clicks are rolled up into the hourly and daily rollups
+	RollupLag      time.Duration // how long after an hour ends its clicks are rolled up, covers clicks still queued
+
+	ClickRetentionDays int           // days raw clicks are kept once rolled up, 0 keeps them forever
+	RetentionInterval  time.Duration // how often old clicks are pruned and upcoming partitions created
+	ClickArchiveDir    string        // pruned clicks are archived here as gzipped NDJSON, not archived if empty
+
+	WebhookPollInterval  time.Duration // how often the webhook outbox is checked for due deliveries
+	WebhookAllowPrivate  bool          // allow webhooks to private/loopback addresses, for local development
+	WebhookRetentionDays int           // days finished deliveries and their attempts are kept, 0 keeps them forever
+
+	ShortCodeStrategy string // random, counter, hashids or adaptive (see codegen package)
+	ShortCodeLength   int    // code length, or minimum length for counter codes
+	ShortCodeSalt     string // shuffles the hashids alphabet so codes can't be decoded by others
 }
 
 // Load reads environment variables (via .env) and returns a Config struct with defaults.
@@ -22,11 +55,43 @@ func Load() *Config {
 	godotenv.Load()
 
 	return &Config{
-		Port:        getEnv("PORT", "8080"),
-		BaseURL:     getEnv("BASE_URL", "http://localhost:8080"),
-		FrontendURL: getEnv("FRONTEND_URL", "http://localhost:3000"),
-		DatabaseURL: getEnv("DATABASE_URL", "postgres://postgres@localhost/minify?sslmode=disable"),
-		JWTSecret:   getEnv("JWT_SECRET"),
+		Port:           getEnv("PORT", "8080"),
+		BaseURL:        getEnv("BASE_URL", "http://localhost:8080"),
+		FrontendURL:    getEnv("FRONTEND_URL", "http://localhost:3000"),
+		DatabaseURL:    getEnv("DATABASE_URL", "postgres://postgres@localhost/minify?sslmode=disable"),
+		JWTSecret:      getEnv("JWT_SECRET"),
+		AdminUserIDs:   getIntList("ADMIN_USER_IDS"),
+		ExpiredLinkURL: getEnv("EXPIRED_LINK_URL"),
+		ReaperInterval: getDuration("REAPER_INTERVAL", time.Minute),
+		RestoreWindow:  getDuration("RESTORE_WINDOW", 30*24*time.Hour),
+		GeoIPDBPath:    getEnv("GEOIP_DB_PATH"),
+
+		CacheSize:        getInt("CACHE_SIZE", 10000),
+		CacheTTL:         getDuration("CACHE_TTL", time.Minute),
+		CacheNegativeTTL: getDuration("CACHE_NEGATIVE_TTL", 10*time.Second),
+		RedisURL:         getEnv("REDIS_URL"),
+
+		ClickQueueSize:     getInt("CLICK_QUEUE_SIZE", 10000),
+		ClickWorkers:       getInt("CLICK_WORKERS", 2),
+		ClickBatchSize:     getInt("CLICK_BATCH_SIZE", 500),
+		ClickFlushInterval: getDuration("CLICK_FLUSH_INTERVAL", time.Second),
+		CountBots:          getEnv("COUNT_BOTS") == "true",
+		VisitorSalt:        getEnv("VISITOR_SALT", getEnv("JWT_SECRET")),
+
+		RollupInterval: getDuration("ROLLUP_INTERVAL", time.Minute),
+		RollupLag:      getDuration("ROLLUP_LAG", 5*time.Minute),
+
+		ClickRetentionDays: getInt("CLICK_RETENTION_DAYS", 0),
+		RetentionInterval:  getDuration("RETENTION_INTERVAL", time.Hour),
+		ClickArchiveDir:    getEnv("CLICK_ARCHIVE_DIR"),
+
+		WebhookPollInterval:  getDuration("WEBHOOK_POLL_INTERVAL", 5*time.Second),
+		WebhookAllowPrivate:  getEnv("WEBHOOK_ALLOW_PRIVATE") == "true",
+		WebhookRetentionDays: getInt("WEBHOOK_RETENTION_DAYS", 30),
+
+		ShortCodeStrategy: getEnv("SHORT_CODE_STRATEGY", "random"),
+		ShortCodeLength:   getInt("SHORT_CODE_LENGTH", 8),
+		ShortCodeSalt:     getEnv("SHORT_CODE_SALT"),
 	}
 }
 
@@ -52,6 +117,81 @@ func (c *Config) Validate() error {
 		errs = append(errs, "JWT_SECRET should be set to a secure random value (for example: openssl rand -base64 32)")
 	}
 
+	for _, id := range c.AdminUserIDs {
+		if id <= 0 {
+			errs = append(errs, "ADMIN_USER_IDS must be a comma-separated list of user ids")
+			break
+		}
+	}
+
+	if c.ExpiredLinkURL != "" && !strings.HasPrefix(c.ExpiredLinkURL, "http") {
+		errs = append(errs, "EXPIRED_LINK_URL must be an absolute http(s) URL")
+	}
+
+	if c.ReaperInterval <= 0 {
+		errs = append(errs, "REAPER_INTERVAL must be a positive duration (for example: 1m)")
+	}
+
+	if c.RestoreWindow <= 0 {
+		errs = append(errs, "RESTORE_WINDOW must be a positive duration (for example: 720h)")
+	}
+
+	switch c.ShortCodeStrategy {
+	case "random", "counter", "hashids", "adaptive":
+	default:
+		errs = append(errs, "SHORT_CODE_STRATEGY must be one of: random, counter, hashids, adaptive")
+	}
+
+	if c.ShortCodeLength < 1 || c.ShortCodeLength > 32 {
+		errs = append(errs, "SHORT_CODE_LENGTH must be a number between 1 and 32")
+	} else if c.ShortCodeStrategy == "hashids" && c.ShortCodeLength > 10 {
+		errs = append(errs, "SHORT_CODE_LENGTH can be at most 10 for the hashids strategy")
+	}
+
+	if c.ShortCodeStrategy == "hashids" && c.ShortCodeSalt == "" {
+		errs = append(errs, "SHORT_CODE_SALT is required for the hashids strategy")
+	}
+
+	if c.CacheSize < 0 {
+		errs = append(errs, "CACHE_SIZE must be a non-negative number (0 disables the cache)")
+	}
+
+	if c.CacheTTL <= 0 || c.CacheNegativeTTL <= 0 {
+		errs = append(errs, "CACHE_TTL and CACHE_NEGATIVE_TTL must be positive durations (for example: 1m, 10s)")
+	}
+
+	if c.RedisURL != "" && c.CacheSize == 0 {
+		errs = append(errs, "REDIS_URL requires the cache to be enabled (CACHE_SIZE > 0)")
+	}
+
+	if c.ClickQueueSize < 1 || c.ClickWorkers < 1 || c.ClickBatchSize < 1 {
+		errs = append(errs, "CLICK_QUEUE_SIZE, CLICK_WORKERS and CLICK_BATCH_SIZE must be positive numbers")
+	}
+
+	if c.ClickFlushInterval <= 0 {
+		errs = append(errs, "CLICK_FLUSH_INTERVAL must be a positive duration (for example: 1s)")
+	}
+
+	if c.RollupInterval <= 0 || c.RollupLag <= 0 {
+		errs = append(errs, "ROLLUP_INTERVAL and ROLLUP_LAG must be positive durations (for example: 1m, 5m)")
+	}
+
+	if c.ClickRetentionDays < 0 {
+		errs = append(errs, "CLICK_RETENTION_DAYS must be a non-negative number (0 keeps raw clicks forever)")
+	}
+
+	if c.RetentionInterval <= 0 {
+		errs = append(errs, "RETENTION_INTERVAL must be a positive duration (for example: 1h)")
+	}
+
+	if c.WebhookPollInterval <= 0 {
+		errs = append(errs, "WEBHOOK_POLL_INTERVAL must be a positive duration (for example: 5s)")
+	}
+
+	if c.WebhookRetentionDays < 0 {
+		errs = append(errs, "WEBHOOK_RETENTION_DAYS must be a non-negative number (0 keeps deliveries forever)")
+	}
+
 	if len(errs) > 0 {
 		return errors.New("config validation failed:\n  - " + strings.Join(errs, "\n  - "))
 	}
@@ -70,3 +210,53 @@ func getEnv(key string, defaultValue ...string) string {
 
 	return ""
 }
+
+// getDuration parses a duration such as "30s" or "5m" from the environment, returning
+// 0 for malformed values so Validate can report them
+func getDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0
+	}
+
+	return d
+}
+
+// getInt parses an integer from the environment, returning -1 for malformed values so Validate can report them
+func getInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return -1
+	}
+
+	return n
+}
+
+// getIntList parses a comma-separated list of integers from the environment, malformed entries are
+// returned as -1 so Validate can report them
+func getIntList(key string) []int {
+	var list []int
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			n = -1
+		}
+		list = append(list, n)
+	}
+
+	return list
+}
@@ -39,7 +39,7 @@ func Migrate(db *sql.DB) error {
 		)`,
 		`CREATE TABLE IF NOT EXISTS urls (
 			id SERIAL PRIMARY KEY,
-			short_code VARCHAR(10) UNIQUE NOT NULL,
+			short_code VARCHAR(32) UNIQUE NOT NULL,
 			original_url TEXT NOT NULL,
 			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
 			clicks INTEGER DEFAULT 0,
@@ -53,8 +53,195 @@ func Migrate(db *sql.DB) error {
 			ip_address VARCHAR(45),
 			clicked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
+		`ALTER TABLE urls ALTER COLUMN short_code TYPE VARCHAR(32)`, // widened for custom aliases
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS max_clicks INTEGER`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS expired BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS active BOOLEAN NOT NULL DEFAULT TRUE`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS password_hash VARCHAR(255)`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS canonical_url TEXT`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS redirect_type SMALLINT NOT NULL DEFAULT 302`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS forward_query VARCHAR(20) NOT NULL DEFAULT ''`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS forward_path BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS targeting_rules JSONB`,
+		`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS target_url TEXT`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS country_overrides JSONB`,
+		`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS country CHAR(2)`,
+		`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS region VARCHAR(8)`,
+		`CREATE INDEX IF NOT EXISTS idx_clicks_url_country ON clicks(url_id, country)`,
+		`CREATE TABLE IF NOT EXISTS url_destinations (
+			id SERIAL PRIMARY KEY,
+			url_id INTEGER NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+			destination_url TEXT NOT NULL,
+			weight INTEGER NOT NULL CHECK (weight > 0),
+			position SMALLINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_url_destinations_url_id ON url_destinations(url_id)`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS sticky_variants BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS variant_id INTEGER REFERENCES url_destinations(id) ON DELETE SET NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_clicks_variant_id ON clicks(variant_id) WHERE variant_id IS NOT NULL`,
+		`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS browser VARCHAR(32)`,
+		`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS browser_version VARCHAR(32)`,
+		`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS os VARCHAR(32)`,
+		`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS device VARCHAR(16)`,
+		`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS bot_name VARCHAR(64)`,
+		`CREATE INDEX IF NOT EXISTS idx_clicks_bots ON clicks(url_id, bot_name) WHERE is_bot`,
+		`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS referrer_domain VARCHAR(255)`,
+		`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS utm_source VARCHAR(255)`,
+		`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS utm_medium VARCHAR(255)`,
+		`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS utm_campaign VARCHAR(255)`,
+		`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS utm_term VARCHAR(255)`,
+		`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS utm_content VARCHAR(255)`,
+		`CREATE TABLE IF NOT EXISTS url_visitor_sketches (
+			url_id INTEGER NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+			day DATE NOT NULL,
+			sketch BYTEA NOT NULL, -- HyperLogLog of the link's visitors that UTC day
+			PRIMARY KEY (url_id, day)
+		)`,
+		`CREATE TABLE IF NOT EXISTS visitor_sketches (
+			day DATE PRIMARY KEY,
+			sketch BYTEA NOT NULL -- HyperLogLog of all visitors that UTC day
+		)`,
+		`CREATE TABLE IF NOT EXISTS webhooks (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			url TEXT NOT NULL,
+			events TEXT[] NOT NULL,
+			secret VARCHAR(255) NOT NULL,
+			active BOOLEAN NOT NULL DEFAULT TRUE,
+			consecutive_failures INTEGER NOT NULL DEFAULT 0,
+			disabled_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id BIGSERIAL PRIMARY KEY,
+			webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+			event_type VARCHAR(32) NOT NULL,
+			payload JSONB NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			delivered_at TIMESTAMP,
+			failed_at TIMESTAMP, -- gave up after too many attempts
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS webhook_attempts (
+			id BIGSERIAL PRIMARY KEY,
+			delivery_id BIGINT NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+			webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+			attempt INTEGER NOT NULL,
+			status_code INTEGER,
+			error TEXT,
+			duration_ms INTEGER NOT NULL,
+			success BOOLEAN NOT NULL,
+			attempted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		// click rollups: human and bot clicks are kept apart so COUNT_BOTS can still apply at read time
+		`CREATE TABLE IF NOT EXISTS click_rollups_hourly (
+			url_id INTEGER NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+			bucket TIMESTAMP NOT NULL,
+			clicks BIGINT NOT NULL DEFAULT 0,
+			bot_clicks BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (url_id, bucket)
+		)`,
+		`CREATE TABLE IF NOT EXISTS click_rollups_daily (
+			url_id INTEGER NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+			bucket TIMESTAMP NOT NULL,
+			clicks BIGINT NOT NULL DEFAULT 0,
+			bot_clicks BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (url_id, bucket)
+		)`,
+		`CREATE TABLE IF NOT EXISTS site_rollups_hourly (
+			bucket TIMESTAMP PRIMARY KEY,
+			clicks BIGINT NOT NULL DEFAULT 0,
+			bot_clicks BIGINT NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS site_rollups_daily (
+			bucket TIMESTAMP PRIMARY KEY,
+			clicks BIGINT NOT NULL DEFAULT 0,
+			bot_clicks BIGINT NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS rollup_state (
+			name VARCHAR(32) PRIMARY KEY,
+			rolled_from TIMESTAMP NOT NULL, -- clicks in [rolled_from, rolled_to) are in the rollups
+			rolled_to TIMESTAMP NOT NULL
+		)`,
+		`ALTER TABLE rollup_state ADD COLUMN IF NOT EXISTS pruned_before TIMESTAMP`, // raw clicks before this are pruned
+		// A/B results per variant, rolled up so they still cover clicks past the retention. When the
+		// table is first created it's filled for the range that's already rolled up
+		`DO $$
+		BEGIN
+			IF to_regclass('variant_rollups_hourly') IS NOT NULL THEN
+				RETURN;
+			END IF;
+
+			CREATE TABLE variant_rollups_hourly (
+				variant_id INTEGER NOT NULL REFERENCES url_destinations(id) ON DELETE CASCADE,
+				bucket TIMESTAMP NOT NULL,
+				clicks BIGINT NOT NULL DEFAULT 0,
+				bot_clicks BIGINT NOT NULL DEFAULT 0,
+				PRIMARY KEY (variant_id, bucket)
+			);
+
+			INSERT INTO variant_rollups_hourly (variant_id, bucket, clicks, bot_clicks)
+			SELECT c.variant_id, date_trunc('hour', c.clicked_at), COUNT(*) FILTER (WHERE NOT c.is_bot), COUNT(*) FILTER (WHERE c.is_bot)
+			FROM clicks c
+			JOIN url_destinations d ON d.id = c.variant_id
+			JOIN rollup_state s ON s.name = 'clicks'
+			WHERE c.clicked_at >= s.rolled_from AND c.clicked_at < s.rolled_to
+			GROUP BY 1, 2;
+		END $$`,
+		`CREATE INDEX IF NOT EXISTS idx_click_rollups_hourly_bucket ON click_rollups_hourly(bucket)`,
+		`CREATE INDEX IF NOT EXISTS idx_variant_rollups_hourly_bucket ON variant_rollups_hourly(bucket)`,
+		`CREATE INDEX IF NOT EXISTS idx_click_rollups_daily_bucket ON click_rollups_daily(bucket)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhooks_user_id ON webhooks(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE delivered_at IS NULL AND failed_at IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_finished ON webhook_deliveries((COALESCE(delivered_at, failed_at))) WHERE delivered_at IS NOT NULL OR failed_at IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_attempts_webhook ON webhook_attempts(webhook_id, attempted_at DESC)`,
+		`CREATE SEQUENCE IF NOT EXISTS short_code_seq`, // used by the counter and hashids code strategies
 		`CREATE INDEX IF NOT EXISTS idx_urls_short_code ON urls(short_code)`,
 		`CREATE INDEX IF NOT EXISTS idx_urls_user_id ON urls(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_urls_created_at ON urls(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_urls_user_canonical ON urls(user_id, canonical_url)`,
+		`CREATE INDEX IF NOT EXISTS idx_urls_expires_at ON urls(expires_at) WHERE NOT expired`,
+		`CREATE INDEX IF NOT EXISTS idx_urls_deleted_at ON urls(deleted_at) WHERE deleted_at IS NOT NULL`,
+		// clicks is range partitioned on clicked_at so retention can drop whole months of clicks. The
+		// unpartitioned table becomes the first partition, covering every click before next month, and
+		// its indexes are renamed so the ones below are created on the partitioned table and attached
+		`DO $$
+		DECLARE
+			bound TIMESTAMP;
+		BEGIN
+			IF (SELECT relkind FROM pg_class WHERE oid = 'clicks'::regclass) = 'p' THEN
+				RETURN;
+			END IF;
+
+			LOCK TABLE clicks IN ACCESS EXCLUSIVE MODE;
+			ALTER TABLE clicks RENAME TO clicks_legacy;
+			ALTER TABLE clicks_legacy DROP CONSTRAINT clicks_pkey;
+			ALTER INDEX IF EXISTS idx_clicks_url_country RENAME TO idx_clicks_legacy_url_country;
+			ALTER INDEX IF EXISTS idx_clicks_variant_id RENAME TO idx_clicks_legacy_variant_id;
+			ALTER INDEX IF EXISTS idx_clicks_bots RENAME TO idx_clicks_legacy_bots;
+			ALTER INDEX IF EXISTS idx_clicks_url_id RENAME TO idx_clicks_legacy_url_id;
+			ALTER INDEX IF EXISTS idx_clicks_clicked_at RENAME TO idx_clicks_legacy_clicked_at;
+			UPDATE clicks_legacy SET clicked_at = NOW() AT TIME ZONE 'UTC' WHERE clicked_at IS NULL;
+			ALTER TABLE clicks_legacy ALTER COLUMN clicked_at SET NOT NULL;
+
+			-- unique constraints on a partitioned table must include the partition key
+			CREATE TABLE clicks (LIKE clicks_legacy INCLUDING DEFAULTS, PRIMARY KEY (id, clicked_at)) PARTITION BY RANGE (clicked_at);
+			ALTER SEQUENCE clicks_id_seq OWNED BY clicks.id;
+			ALTER TABLE clicks ADD FOREIGN KEY (url_id) REFERENCES urls(id) ON DELETE CASCADE;
+			ALTER TABLE clicks ADD FOREIGN KEY (variant_id) REFERENCES url_destinations(id) ON DELETE SET NULL;
+
+			SELECT date_trunc('month', GREATEST(NOW() AT TIME ZONE 'UTC', MAX(clicked_at))) + INTERVAL '1 month' INTO bound FROM clicks_legacy;
+			EXECUTE format('ALTER TABLE clicks ATTACH PARTITION clicks_legacy FOR VALUES FROM (MINVALUE) TO (%L)', bound);
+		END $$`,
+		`CREATE INDEX IF NOT EXISTS idx_clicks_url_country ON clicks(url_id, country)`,
+		`CREATE INDEX IF NOT EXISTS idx_clicks_variant_id ON clicks(variant_id) WHERE variant_id IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_clicks_bots ON clicks(url_id, bot_name) WHERE is_bot`,
 		`CREATE INDEX IF NOT EXISTS idx_clicks_url_id ON clicks(url_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_clicks_clicked_at ON clicks(clicked_at)`,
 	}
@@ -0,0 +1,223 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+type Format string
+
+const (
+	CSV     Format = "csv"
+	NDJSON  Format = "ndjson"
+	Parquet Format = "parquet"
+)
+
+// parquetRowGroupSize is the number of rows buffered before a row group is written out, bounding
+// the memory a parquet export holds regardless of its length
+const parquetRowGroupSize = 10000
+
+var ErrUnsupportedFormat = errors.New("unsupported export format, use csv, ndjson or parquet")
+
+// contentTypes maps each format to the media type it is served as, followed by aliases accepted
+// in an Accept header
+var contentTypes = map[Format][]string{
+	CSV:     {"text/csv"},
+	NDJSON:  {"application/x-ndjson", "application/ndjson", "application/jsonl"},
+	Parquet: {"application/vnd.apache.parquet", "application/x-parquet"},
+}
+
+// Negotiate picks the export format from the format query param, falling back to the first
+// supported media type in the Accept header and then to CSV
+func Negotiate(param, accept string) (Format, error) {
+	if param != "" {
+		f := Format(strings.ToLower(param))
+		if _, ok := contentTypes[f]; !ok {
+			return "", ErrUnsupportedFormat
+		}
+
+		return f, nil
+	}
+
+	// Accept is read in order, q-values aren't worth weighing for three formats
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		for f, types := range contentTypes {
+			for _, t := range types {
+				if mediaType == t {
+					return f, nil
+				}
+			}
+		}
+	}
+
+	return CSV, nil
+}
+
+// ContentType is the media type a format is served as
+func (f Format) ContentType() string {
+	return contentTypes[f][0]
+}
+
+// Extension is the file extension for a format, used in download file names
+func (f Format) Extension() string {
+	if f == NDJSON {
+		return "ndjson"
+	}
+
+	return string(f)
+}
+
+// Writer encodes rows of type T to an output stream. Close must be called to finish the output,
+// it doesn't close the underlying writer
+type Writer[T any] interface {
+	Write(row T) error
+	Close() error
+}
+
+// NewWriter returns a Writer for T in the given format. T must be a struct, its json tags name the
+// CSV columns and NDJSON fields, and its parquet tags the parquet columns
+func NewWriter[T any](f Format, w io.Writer) (Writer[T], error) {
+	switch f {
+	case CSV:
+		return newCSVWriter[T](w), nil
+	case NDJSON:
+		return &ndjsonWriter[T]{enc: json.NewEncoder(w)}, nil
+	case Parquet:
+		return &parquetWriter[T]{w: parquet.NewGenericWriter[T](w, parquet.Compression(&parquet.Zstd))}, nil
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+type csvWriter[T any] struct {
+	w       *csv.Writer
+	columns []int // indexes of the exported struct fields, in column order
+	record  []string
+	started bool
+	header  []string
+}
+
+func newCSVWriter[T any](w io.Writer) *csvWriter[T] {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	c := &csvWriter[T]{w: csv.NewWriter(w)}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "-" || !t.Field(i).IsExported() {
+			continue
+		}
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		c.columns = append(c.columns, i)
+		c.header = append(c.header, name)
+	}
+	c.record = make([]string, len(c.columns))
+
+	return c
+}
+
+func (c *csvWriter[T]) Write(row T) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(row)
+	for i, field := range c.columns {
+		c.record[i] = csvValue(v.Field(field))
+	}
+
+	return c.w.Write(c.record)
+}
+
+// writeHeader writes the header row once, so an empty export still has its columns
+func (c *csvWriter[T]) writeHeader() error {
+	if c.started {
+		return nil
+	}
+	c.started = true
+
+	return c.w.Write(c.header)
+}
+
+func (c *csvWriter[T]) Close() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.w.Flush()
+
+	return c.w.Error()
+}
+
+// csvValue formats a field for CSV, nil pointers become empty cells and times are RFC 3339 in UTC
+func csvValue(v reflect.Value) string {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+type ndjsonWriter[T any] struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter[T]) Write(row T) error {
+	return n.enc.Encode(row)
+}
+
+func (n *ndjsonWriter[T]) Close() error {
+	return nil
+}
+
+type parquetWriter[T any] struct {
+	w        *parquet.GenericWriter[T]
+	buffered int
+}
+
+func (p *parquetWriter[T]) Write(row T) error {
+	if _, err := p.w.Write([]T{row}); err != nil {
+		return err
+	}
+	p.buffered++
+	if p.buffered < parquetRowGroupSize {
+		return nil
+	}
+	p.buffered = 0
+
+	return p.w.Flush()
+}
+
+func (p *parquetWriter[T]) Close() error {
+	return p.w.Close()
+}
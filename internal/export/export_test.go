@@ -0,0 +1,151 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"minify/internal/models"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+type testRow struct {
+	Code    string     `json:"code" parquet:"code"`
+	Count   int64      `json:"count" parquet:"count"`
+	Bot     bool       `json:"bot" parquet:"bot"`
+	Limit   *int64     `json:"limit" parquet:"limit"`
+	Seen    time.Time  `json:"seen" parquet:"seen"`
+	Expires *time.Time `json:"expires" parquet:"expires"`
+	Secret  string     `json:"-" parquet:"-"`
+}
+
+func testRows() []testRow {
+	limit := int64(100)
+	seen := time.Date(2024, 3, 10, 12, 30, 0, 0, time.UTC)
+	expires := seen.Add(24 * time.Hour)
+
+	return []testRow{
+		{Code: "abc", Count: 3, Limit: &limit, Seen: seen, Expires: &expires, Secret: "x"},
+		{Code: "with,comma", Count: 0, Bot: true, Seen: seen},
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		param, accept string
+		want          Format
+	}{
+		{"", "", CSV},
+		{"", "*/*", CSV},
+		{"ndjson", "text/csv", NDJSON},
+		{"PARQUET", "", Parquet},
+		{"", "application/vnd.apache.parquet", Parquet},
+		{"", "text/html, application/x-ndjson;q=0.9", NDJSON},
+		{"", "application/jsonl", NDJSON},
+		{"", "text/csv; charset=utf-8", CSV},
+	}
+	for _, tt := range tests {
+		got, err := Negotiate(tt.param, tt.accept)
+		if err != nil || got != tt.want {
+			t.Errorf("Negotiate(%q, %q) = %s, %v, want %s", tt.param, tt.accept, got, err, tt.want)
+		}
+	}
+
+	if _, err := Negotiate("xlsx", ""); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("Expected ErrUnsupportedFormat, got %v", err)
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewWriter[testRow](CSV, &buf)
+	for _, row := range testRows() {
+		if err := w.Write(row); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	want := "code,count,bot,limit,seen,expires\n" +
+		"abc,3,false,100,2024-03-10T12:30:00Z,2024-03-11T12:30:00Z\n" +
+		"\"with,comma\",0,true,,2024-03-10T12:30:00Z,\n"
+	if buf.String() != want {
+		t.Fatalf("Unexpected CSV:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	t.Log("An empty export still has its header")
+	buf.Reset()
+	w, _ = NewWriter[testRow](CSV, &buf)
+	w.Close()
+	if buf.String() != "code,count,bot,limit,seen,expires\n" {
+		t.Fatalf("Unexpected empty CSV: %q", buf.String())
+	}
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewWriter[testRow](NDJSON, &buf)
+	for _, row := range testRows() {
+		w.Write(row)
+	}
+	w.Close()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d: %q", len(lines), buf.String())
+	}
+	var row testRow
+	if err := json.Unmarshal([]byte(lines[1]), &row); err != nil {
+		t.Fatalf("Line is not JSON: %v", err)
+	}
+	if row.Code != "with,comma" || !row.Bot || row.Limit != nil {
+		t.Fatalf("Unexpected row: %+v", row)
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewWriter[testRow](Parquet, &buf)
+	for _, row := range testRows() {
+		if err := w.Write(row); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	rows, err := parquet.Read[testRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read parquet: %v", err)
+	}
+	want := testRows()
+	if len(rows) != len(want) {
+		t.Fatalf("Expected %d rows, got %d", len(want), len(rows))
+	}
+	if rows[0].Code != "abc" || *rows[0].Limit != 100 || !rows[0].Seen.Equal(want[0].Seen) || !rows[0].Expires.Equal(*want[0].Expires) {
+		t.Fatalf("Unexpected first row: %+v", rows[0])
+	}
+	if rows[1].Limit != nil || rows[1].Expires != nil || !rows[1].Bot {
+		t.Fatalf("Expected nulls to round trip, got %+v", rows[1])
+	}
+}
+
+func TestExportModelSchemas(t *testing.T) {
+	// parquet tags are only checked when a writer is built, and a bad one panics
+	var buf bytes.Buffer
+	links, _ := NewWriter[models.LinkExport](Parquet, &buf)
+	clicks, _ := NewWriter[models.ClickExport](Parquet, &buf)
+	if err := links.Close(); err != nil {
+		t.Fatalf("Failed to write links: %v", err)
+	}
+	if err := clicks.Close(); err != nil {
+		t.Fatalf("Failed to write clicks: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package geo
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Location is where an IP address resolved to. Fields are empty when the address is unknown
+type Location struct {
+	Country string `json:"country"` // ISO 3166-1 alpha-2 code, e.g. "GB"
+	Region  string `json:"region"`  // ISO 3166-2 subdivision code without the country prefix, e.g. "ENG"
+}
+
+// record is the subset of a GeoIP2/GeoLite2 City or Country record that we read
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+}
+
+// Resolver looks up IP addresses in a MaxMind-format database. A nil Resolver is valid and
+// resolves every address to an empty Location, so geo lookups can be left unconfigured
+type Resolver struct {
+	db *maxminddb.Reader
+}
+
+// Open loads the .mmdb file at path into memory. An empty path disables geo lookups
+func Open(path string) (*Resolver, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+
+	return &Resolver{db: db}, nil
+}
+
+// Lookup resolves an IP address to its country and region
+func (r *Resolver) Lookup(ip string) Location {
+	if r == nil {
+		return Location{}
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return Location{}
+	}
+
+	var rec record
+	if err := r.db.Lookup(addr, &rec); err != nil {
+		return Location{}
+	}
+
+	loc := Location{Country: rec.Country.ISOCode}
+	if len(rec.Subdivisions) > 0 {
+		loc.Region = rec.Subdivisions[0].ISOCode
+	}
+
+	return loc
+}
+
+// Close releases the database
+func (r *Resolver) Close() error {
+	if r == nil {
+		return nil
+	}
+
+	return r.db.Close()
+}
@@ -0,0 +1,49 @@
+package geo
+
+import "testing"
+
+func TestLookup(t *testing.T) {
+	r, err := Open("testdata/test-city.mmdb")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer r.Close()
+
+	tests := []struct {
+		ip   string
+		want Location
+	}{
+		{"81.2.69.142", Location{Country: "GB", Region: "ENG"}},
+		{"89.160.20.120", Location{Country: "SE", Region: "E"}},
+		{"216.160.83.60", Location{Country: "US", Region: "WA"}},
+		{"2001:db8:1::1", Location{Country: "DE"}},
+		{"10.0.0.1", Location{}},
+		{"not-an-ip", Location{}},
+	}
+
+	for _, tt := range tests {
+		if got := r.Lookup(tt.ip); got != tt.want {
+			t.Fatalf("Lookup(%q) = %+v, want %+v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestNilResolver(t *testing.T) {
+	r, err := Open("")
+	if err != nil {
+		t.Fatalf("Open with empty path failed: %v", err)
+	}
+
+	if got := r.Lookup("81.2.69.142"); got != (Location{}) {
+		t.Fatalf("Expected empty location from disabled resolver, got %+v", got)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestOpenMissingFile(t *testing.T) {
+	if _, err := Open("testdata/missing.mmdb"); err == nil {
+		t.Fatal("Expected missing database to fail")
+	}
+}
@@ -1,16 +1,29 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
+	"minify/internal/middleware"
 	"minify/internal/services"
 	"minify/internal/utils"
 
 	"github.com/gorilla/mux"
 )
 
+const (
+	defaultReportLimit = 10
+	maxReportLimit     = 100
+
+	streamHeartbeat = 15 * time.Second
+	streamRetry     = 3 * time.Second // how long EventSource clients wait before reconnecting
+)
+
 type AnalyticsHandler struct {
 	analyticsService *services.AnalyticsService // handles fetching analytics data
 }
@@ -58,28 +71,252 @@ func (h *AnalyticsHandler) GetPopularURLs(w http.ResponseWriter, r *http.Request
 	log.Printf("[Analytics] Sent top %d popular URLs\n", limit)
 }
 
-// GetTimeframeStats fetches analytics for a specific period (hour, day, month, year)
+// GetCountryStats returns clicks across all URLs grouped by visitor country
+func (h *AnalyticsHandler) GetCountryStats(w http.ResponseWriter, r *http.Request) {
+	log.Println("[Analytics] GetCountryStats request received")
+
+	countries, err := h.analyticsService.GetClicksByCountry(nil)
+	if err != nil {
+		log.Println("[Analytics] Failed to get country stats:", err)
+		utils.JSONError(w, "Failed to get country stats", http.StatusInternalServerError)
+
+		return
+	}
+
+	setRawClicksFrom(w, h.analyticsService)
+	utils.JSONResponse(w, countries, http.StatusOK)
+	log.Println("[Analytics] Country stats sent")
+}
+
+// GetBotStats returns bot clicks across all URLs grouped by crawler
+func (h *AnalyticsHandler) GetBotStats(w http.ResponseWriter, r *http.Request) {
+	log.Println("[Analytics] GetBotStats request received")
+
+	bots, err := h.analyticsService.GetBotClicks(nil)
+	if err != nil {
+		log.Println("[Analytics] Failed to get bot stats:", err)
+		utils.JSONError(w, "Failed to get bot stats", http.StatusInternalServerError)
+
+		return
+	}
+
+	setRawClicksFrom(w, h.analyticsService)
+	utils.JSONResponse(w, bots, http.StatusOK)
+	log.Println("[Analytics] Bot stats sent")
+}
+
+// GetReferrerStats returns the top referring domains across all URLs
+func (h *AnalyticsHandler) GetReferrerStats(w http.ResponseWriter, r *http.Request) {
+	log.Println("[Analytics] GetReferrerStats request received")
+	period, limit := reportParams(r)
+
+	referrers, err := h.analyticsService.GetTopReferrers(nil, period, limit)
+	if err != nil {
+		log.Println("[Analytics] Failed to get referrer stats:", err)
+		writeReportError(w, err, "Failed to get referrer stats")
+
+		return
+	}
+
+	setRawClicksFrom(w, h.analyticsService)
+	utils.JSONResponse(w, referrers, http.StatusOK)
+	log.Println("[Analytics] Referrer stats sent")
+}
+
+// GetUTMStats returns the top utm source/medium/campaign combinations across all URLs
+func (h *AnalyticsHandler) GetUTMStats(w http.ResponseWriter, r *http.Request) {
+	log.Println("[Analytics] GetUTMStats request received")
+	period, limit := reportParams(r)
+
+	combos, err := h.analyticsService.GetTopUTM(nil, period, limit)
+	if err != nil {
+		log.Println("[Analytics] Failed to get UTM stats:", err)
+		writeReportError(w, err, "Failed to get UTM stats")
+
+		return
+	}
+
+	setRawClicksFrom(w, h.analyticsService)
+	utils.JSONResponse(w, combos, http.StatusOK)
+	log.Println("[Analytics] UTM stats sent")
+}
+
+// reportParams reads the optional period (hour, day, month, year, all time if empty) and
+// limit (default 10, at most 100) query params of a top-N report
+func reportParams(r *http.Request) (period string, limit int) {
+	period = r.URL.Query().Get("period")
+	limit = defaultReportLimit
+
+	if parsedLimit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsedLimit > 0 {
+		limit = parsedLimit
+	}
+	if limit > maxReportLimit {
+		limit = maxReportLimit
+	}
+
+	return period, limit
+}
+
+// rawClicksFromHeader is set on reports read from raw clicks once older clicks are pruned, their
+// counts only cover the clicks since
+const rawClicksFromHeader = "X-Raw-Clicks-From"
+
+// setRawClicksFrom sets rawClicksFromHeader if raw clicks have been pruned
+func setRawClicksFrom(w http.ResponseWriter, analyticsService *services.AnalyticsService) {
+	from, err := analyticsService.RawClicksFrom()
+	if err != nil {
+		log.Println("[Analytics] Failed to get retention cutoff:", err)
+		return
+	}
+	if !from.IsZero() {
+		w.Header().Set(rawClicksFromHeader, from.Format(time.RFC3339))
+	}
+}
+
+// writeReportError maps report errors to a response, bad periods are the caller's fault
+func writeReportError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, services.ErrInvalidPeriod) {
+		utils.JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	utils.JSONError(w, message, http.StatusInternalServerError)
+}
+
+// StreamClicks pushes every click to an admin as Server-Sent Events while they stay connected
+func (h *AnalyticsHandler) StreamClicks(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	log.Printf("[Analytics] StreamClicks request received from user %d\n", user.ID)
+	h.streamClicks(w, r, nil)
+}
+
+// StreamOwnClicks pushes clicks on the authenticated user's links as Server-Sent Events
+func (h *AnalyticsHandler) StreamOwnClicks(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	log.Printf("[Analytics] StreamOwnClicks request received from user %d\n", user.ID)
+	h.streamClicks(w, r, &user.ID)
+}
+
+// streamClicks writes each click as a "click" event with a JSON payload, and a comment line every
+// streamHeartbeat so proxies keep the connection open and dead clients are noticed
+func (h *AnalyticsHandler) streamClicks(w http.ResponseWriter, r *http.Request, ownerID *int) {
+	rc := http.NewResponseController(w)
+	// the server's write timeout would cut the stream off, heartbeats detect dead clients instead
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Println("[Analytics] Streaming unsupported:", err)
+		utils.JSONError(w, "Streaming unsupported", http.StatusInternalServerError)
+
+		return
+	}
+
+	sub, err := h.analyticsService.SubscribeClicks(ownerID)
+	if err != nil {
+		log.Println("[Analytics] Failed to subscribe to clicks:", err)
+		utils.JSONError(w, "Click stream unavailable, try again later", http.StatusServiceUnavailable)
+
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // stop nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds())
+	rc.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-sub.Events():
+			if !ok {
+				// evicted for falling behind, or shutting down. Clients reconnect after the retry delay
+				return
+			}
+			payload, err := json.Marshal(event)
+			if err != nil {
+				log.Println("[Analytics] Failed to encode click event:", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: click\ndata: %s\n\n", payload); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// GetTimeframeStats fetches analytics for a shorthand period (hour, day, month, year) up to now,
+// optionally bucketed in the tz query param's time zone
 func (h *AnalyticsHandler) GetTimeframeStats(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	period := vars["period"]
 	log.Println("[Analytics] GetTimeframeStats request received for period:", period)
 
-	validPeriods := map[string]bool{"hour": true, "day": true, "month": true, "year": true}
-	if !validPeriods[period] {
-		log.Println("[Analytics] Invalid period:", period)
-		utils.JSONError(w, "Invalid period. Use: hour, day, month, year", http.StatusBadRequest)
+	stats, err := h.analyticsService.GetTimeframeStats(period, r.URL.Query().Get("tz"))
+	if err != nil {
+		log.Println("[Analytics] Failed to get timeframe stats:", err)
+		writeTimeframeError(w, err)
 
 		return
 	}
 
-	stats, err := h.analyticsService.GetTimeframeStats(period)
+	utils.JSONResponse(w, stats, http.StatusOK)
+	log.Println("[Analytics] Timeframe stats sent for period:", period)
+}
+
+// GetTimeframe fetches analytics for an explicit range: from and to as RFC 3339 (default the
+// last 30 days), tz as an IANA time zone and bucket as hour, day, week or month
+func (h *AnalyticsHandler) GetTimeframe(w http.ResponseWriter, r *http.Request) {
+	log.Println("[Analytics] GetTimeframe request received")
+
+	query := services.TimeframeQuery{
+		TimeZone: r.URL.Query().Get("tz"),
+		Bucket:   r.URL.Query().Get("bucket"),
+	}
+	for param, dest := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			utils.JSONError(w, param+" must be an RFC 3339 timestamp", http.StatusBadRequest)
+
+			return
+		}
+		*dest = parsed
+	}
+
+	stats, err := h.analyticsService.GetTimeframe(query)
 	if err != nil {
 		log.Println("[Analytics] Failed to get timeframe stats:", err)
-		utils.JSONError(w, "Failed to get timeframe stats", http.StatusInternalServerError)
+		writeTimeframeError(w, err)
 
 		return
 	}
 
 	utils.JSONResponse(w, stats, http.StatusOK)
-	log.Println("[Analytics] Timeframe stats sent for period:", period)
+	log.Println("[Analytics] Timeframe stats sent")
+}
+
+// writeTimeframeError maps timeframe errors to a response, bad periods and ranges are the caller's fault
+func writeTimeframeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrInvalidPeriod) || errors.Is(err, services.ErrInvalidTimeframe) {
+		utils.JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	utils.JSONError(w, "Failed to get timeframe stats", http.StatusInternalServerError)
 }
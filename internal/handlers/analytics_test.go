@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"minify/internal/middleware"
+	"minify/internal/models"
+	"minify/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const testAdminID = 99
+
+// newStreamServer serves the click streams behind the same middleware as main's routes
+func newStreamServer(t *testing.T) (*services.ClickStream, *httptest.Server) {
+	stream := services.NewClickStream(8, 10)
+	h := NewAnalyticsHandler(services.NewAnalyticsService(nil, nil, stream, false))
+
+	routes := http.NewServeMux()
+	routes.Handle("/stream", middleware.RequireAdmin([]int{testAdminID})(http.HandlerFunc(h.StreamClicks)))
+	routes.Handle("/stream/mine", middleware.RequireAuth(http.HandlerFunc(h.StreamOwnClicks)))
+	server := httptest.NewServer(middleware.Authenticate(testJWTSecret)(routes))
+	t.Cleanup(func() {
+		stream.Close()
+		server.Close()
+	})
+
+	return stream, server
+}
+
+// openStream connects to a stream as userID, or anonymously if it's 0, and returns the response
+func openStream(t *testing.T, url string, userID int) *http.Response {
+	t.Helper()
+	r, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if userID != 0 {
+		r = asUser(t, r, userID)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+// readClicks waits for the stream to open, publishes the events and returns the short codes of the
+// first n clicks streamed back
+func readClicks(t *testing.T, resp *http.Response, stream *services.ClickStream, events []models.ClickEvent, n int) []string {
+	t.Helper()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	lines := bufio.NewScanner(resp.Body)
+	if !lines.Scan() || !strings.HasPrefix(lines.Text(), "retry:") {
+		t.Fatalf("Expected the stream to open with a retry delay, got %q", lines.Text())
+	}
+
+	for _, event := range events {
+		stream.Publish(event)
+	}
+
+	var codes []string
+	for len(codes) < n && lines.Scan() {
+		data, ok := strings.CutPrefix(lines.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		code, _, _ := strings.Cut(strings.TrimPrefix(data, `{"short_code":"`), `"`)
+		codes = append(codes, code)
+	}
+	if len(codes) < n {
+		t.Fatalf("Stream ended after %v: %v", codes, lines.Err())
+	}
+
+	return codes
+}
+
+func clickEvents() []models.ClickEvent {
+	other, own := 2, 1
+
+	return []models.ClickEvent{
+		{ShortCode: "theirs1", Timestamp: time.Now(), OwnerID: &other},
+		{ShortCode: "anon123", Timestamp: time.Now()},
+		{ShortCode: "mine123", Timestamp: time.Now(), OwnerID: &own},
+	}
+}
+
+func TestStreamClicksAuth(t *testing.T) {
+	_, server := newStreamServer(t)
+
+	cases := []struct {
+		name   string
+		path   string
+		userID int
+		want   int
+	}{
+		{"anonymous own stream", "/stream/mine", 0, http.StatusUnauthorized},
+		{"anonymous global stream", "/stream", 0, http.StatusUnauthorized},
+		{"non-admin global stream", "/stream", 1, http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		if resp := openStream(t, server.URL+c.path, c.userID); resp.StatusCode != c.want {
+			t.Errorf("%s: expected %d, got %d", c.name, c.want, resp.StatusCode)
+		}
+	}
+}
+
+func TestStreamOwnClicks(t *testing.T) {
+	stream, server := newStreamServer(t)
+
+	t.Log("Clicks on other users' and anonymous links aren't streamed")
+	codes := readClicks(t, openStream(t, server.URL+"/stream/mine", 1), stream, clickEvents(), 1)
+	if codes[0] != "mine123" {
+		t.Fatalf("Expected only the user's own clicks, got %v", codes)
+	}
+}
+
+func TestStreamClicks(t *testing.T) {
+	stream, server := newStreamServer(t)
+
+	t.Log("Admins see every click")
+	codes := readClicks(t, openStream(t, server.URL+"/stream", testAdminID), stream, clickEvents(), 3)
+	if strings.Join(codes, ",") != "theirs1,anon123,mine123" {
+		t.Fatalf("Expected every click in order, got %v", codes)
+	}
+}
+
+func TestReportsSetRawClicksFrom(t *testing.T) {
+	db, mock := newMockDB(t)
+	h := NewAnalyticsHandler(services.NewAnalyticsService(db, nil, nil, false))
+	pruned := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Log("No header until raw clicks are pruned")
+	mock.ExpectQuery(`FROM clicks`).WillReturnRows(sqlmock.NewRows([]string{"country", "count"}).AddRow("DE", 3))
+	mock.ExpectQuery(`SELECT pruned_before FROM rollup_state`).WillReturnRows(sqlmock.NewRows([]string{"pruned_before"}))
+	w := serve(h.GetCountryStats, httptest.NewRequest(http.MethodGet, "/api/v1/analytics/countries", nil), false)
+	if w.Code != http.StatusOK || w.Header().Get(rawClicksFromHeader) != "" {
+		t.Fatalf("Expected no cutoff header, got %d %q", w.Code, w.Header().Get(rawClicksFromHeader))
+	}
+
+	t.Log("Then the report says where its clicks start")
+	mock.ExpectQuery(`FROM clicks`).WillReturnRows(sqlmock.NewRows([]string{"country", "count"}).AddRow("DE", 3))
+	mock.ExpectQuery(`SELECT pruned_before FROM rollup_state`).WillReturnRows(sqlmock.NewRows([]string{"pruned_before"}).AddRow(pruned))
+	w = serve(h.GetCountryStats, httptest.NewRequest(http.MethodGet, "/api/v1/analytics/countries", nil), false)
+	if got := w.Header().Get(rawClicksFromHeader); got != pruned.Format(time.RFC3339) {
+		t.Fatalf("Expected the cutoff %s in the header, got %q", pruned.Format(time.RFC3339), got)
+	}
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"minify/internal/limiter"
+	"minify/internal/middleware"
+	"minify/internal/models"
+	"minify/internal/utils"
+)
+
+const (
+	maxBulkItems     = 1000
+	maxBulkBodyBytes = 5 << 20 // 5 MB
+)
+
+// BulkMinifyURLs shortens a batch of URLs sent as a JSON array or a CSV upload (url[,alias] per row),
+// returning a result for each item. Each item is charged to the account's link budget, the same one
+// single creates draw on
+func (h *URLHandler) BulkMinifyURLs(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	log.Println("[BulkMinifyURLs] Request received from user:", user.ID)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBulkBodyBytes)
+	items, err := parseBulkItems(r)
+	if err != nil {
+		log.Println("[BulkMinifyURLs] Failed to parse request:", err)
+		utils.JSONError(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if len(items) == 0 {
+		utils.JSONError(w, "No URLs provided", http.StatusBadRequest)
+		return
+	}
+	if len(items) > maxBulkItems {
+		utils.JSONError(w, fmt.Sprintf("Too many URLs - at most %d per request", maxBulkItems), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	key := linkBudgetKey(user.ID)
+	if !h.limiter.AllowN(key, limiter.Rates.Links, float64(len(items))) {
+		log.Printf("[BulkMinifyURLs] Rate limit exceed for key %s (%d items)", key, len(items))
+		utils.JSONError(w, "Rate limit exceeded - please try again later.", http.StatusTooManyRequests)
+
+		return
+	}
+
+	results := h.urlService.BulkMinify(items, &user.ID)
+
+	baseURL := utils.GetBaseURL(r)
+	response := models.BulkMinifyResponse{Results: results}
+	for i := range results {
+		if results[i].Error != "" {
+			response.Failed++
+			continue
+		}
+		results[i].ShortURL = baseURL + "/" + results[i].ShortCode
+		response.Created++
+	}
+
+	utils.JSONResponse(w, response, http.StatusOK)
+	log.Printf("[BulkMinifyURLs] %d created, %d failed\n", response.Created, response.Failed)
+}
+
+// parseBulkItems reads the bulk request body based on its content type
+func parseBulkItems(r *http.Request) ([]models.MinifyRequest, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "text/csv":
+		return parseBulkCSV(r.Body)
+	case "multipart/form-data":
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, errors.New("csv upload must be sent in the 'file' field")
+		}
+		defer file.Close()
+
+		return parseBulkCSV(file)
+	default:
+		var items []models.MinifyRequest
+		if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+			return nil, errors.New("invalid request body - expected a JSON array of URLs")
+		}
+
+		return items, nil
+	}
+}
+
+// parseBulkCSV reads url[,alias] rows, skipping an optional header row
+func parseBulkCSV(body io.Reader) ([]models.MinifyRequest, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var items []models.MinifyRequest
+	for line := 0; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+
+		if line == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "url") {
+			continue
+		}
+
+		item := models.MinifyRequest{URL: strings.TrimSpace(record[0])}
+		if len(record) > 1 {
+			item.Alias = strings.TrimSpace(record[1])
+		}
+		items = append(items, item)
+
+		if len(items) > maxBulkItems {
+			break
+		}
+	}
+
+	return items, nil
+}
+
+// linkBudgetKey is the limiter key of the links an account can create, across single and bulk creates
+func linkBudgetKey(userID int) string {
+	return fmt.Sprintf("links:user:%d", userID)
+}
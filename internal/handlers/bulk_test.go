@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"minify/internal/config"
+	"minify/internal/limiter"
+	"minify/internal/models"
+	"minify/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func newBulkHandler(t *testing.T) (*URLHandler, sqlmock.Sqlmock) {
+	db, mock := newMockDB(t)
+	urlService := services.NewURLService(db, nil, nil)
+
+	return NewURLHandler(urlService, nil, limiter.NewLimiter(100), nil, &config.Config{JWTSecret: testJWTSecret}), mock
+}
+
+func bulkRequest(t *testing.T, items []models.MinifyRequest) *http.Request {
+	body, err := json.Marshal(items)
+	if err != nil {
+		t.Fatalf("Failed to encode items: %v", err)
+	}
+
+	return httptest.NewRequest(http.MethodPost, "/api/v1/minify/bulk", strings.NewReader(string(body)))
+}
+
+func invalidItems(n int) []models.MinifyRequest {
+	items := make([]models.MinifyRequest, n)
+	for i := range items {
+		items[i] = models.MinifyRequest{URL: "not a url"}
+	}
+
+	return items
+}
+
+func TestBulkMinifyURLsRequiresAuth(t *testing.T) {
+	h, _ := newBulkHandler(t)
+
+	w := serve(h.BulkMinifyURLs, bulkRequest(t, invalidItems(1)), true)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for an anonymous bulk request, got %d", w.Code)
+	}
+}
+
+func TestBulkMinifyURLsPartialFailure(t *testing.T) {
+	h, mock := newBulkHandler(t)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`^SAVEPOINT bulk_item$`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO urls`).WithArgs("taken", sqlmock.AnyArg(), sqlmock.AnyArg(), 1, sqlmock.AnyArg(),
+		sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+		sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"})) // conflict, the alias is taken
+	mock.ExpectExec(`^ROLLBACK TO SAVEPOINT bulk_item$`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`^SAVEPOINT bulk_item$`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO urls`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(7, now, now))
+	mock.ExpectExec(`INSERT INTO webhook_deliveries`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	items := []models.MinifyRequest{
+		{URL: "not a url"},
+		{URL: "https://example.com/a", Alias: "taken"},
+		{URL: "https://example.com/b", Alias: "fresh-one"},
+	}
+	w := serve(h.BulkMinifyURLs, asUser(t, bulkRequest(t, items), 1), true)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 with per-item results, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp models.BulkMinifyResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Created != 1 || resp.Failed != 2 || len(resp.Results) != 3 {
+		t.Fatalf("Expected 1 created and 2 failed, got %+v", resp)
+	}
+	if resp.Results[0].Error != services.ErrInvalidURL.Error() || resp.Results[1].Error != services.ErrAliasTaken.Error() {
+		t.Fatalf("Unexpected item errors: %+v", resp.Results[:2])
+	}
+	if created := resp.Results[2]; created.Error != "" || created.ShortCode != "fresh-one" || !strings.HasSuffix(created.ShortURL, "/fresh-one") {
+		t.Fatalf("Unexpected created item: %+v", created)
+	}
+}
+
+func TestBulkMinifyURLsLimits(t *testing.T) {
+	h, mock := newBulkHandler(t)
+
+	t.Log("More than maxBulkItems is rejected outright")
+	w := serve(h.BulkMinifyURLs, asUser(t, bulkRequest(t, invalidItems(maxBulkItems+1)), 1), true)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413, got %d", w.Code)
+	}
+
+	t.Log("A full request spends the account's link budget")
+	for i := 0; i < maxBulkItems/100; i++ { // a transaction per batch of 100
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+	}
+	w = serve(h.BulkMinifyURLs, asUser(t, bulkRequest(t, invalidItems(maxBulkItems)), 1), true)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the first full request to be allowed, got %d", w.Code)
+	}
+	w = serve(h.BulkMinifyURLs, asUser(t, bulkRequest(t, invalidItems(1)), 1), true)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the budget to be spent, got %d", w.Code)
+	}
+
+	t.Log("Single creates draw on the same budget")
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/minify", strings.NewReader(`{"url": "https://example.com"}`))
+	if w := serve(h.MinifyURL, asUser(t, r, 1), false); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected a single create to be limited too, got %d", w.Code)
+	}
+	for i := 0; i < int(limiter.Rates.Authenticated.Capacity); i++ {
+		if !h.limiter.Allow("user:1", limiter.Rates.Authenticated) {
+			t.Fatalf("Expected the rejected create not to spend the account's request rate, ran out after %d", i)
+		}
+	}
+
+	t.Log("Other accounts have their own budget")
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	if w := serve(h.BulkMinifyURLs, asUser(t, bulkRequest(t, invalidItems(1)), 2), true); w.Code != http.StatusOK {
+		t.Fatalf("Expected another account to be allowed, got %d", w.Code)
+	}
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"minify/internal/export"
+	"minify/internal/middleware"
+	"minify/internal/models"
+	"minify/internal/services"
+	"minify/internal/utils"
+)
+
+// exportWriteTimeout bounds each write of an export, rather than the whole response, so long exports
+// aren't cut off by the server's write timeout while a stalled client still is
+const exportWriteTimeout = 30 * time.Second
+
+type ExportHandler struct {
+	exportService *services.ExportService // streams links and clicks out of the database
+}
+
+func NewExportHandler(exportService *services.ExportService) *ExportHandler {
+	return &ExportHandler{exportService: exportService}
+}
+
+// ExportLinks streams the authenticated user's links as CSV, NDJSON or parquet
+func (h *ExportHandler) ExportLinks(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	log.Printf("[ExportLinks] User %d exporting links\n", user.ID)
+
+	streamExport(w, r, "links", func(ctx context.Context, q services.ExportQuery, emit func(row models.LinkExport) error) error {
+		return h.exportService.ExportLinks(ctx, user.ID, q, emit)
+	})
+}
+
+// ExportClicks streams the clicks on the authenticated user's links as CSV, NDJSON or parquet
+func (h *ExportHandler) ExportClicks(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	log.Printf("[ExportClicks] User %d exporting clicks\n", user.ID)
+
+	streamExport(w, r, "clicks", func(ctx context.Context, q services.ExportQuery, emit func(row models.ClickExport) error) error {
+		return h.exportService.ExportClicks(ctx, user.ID, q, emit)
+	})
+}
+
+// streamExport reads the export's filters and format from the request (from, to, links and format
+// params, or the Accept header) and streams the rows produced by run as a download
+func streamExport[T any](w http.ResponseWriter, r *http.Request, name string,
+	run func(ctx context.Context, q services.ExportQuery, emit func(row T) error) error) {
+	format, err := export.Negotiate(r.URL.Query().Get("format"), r.Header.Get("Accept"))
+	if err != nil {
+		utils.JSONError(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	q, err := exportQuery(r)
+	if err != nil {
+		utils.JSONError(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	out := &exportResponse{
+		w:           w,
+		rc:          http.NewResponseController(w),
+		contentType: format.ContentType(),
+		filename:    fmt.Sprintf("minify-%s-%s.%s", name, time.Now().UTC().Format("20060102"), format.Extension()),
+	}
+	writer, err := export.NewWriter[T](format, out)
+	if err == nil {
+		err = run(r.Context(), q, writer.Write)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err == nil {
+		return
+	}
+
+	if !out.started {
+		log.Printf("[Export] Failed to export %s: %v\n", name, err)
+		if errors.Is(err, services.ErrInvalidExportQuery) {
+			utils.JSONError(w, err.Error(), http.StatusBadRequest)
+		} else {
+			utils.JSONError(w, "Failed to export "+name, http.StatusInternalServerError)
+		}
+
+		return
+	}
+	if r.Context().Err() == nil {
+		log.Printf("[Export] Export of %s failed part way: %v\n", name, err)
+	}
+	// the status is already sent, aborting the connection is the only way to tell the client the
+	// file is incomplete instead of ending it as if it were whole
+	panic(http.ErrAbortHandler)
+}
+
+// exportQuery parses the from and to RFC 3339 timestamps and the comma separated links filter
+func exportQuery(r *http.Request) (services.ExportQuery, error) {
+	var q services.ExportQuery
+	for param, dest := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return q, fmt.Errorf("%s must be an RFC 3339 timestamp", param)
+		}
+		*dest = t
+	}
+
+	for _, value := range r.URL.Query()["links"] {
+		for _, code := range strings.Split(value, ",") {
+			if code = strings.TrimSpace(code); code != "" {
+				q.ShortCodes = append(q.ShortCodes, code)
+			}
+		}
+	}
+
+	return q, q.Validate()
+}
+
+// exportResponse sends the download headers on the first write, so failures before any output can
+// still be reported as JSON errors, and pushes the write deadline forward on each write
+type exportResponse struct {
+	w           http.ResponseWriter
+	rc          *http.ResponseController
+	contentType string
+	filename    string
+	started     bool
+}
+
+func (e *exportResponse) Write(p []byte) (int, error) {
+	if !e.started {
+		e.started = true
+		e.w.Header().Set("Content-Type", e.contentType)
+		e.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", e.filename))
+		e.w.Header().Set("Cache-Control", "no-store")
+		e.w.Header().Set("X-Accel-Buffering", "no")
+		e.w.WriteHeader(http.StatusOK)
+	}
+	if err := e.rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, err
+	}
+
+	return e.w.Write(p)
+}
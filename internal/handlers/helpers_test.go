@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"minify/internal/middleware"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/dgrijalva/jwt-go"
+)
+
+const testJWTSecret = "test-secret"
+
+// newMockDB returns a db whose queries are checked against the expectations set on the mock
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock db: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Unmet db expectations: %v", err)
+		}
+		db.Close()
+	})
+
+	return db, mock
+}
+
+// asUser signs a token for the user like LoginUser does and adds it to the request
+func asUser(t *testing.T, r *http.Request, userID int) *http.Request {
+	t.Helper()
+	claims := jwt.MapClaims{"user_id": userID, "username": fmt.Sprintf("user%d", userID), "exp": time.Now().Add(time.Hour).Unix()}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJWTSecret))
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	return r
+}
+
+// serve runs the request through the api's auth middleware, requiring a user like main's authed
+// routes when authRequired is set
+func serve(h http.HandlerFunc, r *http.Request, authRequired bool) *httptest.ResponseRecorder {
+	var handler http.Handler = h
+	if authRequired {
+		handler = middleware.RequireAuth(handler)
+	}
+	w := httptest.NewRecorder()
+	middleware.Authenticate(testJWTSecret)(handler).ServeHTTP(w, r)
+
+	return w
+}
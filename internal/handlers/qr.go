@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"minify/internal/middleware"
+	"minify/internal/models"
+	"minify/internal/qr"
+	"minify/internal/services"
+	"minify/internal/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// GetQRCode renders a QR code (PNG or SVG) for a public short link
+func (h *URLHandler) GetQRCode(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+	log.Println("[GetQRCode] Short code:", shortCode)
+
+	url, err := h.urlService.GetURLByShortCode(shortCode)
+	if err != nil || !url.Active {
+		http.NotFound(w, r)
+		return
+	}
+
+	h.serveQRCode(w, r, url, "public")
+}
+
+// GetOwnedQRCode renders a QR code for a link owned by the authenticated user
+func (h *URLHandler) GetOwnedQRCode(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+	log.Printf("[GetOwnedQRCode] User %d requested QR code for %s\n", user.ID, shortCode)
+
+	url, err := h.urlService.GetOwnedURL(shortCode, user.ID)
+	if err == nil && url.DeletedAt != nil {
+		err = services.ErrURLNotFound
+	}
+	if err != nil {
+		log.Println("[GetOwnedQRCode] Failed to get URL:", err)
+		writeURLError(w, err)
+
+		return
+	}
+
+	h.serveQRCode(w, r, url, "private")
+}
+
+// serveQRCode renders the short URL of a link with the options from the query string,
+// answering with 304 Not Modified when the client already has the same image
+func (h *URLHandler) serveQRCode(w http.ResponseWriter, r *http.Request, url *models.URL, cacheScope string) {
+	opts, err := qr.ParseOptions(r.URL.Query())
+	if err != nil {
+		utils.JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("format") == "" && strings.Contains(r.Header.Get("Accept"), "image/svg+xml") {
+		opts.Format = qr.FormatSVG
+	}
+
+	// same value MinifyResponse.ShortURL returns
+	content := utils.GetBaseURL(r) + "/" + url.ShortCode
+	etag := qr.ETag(content, opts)
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", cacheScope+", max-age=86400")
+	w.Header().Set("Vary", "Accept")
+
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	image, err := qr.Render(content, opts)
+	if err != nil {
+		log.Println("[QRCode] Failed to render QR code:", err)
+		utils.JSONError(w, "Failed to render QR code", http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", qr.ContentType(opts))
+	w.WriteHeader(http.StatusOK)
+	w.Write(image)
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"minify/internal/limiter"
+	"minify/internal/models"
+	"minify/internal/services"
+	"minify/internal/utils"
+
+	"github.com/gorilla/mux"
+)
+
+const unlockCookieTTL = 15 * time.Minute
+
+var unlockPage = template.Must(template.New("unlock").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta name="robots" content="noindex">
+	<title>Protected link</title>
+	<style>
+		body { font-family: system-ui, sans-serif; display: flex; justify-content: center; margin-top: 15vh; }
+		form { display: flex; flex-direction: column; gap: 0.75rem; width: 18rem; }
+		.error { color: #b00020; }
+	</style>
+</head>
+<body>
+	<form method="POST" action="/{{.ShortCode}}">
+		<h2>This link is password protected</h2>
+		{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+		<input type="password" name="password" placeholder="Password" autofocus required>
+		<button type="submit">Continue</button>
+	</form>
+</body>
+</html>
+`))
+
+// UnlockURL checks the password submitted from the unlock page, sets a short-lived signed
+// cookie on success and sends the visitor back through RedirectURL
+func (h *URLHandler) UnlockURL(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+	// attempts are limited per connection, not per X-Forwarded-For which would give every forged
+	// header a fresh bucket, and per link across all visitors so rotating addresses doesn't help either
+	visitorKey := fmt.Sprintf("unlock:%s:%s", utils.RemoteIP(r), shortCode)
+	linkKey := "unlock:link:" + shortCode
+	log.Println("[UnlockURL] Unlock attempt for:", shortCode)
+
+	if h.limiter.Blocked(visitorKey) || h.limiter.Blocked(linkKey) {
+		log.Printf("[UnlockURL] Rate limit exceeded for %s from %s\n", shortCode, utils.RemoteIP(r))
+		h.renderUnlockPage(w, shortCode, "Too many attempts - please try again later.", http.StatusTooManyRequests)
+
+		return
+	}
+
+	url, err := h.urlService.GetURLByShortCode(shortCode)
+	if err != nil || !url.Active || !url.Protected {
+		http.NotFound(w, r)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+	if !services.CheckURLPassword(url, r.PostFormValue("password")) {
+		log.Println("[UnlockURL] Wrong password for:", shortCode)
+		status := http.StatusUnauthorized
+		visitorAllowed := h.limiter.Allow(visitorKey, limiter.Rates.Unlock)
+		linkAllowed := h.limiter.Allow(linkKey, limiter.Rates.UnlockLink)
+		if !visitorAllowed || !linkAllowed {
+			status = http.StatusTooManyRequests
+		}
+		h.renderUnlockPage(w, shortCode, "Incorrect password.", status)
+
+		return
+	}
+
+	expires := time.Now().Add(unlockCookieTTL)
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookieName(shortCode),
+		Value:    h.signUnlock(url, expires),
+		Path:     "/" + shortCode,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	log.Println("[UnlockURL] Link unlocked:", shortCode)
+	http.Redirect(w, r, "/"+shortCode, http.StatusSeeOther)
+}
+
+// isUnlocked reports whether the request carries a valid, unexpired unlock cookie for the link
+func (h *URLHandler) isUnlocked(r *http.Request, url *models.URL) bool {
+	cookie, err := r.Cookie(unlockCookieName(url.ShortCode))
+	if err != nil {
+		return false
+	}
+
+	expiresStr, _, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return false
+	}
+	expiresUnix, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	expires := time.Unix(expiresUnix, 0)
+	if time.Now().After(expires) {
+		return false
+	}
+
+	return hmac.Equal([]byte(cookie.Value), []byte(h.signUnlock(url, expires)))
+}
+
+// signUnlock builds an "<expiry>.<signature>" cookie value. The password hash is part of the
+// signed data, so changing a link's password invalidates existing cookies
+func (h *URLHandler) signUnlock(url *models.URL, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(h.cfg.JWTSecret))
+	mac.Write([]byte(url.ShortCode + "|" + exp + "|" + url.PasswordHash))
+
+	return exp + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (h *URLHandler) renderUnlockPage(w http.ResponseWriter, shortCode, errMsg string, status int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	data := struct{ ShortCode, Error string }{shortCode, errMsg}
+	if err := unlockPage.Execute(w, data); err != nil {
+		log.Println("[UnlockURL] Failed to render unlock page:", err)
+	}
+}
+
+func unlockCookieName(shortCode string) string {
+	return "minify_unlock_" + shortCode
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"minify/internal/config"
+	"minify/internal/limiter"
+	"minify/internal/models"
+	"minify/internal/services"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newUnlockHandler returns a handler serving one protected link from the cache, so no db is needed
+func newUnlockHandler(t *testing.T, password string) (*URLHandler, *models.URL) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	link := &models.URL{ID: 1, ShortCode: "secret1", OriginalURL: "https://example.com", Active: true,
+		Protected: true, PasswordHash: string(hash)}
+
+	cache := services.NewURLCache(10, time.Hour, time.Minute, nil)
+	cache.Get(link.ShortCode, func() (*models.URL, error) { return link, nil })
+	urlService := services.NewURLService(nil, nil, cache)
+
+	return NewURLHandler(urlService, nil, limiter.NewLimiter(100), nil, &config.Config{JWTSecret: "test-secret"}), link
+}
+
+func unlockRequest(shortCode, password, remoteAddr string) *http.Request {
+	form := url.Values{"password": {password}}
+	r := httptest.NewRequest(http.MethodPost, "/"+shortCode, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = remoteAddr
+
+	return mux.SetURLVars(r, map[string]string{"shortCode": shortCode})
+}
+
+func unlock(h *URLHandler, r *http.Request) int {
+	w := httptest.NewRecorder()
+	h.UnlockURL(w, r)
+
+	return w.Code
+}
+
+func TestUnlockCookie(t *testing.T) {
+	h, link := newUnlockHandler(t, "hunter2")
+	withCookie := func(value string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/"+link.ShortCode, nil)
+		r.AddCookie(&http.Cookie{Name: unlockCookieName(link.ShortCode), Value: value})
+
+		return r
+	}
+
+	value := h.signUnlock(link, time.Now().Add(time.Minute))
+	if !h.isUnlocked(withCookie(value), link) {
+		t.Fatal("Expected a signed cookie to unlock the link")
+	}
+
+	t.Log("Tampered, extended, expired and malformed cookies don't unlock it")
+	exp, sig, _ := strings.Cut(value, ".")
+	for name, value := range map[string]string{
+		"tampered":  exp + "." + strings.Repeat("A", len(sig)),
+		"extended":  fmt.Sprintf("%d.%s", time.Now().Add(time.Hour).Unix(), sig),
+		"expired":   h.signUnlock(link, time.Now().Add(-time.Second)),
+		"malformed": "not-a-cookie",
+	} {
+		if h.isUnlocked(withCookie(value), link) {
+			t.Errorf("Expected a %s cookie not to unlock the link", name)
+		}
+	}
+	if h.isUnlocked(httptest.NewRequest(http.MethodGet, "/"+link.ShortCode, nil), link) {
+		t.Fatal("Expected a request without the cookie not to unlock the link")
+	}
+
+	t.Log("Changing the password invalidates existing cookies")
+	changed := *link
+	changed.PasswordHash = "$2a$04$different"
+	if h.isUnlocked(withCookie(value), &changed) {
+		t.Fatal("Expected a cookie signed for the old password to be rejected")
+	}
+}
+
+func TestUnlockURL(t *testing.T) {
+	h, link := newUnlockHandler(t, "hunter2")
+
+	if code := unlock(h, unlockRequest(link.ShortCode, "wrong", "203.0.113.1:5000")); code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for a wrong password, got %d", code)
+	}
+
+	w := httptest.NewRecorder()
+	h.UnlockURL(w, unlockRequest(link.ShortCode, "hunter2", "203.0.113.1:5000"))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/"+link.ShortCode {
+		t.Fatalf("Expected a redirect back to the link, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].Path != "/"+link.ShortCode {
+		t.Fatalf("Expected one HttpOnly cookie scoped to the link, got %+v", cookies)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/"+link.ShortCode, nil)
+	r.AddCookie(cookies[0])
+	if !h.isUnlocked(r, link) {
+		t.Fatal("Expected the issued cookie to unlock the link")
+	}
+}
+
+func TestUnlockURLIgnoresForwardedFor(t *testing.T) {
+	h, link := newUnlockHandler(t, "hunter2")
+
+	t.Log("A new X-Forwarded-For on every attempt doesn't reset the visitor's limit")
+	attempts := int(limiter.Rates.Unlock.Capacity) + 1
+	code := 0
+	for i := 0; i < attempts; i++ {
+		r := unlockRequest(link.ShortCode, "wrong", "203.0.113.1:5000")
+		r.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		code = unlock(h, r)
+	}
+	if code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the visitor to be limited after %d attempts, got %d", attempts, code)
+	}
+	if code := unlock(h, unlockRequest(link.ShortCode, "hunter2", "203.0.113.1:5000")); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the visitor to stay blocked even with the right password, got %d", code)
+	}
+
+	t.Log("Other visitors are still let in")
+	if code := unlock(h, unlockRequest(link.ShortCode, "hunter2", "203.0.113.2:5000")); code != http.StatusSeeOther {
+		t.Fatalf("Expected another visitor to unlock the link, got %d", code)
+	}
+}
+
+func TestUnlockURLLinkBudget(t *testing.T) {
+	h, link := newUnlockHandler(t, "hunter2")
+
+	t.Log("Failed attempts from many addresses share the link's budget")
+	for i := 0; i < int(limiter.Rates.UnlockLink.Capacity); i++ {
+		if code := unlock(h, unlockRequest(link.ShortCode, "wrong", fmt.Sprintf("198.51.100.%d:5000", i))); code != http.StatusUnauthorized {
+			t.Fatalf("Expected attempt %d to be allowed, got %d", i+1, code)
+		}
+	}
+
+	if code := unlock(h, unlockRequest(link.ShortCode, "wrong", "198.51.100.250:5000")); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the link's budget to run out, got %d", code)
+	}
+	if code := unlock(h, unlockRequest(link.ShortCode, "hunter2", "198.51.100.251:5000")); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the link to stay blocked during the cooldown, got %d", code)
+	}
+}
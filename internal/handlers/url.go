@@ -2,14 +2,20 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
+	"time"
 
+	"minify/internal/config"
+	"minify/internal/geo"
 	"minify/internal/limiter"
+	"minify/internal/metrics"
+	"minify/internal/middleware"
 	"minify/internal/models"
 	"minify/internal/services"
+	"minify/internal/useragent"
 	"minify/internal/utils"
 
 	"github.com/gorilla/mux"
@@ -19,13 +25,17 @@ type URLHandler struct {
 	urlService       *services.URLService
 	analyticsService *services.AnalyticsService
 	limiter          *limiter.Limiter
+	geo              *geo.Resolver
+	cfg              *config.Config
 }
 
-func NewURLHandler(urlService *services.URLService, analyticsService *services.AnalyticsService, limiter *limiter.Limiter) *URLHandler {
+func NewURLHandler(urlService *services.URLService, analyticsService *services.AnalyticsService, limiter *limiter.Limiter, geoResolver *geo.Resolver, cfg *config.Config) *URLHandler {
 	return &URLHandler{
 		urlService:       urlService,       // handles db operations for URLs
 		analyticsService: analyticsService, // records clicks and analytics
 		limiter:          limiter,          // limits requests for each user
+		geo:              geoResolver,      // resolves visitor IPs to countries, nil if not configured
+		cfg:              cfg,              // expired link and restore window settings
 	}
 }
 
@@ -45,21 +55,24 @@ func (h *URLHandler) MinifyURL(w http.ResponseWriter, r *http.Request) {
 
 	ip := utils.GetClientIP(r)
 	var (
-		key string
-		cfg limiter.RateConfig
+		key    string
+		cfg    limiter.RateConfig
+		userID *int
 	)
 
 	// set rate limit based on whether requester is logged into an account
-	if req.UserID != nil {
-		key = fmt.Sprintf("user:%d", *req.UserID)
+	if user, ok := middleware.UserFromContext(r.Context()); ok {
+		userID = &user.ID
+		key = fmt.Sprintf("user:%d", user.ID)
 		cfg = limiter.Rates.Authenticated
 	} else {
 		key = fmt.Sprintf("ip:%s", ip)
 		cfg = limiter.Rates.Anonymous
 	}
 
-	// enforce rate limit
-	if !h.limiter.Allow(key, cfg) {
+	// enforce rate limit, accounts also draw on the link budget they share with bulk creates. The budget
+	// is checked first so a create it rejects doesn't also spend the account's request rate
+	if (userID != nil && !h.limiter.Allow(linkBudgetKey(*userID), limiter.Rates.Links)) || !h.limiter.Allow(key, cfg) {
 		log.Printf("[MinifyURL] Rate limit exceed for key %s", key)
 		utils.JSONError(w, "Rate limit exceeded - please try again later.", http.StatusTooManyRequests)
 
@@ -74,32 +87,82 @@ func (h *URLHandler) MinifyURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// custom aliases are only available to registered users
+	if req.Alias != "" && userID == nil {
+		log.Println("[MinifyURL] Anonymous alias request rejected")
+		utils.JSONError(w, "Custom aliases require an account", http.StatusUnauthorized)
+
+		return
+	}
+
 	// shorten (minify) url
-	url, err := h.urlService.MinifyURL(req.URL, req.UserID)
+	opts := services.MinifyOptions{
+		Alias:     req.Alias,
+		ExpiresAt: req.ExpiresAt,
+		MaxClicks: req.MaxClicks,
+		Password:  req.Password,
+		Dedup:     services.DedupEnabled(req.Dedup),
+
+		RedirectType: req.RedirectType,
+		ForwardQuery: req.ForwardQuery,
+		ForwardPath:  req.ForwardPath,
+
+		TargetingRules:   req.TargetingRules,
+		CountryOverrides: req.CountryOverrides,
+
+		Destinations:   req.Destinations,
+		StickyVariants: req.StickyVariants,
+	}
+	url, reused, err := h.urlService.MinifyURL(req.URL, userID, opts)
 	if err != nil {
 		log.Println("[MinifyURL] Service failed:", err)
-		utils.JSONError(w, "Failed to minify URL", http.StatusInternalServerError)
+		switch {
+		case errors.Is(err, services.ErrAliasInvalid),
+			errors.Is(err, services.ErrInvalidURL),
+			errors.Is(err, services.ErrInvalidExpiry),
+			errors.Is(err, services.ErrInvalidMaxClicks),
+			errors.Is(err, services.ErrInvalidRedirectType),
+			errors.Is(err, services.ErrInvalidForwardQuery),
+			errors.Is(err, services.ErrInvalidTargeting),
+			errors.Is(err, services.ErrInvalidDestinations):
+			utils.JSONError(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, services.ErrAliasReserved), errors.Is(err, services.ErrAliasTaken):
+			utils.JSONError(w, err.Error(), http.StatusConflict)
+		default:
+			utils.JSONError(w, "Failed to minify URL", http.StatusInternalServerError)
+		}
 
 		return
 	}
-	log.Println("[MinifyURL] Short URL created:", url.ShortCode)
+	log.Printf("[MinifyURL] Short URL %s (reused: %t)\n", url.ShortCode, reused)
 
 	baseURL := utils.GetBaseURL(r)
 	response := models.MinifyResponse{
-		ShortURL:    baseURL + "/" + url.ShortCode,
-		OriginalURL: url.OriginalURL,
-		ShortCode:   url.ShortCode,
+		ShortURL:     baseURL + "/" + url.ShortCode,
+		OriginalURL:  url.OriginalURL,
+		ShortCode:    url.ShortCode,
+		ExpiresAt:    url.ExpiresAt,
+		MaxClicks:    url.MaxClicks,
+		Protected:    url.Protected,
+		Reused:       reused,
+		RedirectType: url.RedirectType,
 	}
 
-	utils.JSONResponse(w, response, http.StatusCreated)
+	status := http.StatusCreated
+	if reused {
+		status = http.StatusOK
+	}
+	utils.JSONResponse(w, response, status)
 	log.Println("[MinifyURL] Response sent")
 }
 
 // RedirectURL looks up the original URL by it's short code, increments click count (for metrics),
-// records analytics, and redirects to the original URL
+// records analytics, and redirects to the original URL using the link's redirect status. Links that
+// opt in also get the incoming query string and any path after the short code forwarded
 func (h *URLHandler) RedirectURL(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	shortCode := vars["shortCode"]
+	extraPath := vars["path"]
 	log.Println("[RedirectURL] Short code:", shortCode)
 
 	if shortCode == "" {
@@ -117,33 +180,121 @@ func (h *URLHandler) RedirectURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go func() {
-		if err := h.urlService.IncrementClickCount(url.ID); err != nil {
-			log.Println("[RedirectURL] Failed to increment click count:", err)
-		}
-		h.analyticsService.RecordClick(url.ID, r.UserAgent(), utils.GetClientIP(r))
-	}()
+	if !url.Active {
+		log.Println("[RedirectURL] URL disabled:", shortCode)
+		http.NotFound(w, r)
 
-	http.Redirect(w, r, url.OriginalURL, http.StatusFound)
-}
+		return
+	}
 
-// GetUserURLs fetches all URLs associated with the given user ID
-func (h *URLHandler) GetUserURLs(w http.ResponseWriter, r *http.Request) {
-	userIDStr := r.URL.Query().Get("user_id")
-	log.Println("[GetUserURLs] User ID query param:", userIDStr)
+	if services.IsExpired(url, time.Now()) {
+		log.Println("[RedirectURL] URL expired:", shortCode)
+		h.serveExpired(w, r)
 
-	if userIDStr == "" {
-		utils.JSONError(w, "User ID is required", http.StatusBadRequest)
 		return
 	}
 
-	userID, err := strconv.Atoi(userIDStr)
+	// country and device/platform targeting pick the destination before passthrough is applied
+	ip := utils.GetClientIP(r)
+	location := h.geo.Lookup(ip)
+	ua := useragent.Parse(r.UserAgent())
+	target, targeted := services.SelectTarget(url, ua, location.Country)
+
+	// untargeted visitors get the original URL, or one of the link's A/B destinations
+	var variant *models.Destination
+	if !targeted {
+		target = url.OriginalURL
+		if variant = h.pickVariant(w, r, url); variant != nil {
+			target = variant.URL
+		}
+	}
+	destination, err := services.BuildDestination(url, target, extraPath, r.URL.Query())
 	if err != nil {
-		utils.JSONError(w, "Invalid user ID", http.StatusBadRequest)
+		log.Println("[RedirectURL] Failed to build destination:", err)
+		http.NotFound(w, r)
+
+		return
+	}
+
+	// protected links show the unlock form until a valid unlock cookie is presented
+	if url.Protected && !h.isUnlocked(r, url) {
+		log.Println("[RedirectURL] URL locked:", shortCode)
+		h.renderUnlockPage(w, shortCode, "", http.StatusOK)
+
 		return
 	}
 
-	urls, err := h.urlService.GetUserURLs(userID)
+	click := models.Click{
+		URLID:     url.ID,
+		UserAgent: r.UserAgent(),
+		IPAddress: ip,
+		TargetURL: target,
+		Country:   location.Country,
+		Region:    location.Region,
+
+		Browser:        ua.Browser,
+		BrowserVersion: ua.BrowserVersion,
+		OS:             ua.OS,
+		Device:         ua.Device,
+		IsBot:          ua.IsBot,
+		BotName:        ua.Bot,
+
+		Referrer:    utils.ReferrerDomain(r.Referer()),
+		UTMSource:   utils.Truncate(r.URL.Query().Get("utm_source"), 255),
+		UTMMedium:   utils.Truncate(r.URL.Query().Get("utm_medium"), 255),
+		UTMCampaign: utils.Truncate(r.URL.Query().Get("utm_campaign"), 255),
+		UTMTerm:     utils.Truncate(r.URL.Query().Get("utm_term"), 255),
+		UTMContent:  utils.Truncate(r.URL.Query().Get("utm_content"), 255),
+	}
+	if variant != nil {
+		click.VariantID = &variant.ID
+	}
+
+	// links with a click limit claim their click up front so concurrent visitors can't overshoot it.
+	// Uncounted bots don't claim, so link previews can't use up a one-time link
+	if url.MaxClicks != nil && (!ua.IsBot || h.cfg.CountBots) {
+		claimed, err := h.urlService.ClaimClick(url.ID)
+		if err != nil {
+			log.Println("[RedirectURL] Failed to claim click:", err)
+			utils.JSONError(w, "Failed to resolve URL", http.StatusInternalServerError)
+
+			return
+		}
+		if !claimed {
+			log.Println("[RedirectURL] Click limit reached:", shortCode)
+			h.serveExpired(w, r)
+
+			return
+		}
+
+		click.Counted = true
+	}
+	h.analyticsService.RecordClick(url, click)
+	metrics.RecordURLClick()
+
+	http.Redirect(w, r, destination, url.RedirectType)
+}
+
+// serveExpired sends visitors of an expired link to the configured fallback URL, or 410 Gone
+func (h *URLHandler) serveExpired(w http.ResponseWriter, r *http.Request) {
+	if h.cfg.ExpiredLinkURL != "" {
+		http.Redirect(w, r, h.cfg.ExpiredLinkURL, http.StatusFound)
+		return
+	}
+
+	http.Error(w, "This link has expired", http.StatusGone)
+}
+
+// GetUserURLs fetches all URLs owned by the authenticated user
+func (h *URLHandler) GetUserURLs(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		utils.JSONError(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+	log.Println("[GetUserURLs] User ID:", user.ID)
+
+	urls, err := h.urlService.GetUserURLs(user.ID)
 	if err != nil {
 		log.Println("[GetUserURLs] Failed to get URLs:", err)
 		utils.JSONError(w, "Failed to get URLs", http.StatusInternalServerError)
@@ -154,3 +305,252 @@ func (h *URLHandler) GetUserURLs(w http.ResponseWriter, r *http.Request) {
 	utils.JSONResponse(w, urls, http.StatusOK)
 	log.Println("[GetUserURLs] URLs returned:", len(urls))
 }
+
+// GetURL returns a single link owned by the authenticated user
+func (h *URLHandler) GetURL(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+	log.Printf("[GetURL] User %d requested %s\n", user.ID, shortCode)
+
+	url, err := h.urlService.GetOwnedURL(shortCode, user.ID)
+	if err != nil {
+		log.Println("[GetURL] Failed to get URL:", err)
+		writeURLError(w, err)
+
+		return
+	}
+
+	utils.JSONResponse(w, url, http.StatusOK)
+}
+
+// GetURLCountries returns the clicks on a link owned by the authenticated user, grouped by visitor country
+func (h *URLHandler) GetURLCountries(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+	log.Printf("[GetURLCountries] User %d requested %s\n", user.ID, shortCode)
+
+	url, err := h.urlService.GetOwnedURL(shortCode, user.ID)
+	if err != nil {
+		log.Println("[GetURLCountries] Failed to get URL:", err)
+		writeURLError(w, err)
+
+		return
+	}
+
+	countries, err := h.analyticsService.GetClicksByCountry(&url.ID)
+	if err != nil {
+		log.Println("[GetURLCountries] Failed to get country stats:", err)
+		utils.JSONError(w, "Failed to get country stats", http.StatusInternalServerError)
+
+		return
+	}
+
+	setRawClicksFrom(w, h.analyticsService)
+	utils.JSONResponse(w, countries, http.StatusOK)
+}
+
+// GetURLBots returns the bot clicks on a link owned by the authenticated user, grouped by crawler
+func (h *URLHandler) GetURLBots(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+	log.Printf("[GetURLBots] User %d requested %s\n", user.ID, shortCode)
+
+	url, err := h.urlService.GetOwnedURL(shortCode, user.ID)
+	if err != nil {
+		log.Println("[GetURLBots] Failed to get URL:", err)
+		writeURLError(w, err)
+
+		return
+	}
+
+	bots, err := h.analyticsService.GetBotClicks(&url.ID)
+	if err != nil {
+		log.Println("[GetURLBots] Failed to get bot stats:", err)
+		utils.JSONError(w, "Failed to get bot stats", http.StatusInternalServerError)
+
+		return
+	}
+
+	setRawClicksFrom(w, h.analyticsService)
+	utils.JSONResponse(w, bots, http.StatusOK)
+}
+
+// GetURLReferrers returns the top referring domains for a link owned by the authenticated user
+func (h *URLHandler) GetURLReferrers(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+	log.Printf("[GetURLReferrers] User %d requested %s\n", user.ID, shortCode)
+
+	url, err := h.urlService.GetOwnedURL(shortCode, user.ID)
+	if err != nil {
+		log.Println("[GetURLReferrers] Failed to get URL:", err)
+		writeURLError(w, err)
+
+		return
+	}
+
+	period, limit := reportParams(r)
+	referrers, err := h.analyticsService.GetTopReferrers(&url.ID, period, limit)
+	if err != nil {
+		log.Println("[GetURLReferrers] Failed to get referrer stats:", err)
+		writeReportError(w, err, "Failed to get referrer stats")
+
+		return
+	}
+
+	setRawClicksFrom(w, h.analyticsService)
+	utils.JSONResponse(w, referrers, http.StatusOK)
+}
+
+// GetURLUTM returns the top utm source/medium/campaign combinations for a link owned by the authenticated user
+func (h *URLHandler) GetURLUTM(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+	log.Printf("[GetURLUTM] User %d requested %s\n", user.ID, shortCode)
+
+	url, err := h.urlService.GetOwnedURL(shortCode, user.ID)
+	if err != nil {
+		log.Println("[GetURLUTM] Failed to get URL:", err)
+		writeURLError(w, err)
+
+		return
+	}
+
+	period, limit := reportParams(r)
+	combos, err := h.analyticsService.GetTopUTM(&url.ID, period, limit)
+	if err != nil {
+		log.Println("[GetURLUTM] Failed to get UTM stats:", err)
+		writeReportError(w, err, "Failed to get UTM stats")
+
+		return
+	}
+
+	setRawClicksFrom(w, h.analyticsService)
+	utils.JSONResponse(w, combos, http.StatusOK)
+}
+
+// GetURLStats returns click totals, a time series and breakdowns for a link owned by the authenticated
+// user. from and to are RFC 3339 timestamps, granularity is minute, hour or day
+func (h *URLHandler) GetURLStats(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+	log.Printf("[GetURLStats] User %d requested %s\n", user.ID, shortCode)
+
+	url, err := h.urlService.GetOwnedURL(shortCode, user.ID)
+	if err != nil {
+		log.Println("[GetURLStats] Failed to get URL:", err)
+		writeURLError(w, err)
+
+		return
+	}
+
+	query := services.StatsQuery{Granularity: r.URL.Query().Get("granularity")}
+	for param, dest := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		if *dest, err = time.Parse(time.RFC3339, value); err != nil {
+			utils.JSONError(w, param+" must be an RFC 3339 timestamp", http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	stats, err := h.analyticsService.GetLinkStats(url.ID, query)
+	if err != nil {
+		log.Println("[GetURLStats] Failed to get stats:", err)
+		if errors.Is(err, services.ErrInvalidStatsQuery) {
+			utils.JSONError(w, err.Error(), http.StatusBadRequest)
+		} else {
+			utils.JSONError(w, "Failed to get stats", http.StatusInternalServerError)
+		}
+
+		return
+	}
+	stats.ShortCode = url.ShortCode
+
+	utils.JSONResponse(w, stats, http.StatusOK)
+}
+
+// UpdateURL changes the destination and/or active flag of a link owned by the authenticated user
+func (h *URLHandler) UpdateURL(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+	log.Printf("[UpdateURL] User %d updating %s\n", user.ID, shortCode)
+
+	var req models.UpdateURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println("[UpdateURL] Failed to decode request:", err)
+		utils.JSONError(w, "Invalid request body", http.StatusBadRequest)
+
+		return
+	}
+
+	url, err := h.urlService.UpdateURL(shortCode, user.ID, req)
+	if err != nil {
+		log.Println("[UpdateURL] Failed to update URL:", err)
+		writeURLError(w, err)
+
+		return
+	}
+
+	utils.JSONResponse(w, url, http.StatusOK)
+	log.Println("[UpdateURL] URL updated:", shortCode)
+}
+
+// DeleteURL soft-deletes a link owned by the authenticated user
+func (h *URLHandler) DeleteURL(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+	log.Printf("[DeleteURL] User %d deleting %s\n", user.ID, shortCode)
+
+	if err := h.urlService.DeleteURL(shortCode, user.ID); err != nil {
+		log.Println("[DeleteURL] Failed to delete URL:", err)
+		writeURLError(w, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Println("[DeleteURL] URL deleted:", shortCode)
+}
+
+// RestoreURL restores a soft-deleted link if it's still within the restore window
+func (h *URLHandler) RestoreURL(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+	log.Printf("[RestoreURL] User %d restoring %s\n", user.ID, shortCode)
+
+	url, err := h.urlService.RestoreURL(shortCode, user.ID, h.cfg.RestoreWindow)
+	if err != nil {
+		log.Println("[RestoreURL] Failed to restore URL:", err)
+		writeURLError(w, err)
+
+		return
+	}
+
+	utils.JSONResponse(w, url, http.StatusOK)
+	log.Println("[RestoreURL] URL restored:", shortCode)
+}
+
+// writeURLError maps URLService errors to their HTTP status codes
+func writeURLError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrURLNotFound):
+		utils.JSONError(w, "URL not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrNotOwner):
+		utils.JSONError(w, "You do not have access to this URL", http.StatusForbidden)
+	case errors.Is(err, services.ErrRestoreWindow):
+		utils.JSONError(w, err.Error(), http.StatusGone)
+	case errors.Is(err, services.ErrInvalidURL):
+		utils.JSONError(w, "Invalid URL format", http.StatusBadRequest)
+	case errors.Is(err, services.ErrInvalidRedirectType),
+		errors.Is(err, services.ErrInvalidForwardQuery),
+		errors.Is(err, services.ErrInvalidTargeting),
+		errors.Is(err, services.ErrInvalidDestinations):
+		utils.JSONError(w, err.Error(), http.StatusBadRequest)
+	default:
+		utils.JSONError(w, "Failed to process URL", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"minify/internal/config"
+	"minify/internal/limiter"
+	"minify/internal/models"
+	"minify/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+// urlColumns are the columns GetOwnedURL scans, in order
+var urlColumns = []string{"id", "short_code", "original_url", "user_id", "clicks", "expires_at", "max_clicks", "expired",
+	"active", "deleted_at", "password_hash", "redirect_type", "forward_query", "forward_path", "targeting_rules",
+	"country_overrides", "sticky_variants", "destinations", "created_at", "updated_at"}
+
+func urlRow(id int, shortCode string, userID int) *sqlmock.Rows {
+	now := time.Now()
+
+	return sqlmock.NewRows(urlColumns).AddRow(id, shortCode, "https://example.com", userID, 0, nil, nil, false, true, nil,
+		"", 302, "", false, nil, nil, false, nil, now, now)
+}
+
+func newStatsHandler(t *testing.T) (*URLHandler, sqlmock.Sqlmock) {
+	db, mock := newMockDB(t)
+	urlService := services.NewURLService(db, nil, nil)
+	analyticsService := services.NewAnalyticsService(db, nil, nil, false)
+
+	return NewURLHandler(urlService, analyticsService, limiter.NewLimiter(100), nil, &config.Config{JWTSecret: testJWTSecret}), mock
+}
+
+func statsRequest(shortCode string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/urls/"+shortCode+"/stats?granularity=day", nil)
+
+	return mux.SetURLVars(r, map[string]string{"shortCode": shortCode})
+}
+
+func TestGetURLStatsRequiresAuth(t *testing.T) {
+	h, _ := newStatsHandler(t)
+
+	if w := serve(h.GetURLStats, statsRequest("abc1234"), true); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for anonymous stats, got %d", w.Code)
+	}
+}
+
+func TestGetURLStatsNotOwner(t *testing.T) {
+	h, mock := newStatsHandler(t)
+	mock.ExpectQuery(`FROM urls WHERE short_code = \$1`).WithArgs("abc1234").WillReturnRows(urlRow(1, "abc1234", 2))
+
+	if w := serve(h.GetURLStats, asUser(t, statsRequest("abc1234"), 1), true); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 for another user's stats, got %d", w.Code)
+	}
+}
+
+func TestGetURLStatsOwner(t *testing.T) {
+	h, mock := newStatsHandler(t)
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery(`FROM urls WHERE short_code = \$1`).WithArgs("abc1234").WillReturnRows(urlRow(1, "abc1234", 1))
+	mock.ExpectQuery(`SELECT rolled_from, rolled_to FROM rollup_state`).WillReturnRows(sqlmock.NewRows([]string{"rolled_from", "rolled_to"}))
+	pruned := time.Now().UTC().Add(-48 * time.Hour).Truncate(time.Second)
+	mock.ExpectQuery(`SELECT pruned_before FROM rollup_state`).WillReturnRows(sqlmock.NewRows([]string{"pruned_before"}).AddRow(pruned))
+	mock.ExpectQuery(`SELECT COUNT\(DISTINCT ip_address\)`).WithArgs(1, pruned, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(`SELECT\s+\(SELECT COALESCE\(SUM`).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(5))
+	mock.ExpectQuery(`FROM url_visitor_sketches`).WillReturnRows(sqlmock.NewRows([]string{"sketch"}))
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	mock.ExpectQuery(`date_trunc\(\$6, at\)`).WillReturnRows(sqlmock.NewRows([]string{"bucket", "sum"}).AddRow(day, 5))
+	mock.ExpectQuery(`date_trunc\(\$4, clicked_at\)`).WillReturnRows(sqlmock.NewRows([]string{"bucket", "count"}).AddRow(day, 3))
+	for range []string{"browsers", "os", "countries", "referrers"} {
+		mock.ExpectQuery(`GROUP BY 1\s+ORDER BY 2 DESC`).WithArgs(1, pruned, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"value", "count"}).AddRow("x", 5))
+	}
+
+	w := serve(h.GetURLStats, asUser(t, statsRequest("abc1234"), 1), true)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for the owner's stats, got %d: %s", w.Code, w.Body.String())
+	}
+	var stats models.LinkStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+	if stats.ShortCode != "abc1234" || stats.TotalClicks != 5 || stats.UniqueClicks != 3 || len(stats.Series) != 1 ||
+		len(stats.Breakdowns["browsers"]) != 1 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	if stats.RawClicksFrom == nil || !stats.RawClicksFrom.Equal(pruned) {
+		t.Fatalf("Expected raw-only stats to start at the retention cutoff %v, got %v", pruned, stats.RawClicksFrom)
+	}
+}
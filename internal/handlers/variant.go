@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"minify/internal/middleware"
+	"minify/internal/models"
+	"minify/internal/services"
+	"minify/internal/utils"
+
+	"github.com/gorilla/mux"
+)
+
+const variantCookieTTL = 30 * 24 * time.Hour
+
+// pickVariant chooses one of the link's A/B destinations, or nil if it has none. With sticky
+// variants the choice is kept in a cookie so returning visitors see the same destination
+func (h *URLHandler) pickVariant(w http.ResponseWriter, r *http.Request, url *models.URL) *models.Destination {
+	if len(url.Destinations) == 0 {
+		return nil
+	}
+
+	pinnedID := 0
+	if url.StickyVariants {
+		if cookie, err := r.Cookie(variantCookieName(url.ShortCode)); err == nil {
+			pinnedID, _ = strconv.Atoi(cookie.Value)
+		}
+	}
+
+	variant := services.PickDestination(url.Destinations, pinnedID)
+	if url.StickyVariants && variant.ID != pinnedID {
+		http.SetCookie(w, &http.Cookie{
+			Name:     variantCookieName(url.ShortCode),
+			Value:    strconv.Itoa(variant.ID),
+			Path:     "/" + url.ShortCode,
+			Expires:  time.Now().Add(variantCookieTTL),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	return variant
+}
+
+// GetURLVariants returns the clicks served by each A/B destination of a link owned by the authenticated user
+func (h *URLHandler) GetURLVariants(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+	log.Printf("[GetURLVariants] User %d requested %s\n", user.ID, shortCode)
+
+	url, err := h.urlService.GetOwnedURL(shortCode, user.ID)
+	if err != nil {
+		log.Println("[GetURLVariants] Failed to get URL:", err)
+		writeURLError(w, err)
+
+		return
+	}
+
+	variants, err := h.analyticsService.GetVariantClicks(url.ID)
+	if err != nil {
+		log.Println("[GetURLVariants] Failed to get variant stats:", err)
+		utils.JSONError(w, "Failed to get variant stats", http.StatusInternalServerError)
+
+		return
+	}
+
+	utils.JSONResponse(w, variants, http.StatusOK)
+}
+
+func variantCookieName(shortCode string) string {
+	return "minify_variant_" + shortCode
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"minify/internal/middleware"
+	"minify/internal/models"
+	"minify/internal/services"
+	"minify/internal/utils"
+
+	"github.com/gorilla/mux"
+)
+
+type WebhookHandler struct {
+	webhookService *services.WebhookService // manages webhook subscriptions and their delivery log
+}
+
+func NewWebhookHandler(webhookService *services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{webhookService: webhookService}
+}
+
+// CreateWebhook subscribes a URL to the authenticated user's events, the response holds the signing secret
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	log.Printf("[CreateWebhook] User %d creating a webhook\n", user.ID)
+
+	var req models.CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println("[CreateWebhook] Failed to decode request:", err)
+		utils.JSONError(w, "Invalid request body", http.StatusBadRequest)
+
+		return
+	}
+
+	webhook, err := h.webhookService.CreateWebhook(user.ID, req)
+	if err != nil {
+		log.Println("[CreateWebhook] Failed to create webhook:", err)
+		writeWebhookError(w, err)
+
+		return
+	}
+
+	utils.JSONResponse(w, webhook, http.StatusCreated)
+}
+
+// GetWebhooks lists the authenticated user's webhooks
+func (h *WebhookHandler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+
+	webhooks, err := h.webhookService.GetWebhooks(user.ID)
+	if err != nil {
+		log.Println("[GetWebhooks] Failed to get webhooks:", err)
+		writeWebhookError(w, err)
+
+		return
+	}
+
+	utils.JSONResponse(w, webhooks, http.StatusOK)
+}
+
+// GetWebhook returns one of the authenticated user's webhooks
+func (h *WebhookHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+
+	webhook, err := h.webhookService.GetWebhook(id, user.ID)
+	if err != nil {
+		log.Println("[GetWebhook] Failed to get webhook:", err)
+		writeWebhookError(w, err)
+
+		return
+	}
+
+	utils.JSONResponse(w, webhook, http.StatusOK)
+}
+
+// UpdateWebhook changes a webhook's url, events or active flag
+func (h *WebhookHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	log.Printf("[UpdateWebhook] User %d updating webhook %d\n", user.ID, id)
+
+	var req models.UpdateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println("[UpdateWebhook] Failed to decode request:", err)
+		utils.JSONError(w, "Invalid request body", http.StatusBadRequest)
+
+		return
+	}
+
+	webhook, err := h.webhookService.UpdateWebhook(id, user.ID, req)
+	if err != nil {
+		log.Println("[UpdateWebhook] Failed to update webhook:", err)
+		writeWebhookError(w, err)
+
+		return
+	}
+
+	utils.JSONResponse(w, webhook, http.StatusOK)
+}
+
+// DeleteWebhook removes a webhook and drops its undelivered events
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	log.Printf("[DeleteWebhook] User %d deleting webhook %d\n", user.ID, id)
+
+	if err := h.webhookService.DeleteWebhook(id, user.ID); err != nil {
+		log.Println("[DeleteWebhook] Failed to delete webhook:", err)
+		writeWebhookError(w, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetWebhookDeliveries returns the most recent delivery attempts of a webhook (limit, at most 50)
+func (h *WebhookHandler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	attempts, err := h.webhookService.GetWebhookAttempts(id, user.ID, limit)
+	if err != nil {
+		log.Println("[GetWebhookDeliveries] Failed to get delivery log:", err)
+		writeWebhookError(w, err)
+
+		return
+	}
+
+	utils.JSONResponse(w, attempts, http.StatusOK)
+}
+
+// webhookID parses the {id} path variable, writing a 404 if it isn't a number
+func webhookID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.JSONError(w, "Webhook not found", http.StatusNotFound)
+		return 0, false
+	}
+
+	return id, true
+}
+
+func writeWebhookError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrWebhookNotFound):
+		utils.JSONError(w, "Webhook not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrInvalidWebhook), errors.Is(err, services.ErrTooManyWebhooks):
+		utils.JSONError(w, err.Error(), http.StatusBadRequest)
+	default:
+		utils.JSONError(w, "Failed to process webhook", http.StatusInternalServerError)
+	}
+}
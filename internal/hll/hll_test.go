@@ -0,0 +1,79 @@
+package hll
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+func hashOf(i int) uint64 {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(i))
+	sum := sha256.Sum256(b[:])
+
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+func withinError(t *testing.T, got uint64, want int) {
+	t.Helper()
+	// four standard errors, so the test doesn't flake on an unlucky but valid estimate
+	if diff := math.Abs(float64(got)-float64(want)) / float64(want); diff > 4*StandardError {
+		t.Fatalf("Estimate %d is %.1f%% off %d", got, diff*100, want)
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	if got := New().Estimate(); got != 0 {
+		t.Fatalf("Expected an empty sketch to estimate 0, got %d", got)
+	}
+
+	for _, n := range []int{10, 1000, 50000, 500000} {
+		s := New()
+		for i := 0; i < n; i++ {
+			s.Add(hashOf(i))
+			s.Add(hashOf(i)) // repeats don't count
+		}
+		withinError(t, s.Estimate(), n)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	t.Log("Merging overlapping sketches estimates the union")
+	a, b := New(), New()
+	for i := 0; i < 30000; i++ {
+		a.Add(hashOf(i))
+	}
+	for i := 20000; i < 50000; i++ {
+		b.Add(hashOf(i))
+	}
+	a.Merge(b)
+	withinError(t, a.Estimate(), 50000)
+}
+
+func TestBytesRoundTrip(t *testing.T) {
+	s := New()
+	for i := 0; i < 5000; i++ {
+		s.Add(hashOf(i))
+	}
+
+	decoded, err := FromBytes(s.Bytes())
+	if err != nil {
+		t.Fatalf("FromBytes failed: %v", err)
+	}
+	if decoded.Estimate() != s.Estimate() {
+		t.Fatalf("Expected %d after round trip, got %d", s.Estimate(), decoded.Estimate())
+	}
+
+	empty, err := FromBytes(nil)
+	if err != nil || empty.Estimate() != 0 {
+		t.Fatalf("Expected an empty sketch from no bytes, got %v", err)
+	}
+
+	for _, b := range [][]byte{{Precision}, append([]byte{14}, make([]byte, registers)...)} {
+		if _, err := FromBytes(b); !errors.Is(err, ErrInvalidSketch) {
+			t.Fatalf("Expected ErrInvalidSketch for %d bytes, got %v", len(b), err)
+		}
+	}
+}
@@ -29,10 +29,13 @@ type RateConfig struct {
 }
 
 var Rates = struct {
-	Authenticated, Anonymous RateConfig
+	Authenticated, Anonymous, Unlock, UnlockLink, Links RateConfig
 }{
 	Authenticated: RateConfig{Rate: 0.50, Capacity: 10, Cooldown: 60 * time.Second},
 	Anonymous:     RateConfig{Rate: 0.33, Capacity: 5, Cooldown: 120 * time.Second},
+	Unlock:        RateConfig{Rate: 0.05, Capacity: 5, Cooldown: 300 * time.Second},  // failed link password attempts per visitor
+	UnlockLink:    RateConfig{Rate: 0.10, Capacity: 30, Cooldown: 900 * time.Second}, // failed password attempts on a link from all visitors
+	Links:         RateConfig{Rate: 1, Capacity: 1000, Cooldown: 300 * time.Second},  // links an account creates, single and bulk (2x the Authenticated rate)
 }
 
 // NewBucket creates a new bucket to track user tokens
@@ -57,6 +60,11 @@ func NewLimiter(maxBuckets int) *Limiter {
 
 // Allow checks and consumes rate-limit capacity for the given requester
 func (l *Limiter) Allow(key string, cfg RateConfig) bool {
+	return l.AllowN(key, cfg, 1)
+}
+
+// AllowN checks and consumes n tokens of rate-limit capacity for the given requester
+func (l *Limiter) AllowN(key string, cfg RateConfig, n float64) bool {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
@@ -70,11 +78,32 @@ func (l *Limiter) Allow(key string, cfg RateConfig) bool {
 		l.buckets[key] = b
 	}
 
-	return b.Consume(time.Now())
+	return b.ConsumeN(time.Now(), n)
+}
+
+// Blocked reports whether the requester's bucket is currently in cooldown, without consuming a token
+func (l *Limiter) Blocked(key string) bool {
+	l.mu.Lock()
+	b, ok := l.buckets[key]
+	l.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return time.Now().Before(b.cooldownUntil)
 }
 
 // Allow checks whether a request is allowed for the bucket and consumes the token if so
 func (b *Bucket) Consume(now time.Time) bool {
+	return b.ConsumeN(now, 1)
+}
+
+// ConsumeN checks whether n tokens are available in the bucket and consumes them if so
+func (b *Bucket) ConsumeN(now time.Time, n float64) bool {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
@@ -89,8 +118,8 @@ func (b *Bucket) Consume(now time.Time) bool {
 	b.lastAccessed = now
 
 	// reduce for every call made
-	if b.tokens >= 1 {
-		b.tokens -= 1
+	if b.tokens >= n {
+		b.tokens -= n
 		return true
 	}
 
@@ -129,3 +129,46 @@ func TestLimiterCleanupOldBuckets(t *testing.T) {
 		t.Fatal("Expected new bucket 'user3' to exist after cleanup")
 	}
 }
+
+func TestLimiterBlocked(t *testing.T) {
+	l := NewLimiter(100)
+	key := "unlock:1.2.3.4:abc"
+	cfg := Rates.Unlock
+
+	t.Log("Unknown keys are never blocked")
+	if l.Blocked(key) {
+		t.Fatal("Expected unknown key to not be blocked")
+	}
+
+	t.Log("Use up the bucket without tripping the cooldown")
+	for i := 0; i < int(cfg.Capacity); i++ {
+		l.Allow(key, cfg)
+	}
+	if l.Blocked(key) {
+		t.Fatal("Expected key to not be blocked before the cooldown starts")
+	}
+
+	t.Log("Next attempt starts the cooldown")
+	if l.Allow(key, cfg) {
+		t.Fatal("Expected request to be blocked after capacity reached")
+	}
+	if !l.Blocked(key) {
+		t.Fatal("Expected key to be blocked during cooldown")
+	}
+}
+
+func TestLimiterAllowN(t *testing.T) {
+	l := NewLimiter(100)
+	key := "links:user:1"
+	cfg := RateConfig{Rate: 1, Capacity: 100, Cooldown: time.Minute}
+
+	t.Log("Charge a batch that fits in the bucket")
+	if !l.AllowN(key, cfg, 80) {
+		t.Fatal("Expected batch of 80 to be allowed")
+	}
+
+	t.Log("A batch larger than the remaining tokens should be rejected")
+	if l.AllowN(key, cfg, 30) {
+		t.Fatal("Expected batch of 30 to be blocked with ~20 tokens left")
+	}
+}
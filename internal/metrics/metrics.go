@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -67,6 +69,139 @@ var (
 		},
 	)
 
+	// CacheRequests counts short code cache lookups by tier (local, remote) and result (hit, miss)
+	CacheRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "minify_cache_requests_total",
+			Help: "Total number of short code cache lookups",
+		},
+		[]string{"tier", "result"},
+	)
+
+	// ClickQueueDepth tracks clicks waiting to be written
+	ClickQueueDepth = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "minify_click_queue_depth",
+			Help: "Number of clicks waiting in the write queue",
+		},
+	)
+
+	// ClickBackpressure counts redirects that found the click queue full and had to wait
+	ClickBackpressure = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "minify_click_queue_backpressure_total",
+			Help: "Total number of clicks that waited for room in a full queue",
+		},
+	)
+
+	// ClicksDropped counts clicks that were never written, by reason (queue_full, shutdown, write_failed)
+	ClicksDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "minify_clicks_dropped_total",
+			Help: "Total number of clicks dropped before being written",
+		},
+		[]string{"reason"},
+	)
+
+	// ClicksFlushed counts clicks written to the db by the click pipeline
+	ClicksFlushed = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "minify_clicks_flushed_total",
+			Help: "Total number of clicks written by the click pipeline",
+		},
+	)
+
+	// ClickStreamSubscribers tracks open live click streams
+	ClickStreamSubscribers = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "minify_click_stream_subscribers",
+			Help: "Number of open live click streams",
+		},
+	)
+
+	// WebhookDeliveries counts webhook delivery attempts by result (success, failure)
+	WebhookDeliveries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "minify_webhook_deliveries_total",
+			Help: "Total number of webhook delivery attempts",
+		},
+		[]string{"result"},
+	)
+
+	// ClickStreamEvictions counts live click streams dropped for falling behind
+	ClickStreamEvictions = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "minify_click_stream_evictions_total",
+			Help: "Total number of live click streams evicted as slow consumers",
+		},
+	)
+
+	// RetentionRuns counts click retention runs by result (success, failure)
+	RetentionRuns = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "minify_retention_runs_total",
+			Help: "Total number of click retention runs",
+		},
+		[]string{"result"},
+	)
+
+	// RetentionRunDuration measures how long click retention runs take
+	RetentionRunDuration = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "minify_retention_run_duration_seconds",
+			Help:    "Duration of click retention runs in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.1, 4, 8),
+		},
+	)
+
+	// RetentionLastSuccess is when the last click retention run succeeded
+	RetentionLastSuccess = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "minify_retention_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful click retention run",
+		},
+	)
+
+	// RetentionCutoff is the time raw clicks are kept from
+	RetentionCutoff = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "minify_retention_cutoff_timestamp_seconds",
+			Help: "Unix time before which raw clicks were pruned by the last retention run",
+		},
+	)
+
+	// ClicksPruned counts raw clicks deleted by retention
+	ClicksPruned = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "minify_clicks_pruned_total",
+			Help: "Total number of raw clicks deleted by retention",
+		},
+	)
+
+	// ClicksArchived counts pruned clicks written to archive files
+	ClicksArchived = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "minify_clicks_archived_total",
+			Help: "Total number of pruned clicks written to archive files",
+		},
+	)
+
+	// ClickPartitions tracks the partitions of the clicks table
+	ClickPartitions = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "minify_click_partitions",
+			Help: "Number of partitions of the clicks table",
+		},
+	)
+
+	// ClickPartitionsDropped counts click partitions dropped by retention
+	ClickPartitionsDropped = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "minify_click_partitions_dropped_total",
+			Help: "Total number of click partitions dropped by retention",
+		},
+	)
+
 	// DatabaseConnections tracks active db connections
 	DatabaseConnections = promauto.NewGauge(
 		prometheus.GaugeOpts{
@@ -102,6 +237,76 @@ func RecordUserRegistration() {
 	UsersRegistered.Inc()
 }
 
+func RecordCacheLookup(tier string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	CacheRequests.WithLabelValues(tier, result).Inc()
+}
+
+func SetClickQueueDepth(depth float64) {
+	ClickQueueDepth.Set(depth)
+}
+
+func RecordClickBackpressure() {
+	ClickBackpressure.Inc()
+}
+
+func RecordClicksDropped(reason string, n int) {
+	ClicksDropped.WithLabelValues(reason).Add(float64(n))
+}
+
+func RecordClicksFlushed(n int) {
+	ClicksFlushed.Add(float64(n))
+}
+
+func SetClickStreamSubscribers(count float64) {
+	ClickStreamSubscribers.Set(count)
+}
+
+func RecordClickStreamEviction() {
+	ClickStreamEvictions.Inc()
+}
+
+func RecordWebhookDelivery(success bool) {
+	result := "failure"
+	if success {
+		result = "success"
+	}
+	WebhookDeliveries.WithLabelValues(result).Inc()
+}
+
+func RecordRetentionRun(success bool, duration time.Duration) {
+	result := "failure"
+	if success {
+		result = "success"
+		RetentionLastSuccess.SetToCurrentTime()
+	}
+	RetentionRuns.WithLabelValues(result).Inc()
+	RetentionRunDuration.Observe(duration.Seconds())
+}
+
+func SetRetentionCutoff(cutoff time.Time) {
+	RetentionCutoff.Set(float64(cutoff.Unix()))
+}
+
+func RecordClicksPruned(n int) {
+	ClicksPruned.Add(float64(n))
+}
+
+func RecordClicksArchived(n int) {
+	ClicksArchived.Add(float64(n))
+}
+
+func SetClickPartitions(count float64) {
+	ClickPartitions.Set(count)
+}
+
+func RecordClickPartitionDropped() {
+	ClickPartitionsDropped.Inc()
+}
+
 func SetActiveUsers(count float64) {
 	ActiveUsers.Set(count)
 }
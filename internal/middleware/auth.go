@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"minify/internal/utils"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+// AuthUser is the caller identity taken from a verified JWT
+type AuthUser struct {
+	ID       int
+	Username string
+}
+
+// Authenticate validates the bearer token (if one is sent) and stores the caller in the request context.
+// Requests without a token continue anonymously, while forged or expired tokens are rejected
+func Authenticate(jwtSecret string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			tokenStr := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+			if tokenStr == header || tokenStr == "" {
+				utils.JSONError(w, "Invalid authorization header", http.StatusUnauthorized)
+				return
+			}
+
+			user, err := ParseToken(tokenStr, jwtSecret)
+			if err != nil {
+				log.Println("[Auth] Rejected token:", err)
+				utils.JSONError(w, "Invalid or expired token", http.StatusUnauthorized)
+
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userContextKey, user)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireAuth rejects requests that don't have an authenticated user in their context
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := UserFromContext(r.Context()); !ok {
+			utils.JSONError(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAdmin rejects requests unless the authenticated user is one of adminIDs
+func RequireAdmin(adminIDs []int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, _ := UserFromContext(r.Context())
+			for _, id := range adminIDs {
+				if user.ID == id {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			log.Printf("[Auth] Rejected user %d on an admin route\n", user.ID)
+			utils.JSONError(w, "Admin access required", http.StatusForbidden)
+		}))
+	}
+}
+
+// UserFromContext returns the authenticated user set by Authenticate, if any
+func UserFromContext(ctx context.Context) (*AuthUser, bool) {
+	user, ok := ctx.Value(userContextKey).(*AuthUser)
+	return user, ok && user != nil
+}
+
+// ParseToken verifies an HS256 token's signature and expiry and returns the user it was issued for
+func ParseToken(tokenStr, jwtSecret string) (*AuthUser, error) {
+	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return []byte(jwtSecret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	// tokens without an expiry are never issued by the server, so treat them as forged
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, errors.New("token is expired or has no expiry")
+	}
+
+	userID, ok := claims["user_id"].(float64)
+	if !ok || userID <= 0 {
+		return nil, errors.New("token has no user_id claim")
+	}
+	username, _ := claims["username"].(string)
+
+	return &AuthUser{ID: int(userID), Username: username}, nil
+}
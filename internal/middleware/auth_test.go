@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const testSecret = "test-secret"
+
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return token
+}
+
+func serveWithAuth(header string) (*httptest.ResponseRecorder, *AuthUser) {
+	var seen *AuthUser
+	handler := Authenticate(testSecret)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = UserFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/urls", nil)
+	if header != "" {
+		req.Header.Set("Authorization", header)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec, seen
+}
+
+func TestAuthenticateValidToken(t *testing.T) {
+	token := signToken(t, jwt.SigningMethodHS256, []byte(testSecret), jwt.MapClaims{
+		"user_id":  42,
+		"username": "alice",
+		"exp":      time.Now().Add(time.Hour).Unix(),
+	})
+
+	rec, user := serveWithAuth("Bearer " + token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if user == nil || user.ID != 42 || user.Username != "alice" {
+		t.Fatalf("Expected user 42/alice in context, got %+v", user)
+	}
+}
+
+func TestAuthenticateAnonymous(t *testing.T) {
+	rec, user := serveWithAuth("")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected anonymous request to pass, got %d", rec.Code)
+	}
+	if user != nil {
+		t.Fatalf("Expected no user in context, got %+v", user)
+	}
+}
+
+func TestAuthenticateRejectsBadTokens(t *testing.T) {
+	future := time.Now().Add(time.Hour).Unix()
+
+	cases := map[string]string{
+		"wrong secret": signToken(t, jwt.SigningMethodHS256, []byte("other-secret"), jwt.MapClaims{
+			"user_id": 1, "exp": future,
+		}),
+		"expired": signToken(t, jwt.SigningMethodHS256, []byte(testSecret), jwt.MapClaims{
+			"user_id": 1, "exp": time.Now().Add(-time.Hour).Unix(),
+		}),
+		"no expiry": signToken(t, jwt.SigningMethodHS256, []byte(testSecret), jwt.MapClaims{
+			"user_id": 1,
+		}),
+		"no user": signToken(t, jwt.SigningMethodHS256, []byte(testSecret), jwt.MapClaims{
+			"exp": future,
+		}),
+		"alg none": signToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, jwt.MapClaims{
+			"user_id": 1, "exp": future,
+		}),
+		"garbage": "not-a-token",
+	}
+
+	for name, token := range cases {
+		rec, user := serveWithAuth("Bearer " + token)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401, got %d", name, rec.Code)
+		}
+		if user != nil {
+			t.Errorf("%s: expected no user in context", name)
+		}
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	handler := RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/urls", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without a user, got %d", rec.Code)
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	handler := Authenticate(testSecret)(RequireAdmin([]int{1})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	cases := map[string]struct {
+		userID float64
+		want   int
+	}{
+		"anonymous": {0, http.StatusUnauthorized},
+		"non-admin": {2, http.StatusForbidden},
+		"admin":     {1, http.StatusOK},
+	}
+
+	for name, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/analytics/stream", nil)
+		if c.userID != 0 {
+			token := signToken(t, jwt.SigningMethodHS256, []byte(testSecret), jwt.MapClaims{
+				"user_id": c.userID, "username": "user", "exp": time.Now().Add(time.Hour).Unix(),
+			})
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("%s: expected %d, got %d", name, c.want, rec.Code)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type User struct {
 	ID           int       `json:"id" db:"id"`
@@ -11,29 +14,108 @@ type User struct {
 }
 
 type URL struct {
-	ID          int       `json:"id" db:"id"`
-	ShortCode   string    `json:"short_code" db:"short_code"`
-	OriginalURL string    `json:"original_url" db:"original_url"`
-	UserID      *int      `json:"user_id,omitempty" db:"user_id"`
-	Clicks      int       `json:"clicks" db:"clicks"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	ID               int               `json:"id" db:"id"`
+	ShortCode        string            `json:"short_code" db:"short_code"`
+	OriginalURL      string            `json:"original_url" db:"original_url"`
+	UserID           *int              `json:"user_id,omitempty" db:"user_id"`
+	Clicks           int               `json:"clicks" db:"clicks"`
+	ExpiresAt        *time.Time        `json:"expires_at,omitempty" db:"expires_at"`
+	MaxClicks        *int              `json:"max_clicks,omitempty" db:"max_clicks"`
+	Expired          bool              `json:"expired" db:"expired"`
+	Active           bool              `json:"active" db:"active"`
+	DeletedAt        *time.Time        `json:"deleted_at,omitempty" db:"deleted_at"`
+	PasswordHash     string            `json:"-" db:"password_hash"`
+	Protected        bool              `json:"protected"`
+	RedirectType     int               `json:"redirect_type" db:"redirect_type"`
+	ForwardQuery     string            `json:"forward_query,omitempty" db:"forward_query"`
+	ForwardPath      bool              `json:"forward_path" db:"forward_path"`
+	TargetingRules   []TargetingRule   `json:"targeting_rules,omitempty" db:"targeting_rules"`
+	CountryOverrides map[string]string `json:"country_overrides,omitempty" db:"country_overrides"`
+	Destinations     []Destination     `json:"destinations,omitempty" db:"-"`
+	StickyVariants   bool              `json:"sticky_variants" db:"sticky_variants"`
+	CreatedAt        time.Time         `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at" db:"updated_at"`
+}
+
+// TargetingRule sends visitors matching every non-empty condition to URL instead of the link's
+// original URL. Conditions are values from the useragent package (e.g. os "ios", device "mobile")
+type TargetingRule struct {
+	OS      string `json:"os,omitempty"`
+	Device  string `json:"device,omitempty"`
+	Browser string `json:"browser,omitempty"`
+	URL     string `json:"url"`
+}
+
+// Destination is one variant of an A/B split, visitors are spread over a link's destinations by weight
+type Destination struct {
+	ID     int    `json:"id,omitempty"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
 }
 
 type Click struct {
-	ID        int       `json:"id" db:"id"`
-	URLID     int       `json:"url_id" db:"url_id"`
-	UserAgent string    `json:"user_agent" db:"user_agent"`
-	IPAddress string    `json:"ip_address" db:"ip_address"`
+	ID        int    `json:"id" db:"id"`
+	URLID     int    `json:"url_id" db:"url_id"`
+	UserAgent string `json:"user_agent" db:"user_agent"`
+	IPAddress string `json:"ip_address" db:"ip_address"`
+	TargetURL string `json:"target_url" db:"target_url"`
+	Country   string `json:"country" db:"country"`
+	Region    string `json:"region" db:"region"`
+	VariantID *int   `json:"variant_id,omitempty" db:"variant_id"`
+
+	Browser        string `json:"browser" db:"browser"`
+	BrowserVersion string `json:"browser_version" db:"browser_version"`
+	OS             string `json:"os" db:"os"`
+	Device         string `json:"device" db:"device"`
+	IsBot          bool   `json:"is_bot" db:"is_bot"`
+	BotName        string `json:"bot_name,omitempty" db:"bot_name"`
+
+	Referrer    string `json:"referrer,omitempty" db:"referrer_domain"` // domain only, empty for direct visits
+	UTMSource   string `json:"utm_source,omitempty" db:"utm_source"`
+	UTMMedium   string `json:"utm_medium,omitempty" db:"utm_medium"`
+	UTMCampaign string `json:"utm_campaign,omitempty" db:"utm_campaign"`
+	UTMTerm     string `json:"utm_term,omitempty" db:"utm_term"`
+	UTMContent  string `json:"utm_content,omitempty" db:"utm_content"`
+
+	Counted   bool      `json:"-" db:"-"` // urls.clicks was already incremented, e.g. by a click limit claim
 	ClickedAt time.Time `json:"clicked_at" db:"clicked_at"`
 }
 
 // requests / responses
 type MinifyRequest struct {
-	URL    string `json:"url" validate:"required,url"`
-	UserID *int   `json:"user_id,omitempty"`
+	URL       string     `json:"url" validate:"required,url"`
+	Alias     string     `json:"alias,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxClicks *int       `json:"max_clicks,omitempty"`
+	Password  string     `json:"password,omitempty"`
+	Dedup     *bool      `json:"dedup,omitempty"` // defaults to true
+
+	RedirectType int    `json:"redirect_type,omitempty"` // 301, 302 (default), 307 or 308
+	ForwardQuery string `json:"forward_query,omitempty"` // keep_destination or prefer_incoming
+	ForwardPath  bool   `json:"forward_path,omitempty"`
+
+	TargetingRules   []TargetingRule   `json:"targeting_rules,omitempty"`
+	CountryOverrides map[string]string `json:"country_overrides,omitempty"` // ISO country code -> destination
+
+	Destinations   []Destination `json:"destinations,omitempty"`    // A/B split, replaces original_url as the default destination
+	StickyVariants bool          `json:"sticky_variants,omitempty"` // pin returning visitors to their variant with a cookie
 }
 
+type UpdateURLRequest struct {
+	OriginalURL *string `json:"original_url,omitempty"`
+	Active      *bool   `json:"active,omitempty"`
+	Password    *string `json:"password,omitempty"`
+
+	RedirectType *int    `json:"redirect_type,omitempty"`
+	ForwardQuery *string `json:"forward_query,omitempty"` // empty string turns forwarding off
+	ForwardPath  *bool   `json:"forward_path,omitempty"`
+
+	TargetingRules   *[]TargetingRule   `json:"targeting_rules,omitempty"`   // empty list removes all rules
+	CountryOverrides *map[string]string `json:"country_overrides,omitempty"` // empty object removes all overrides
+
+	Destinations   *[]Destination `json:"destinations,omitempty"` // entries with an id keep that variant's stats, empty list ends the split
+	StickyVariants *bool          `json:"sticky_variants,omitempty"`
+}
 
 type CreateUserRequest struct {
 	Username string `json:"username" validate:"required,min=3,max=50"`
@@ -47,9 +129,29 @@ type LoginRequest struct {
 }
 
 type MinifyResponse struct {
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
-	ShortCode   string `json:"short_code"`
+	ShortURL     string     `json:"short_url"`
+	OriginalURL  string     `json:"original_url"`
+	ShortCode    string     `json:"short_code"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	MaxClicks    *int       `json:"max_clicks,omitempty"`
+	Protected    bool       `json:"protected"`
+	Reused       bool       `json:"reused"` // an existing link for the same destination was returned
+	RedirectType int        `json:"redirect_type"`
+}
+
+type BulkMinifyResult struct {
+	Index     int    `json:"index"`
+	URL       string `json:"url"`
+	ShortURL  string `json:"short_url,omitempty"`
+	ShortCode string `json:"short_code,omitempty"`
+	Reused    bool   `json:"reused,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type BulkMinifyResponse struct {
+	Created int                `json:"created"`
+	Failed  int                `json:"failed"`
+	Results []BulkMinifyResult `json:"results"`
 }
 
 type LoginResponse struct {
@@ -57,11 +159,12 @@ type LoginResponse struct {
 	User  User   `json:"user"`
 }
 
-// analytics 
+// analytics
 type OverviewStats struct {
 	TotalUsers    int                    `json:"total_users"`
 	TotalURLs     int                    `json:"total_urls"`
 	TotalClicks   int                    `json:"total_clicks"`
+	TotalVisitors int                    `json:"total_visitors"` // estimated unique visitors, all time
 	RecentUsers   []string               `json:"recent_users"`
 	TimeframeData map[string]interface{} `json:"timeframe_data"`
 }
@@ -73,9 +176,209 @@ type PopularURL struct {
 	Username    string `json:"username,omitempty"`
 }
 
+// CountryClicks is the number of clicks from one country, "" when the country is unknown
+type CountryClicks struct {
+	Country string `json:"country"`
+	Clicks  int    `json:"clicks"`
+}
+
+// LinkStats summarizes one link's clicks over a time range
+type LinkStats struct {
+	ShortCode      string                  `json:"short_code"`
+	From           time.Time               `json:"from"`
+	To             time.Time               `json:"to"`
+	Granularity    string                  `json:"granularity"`
+	TotalClicks    int                     `json:"total_clicks"`
+	UniqueClicks   int                     `json:"unique_clicks"`   // distinct visitor IPs
+	UniqueVisitors int                     `json:"unique_visitors"` // estimated, over the whole UTC days in the range
+	Series         []StatsBucket           `json:"series"`
+	Breakdowns     map[string][]StatsCount `json:"breakdowns"` // browsers, os, countries, referrers
+	// RawClicksFrom is set when raw clicks in the range were pruned, unique clicks (per bucket too)
+	// and breakdowns only count the clicks from then on
+	RawClicksFrom *time.Time `json:"raw_clicks_from,omitempty"`
+}
+
+// StatsBucket is the clicks in one time series bucket starting at Start
+type StatsBucket struct {
+	Start        time.Time `json:"start"`
+	Clicks       int       `json:"clicks"`
+	UniqueClicks int       `json:"unique_clicks"`
+}
+
+// StatsCount is the clicks for one value of a breakdown, "" when the value is unknown
+type StatsCount struct {
+	Value  string `json:"value"`
+	Clicks int    `json:"clicks"`
+}
+
+// ReferrerClicks is the number of clicks referred by one domain, "" for direct visits
+type ReferrerClicks struct {
+	Referrer string `json:"referrer"`
+	Clicks   int    `json:"clicks"`
+}
+
+// UTMClicks is the number of clicks for one utm source/medium/campaign combination
+type UTMClicks struct {
+	Source   string `json:"utm_source"`
+	Medium   string `json:"utm_medium"`
+	Campaign string `json:"utm_campaign"`
+	Clicks   int    `json:"clicks"`
+}
+
+// BotClicks is the number of clicks from one crawler
+type BotClicks struct {
+	Bot      string    `json:"bot"`
+	Clicks   int       `json:"clicks"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// VariantClicks is the number of clicks served by one A/B destination
+type VariantClicks struct {
+	ID     int    `json:"id"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+	Clicks int    `json:"clicks"`
+}
+
+// ClickEvent is a click pushed to live click streams as it's recorded
+type ClickEvent struct {
+	ShortCode string    `json:"short_code"`
+	Timestamp time.Time `json:"timestamp"`
+	Country   string    `json:"country,omitempty"`
+	Device    string    `json:"device"`
+	Referrer  string    `json:"referrer,omitempty"` // referring domain, empty for direct visits
+	OwnerID   *int      `json:"-"`                  // for streams of one owner's links
+}
+
+// TimeframeStats summarizes service-wide activity over a time range, compared with the period
+// of the same length just before it
 type TimeframeStats struct {
-	Period      string `json:"period"`
-	ClickCount  int    `json:"click_count"`
-	URLCount    int    `json:"url_count"`
-	UniqueUsers int    `json:"unique_users"`
+	Period         string            `json:"period,omitempty"` // shorthand the range came from, if any
+	From           time.Time         `json:"from"`
+	To             time.Time         `json:"to"`
+	TimeZone       string            `json:"time_zone"`
+	Bucket         string            `json:"bucket"`
+	ClickCount     int               `json:"click_count"`
+	URLCount       int               `json:"url_count"`
+	UniqueUsers    int               `json:"unique_users"`    // distinct link creators
+	UniqueVisitors int               `json:"unique_visitors"` // estimated, over the whole UTC days in the range
+	Series         []TimeframeBucket `json:"series"`
+	Previous       TimeframeTotals   `json:"previous"`
+	Change         TimeframeChange   `json:"change"`
+}
+
+// TimeframeBucket is the clicks and new URLs in one series bucket starting at Start
+type TimeframeBucket struct {
+	Start      time.Time `json:"start"`
+	ClickCount int       `json:"click_count"`
+	URLCount   int       `json:"url_count"`
+}
+
+// TimeframeTotals is the activity in the period before a timeframe
+type TimeframeTotals struct {
+	ClickCount     int `json:"click_count"`
+	URLCount       int `json:"url_count"`
+	UniqueUsers    int `json:"unique_users"`
+	UniqueVisitors int `json:"unique_visitors"`
+}
+
+// TimeframeChange is the difference from the previous period, percentages are nil when it had none
+type TimeframeChange struct {
+	ClickCount            int      `json:"click_count"`
+	ClickCountPercent     *float64 `json:"click_count_percent"`
+	URLCount              int      `json:"url_count"`
+	URLCountPercent       *float64 `json:"url_count_percent"`
+	UniqueUsers           int      `json:"unique_users"`
+	UniqueUsersPercent    *float64 `json:"unique_users_percent"`
+	UniqueVisitors        int      `json:"unique_visitors"`
+	UniqueVisitorsPercent *float64 `json:"unique_visitors_percent"`
+}
+
+// webhooks
+
+// Webhook sends the owner's link and click events to URL, signed with Secret
+type Webhook struct {
+	ID                  int        `json:"id"`
+	UserID              int        `json:"-"`
+	URL                 string     `json:"url"`
+	Events              []string   `json:"events"`
+	Secret              string     `json:"secret,omitempty"` // only returned when the webhook is created
+	Active              bool       `json:"active"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty"` // set when repeated failures turned it off
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret,omitempty"` // generated if empty
+}
+
+type UpdateWebhookRequest struct {
+	URL    *string   `json:"url,omitempty"`
+	Events *[]string `json:"events,omitempty"`
+	Active *bool     `json:"active,omitempty"` // true re-enables a webhook disabled by failures
+}
+
+// WebhookEvent is the body POSTed to a webhook, ID is the same across retries of one delivery
+type WebhookEvent struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// WebhookAttempt is one try at delivering an event, for the delivery log
+type WebhookAttempt struct {
+	ID          int64     `json:"id"`
+	DeliveryID  int64     `json:"delivery_id"`
+	EventType   string    `json:"event_type"`
+	Attempt     int       `json:"attempt"`
+	Success     bool      `json:"success"`
+	StatusCode  *int      `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	DurationMS  int       `json:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+// exports
+
+// LinkExport is one row of a links export, the json tags name CSV columns and NDJSON fields
+type LinkExport struct {
+	ShortCode   string     `json:"short_code" parquet:"short_code"`
+	OriginalURL string     `json:"original_url" parquet:"original_url"`
+	Clicks      int64      `json:"clicks" parquet:"clicks"`
+	MaxClicks   *int64     `json:"max_clicks" parquet:"max_clicks"`
+	Active      bool       `json:"active" parquet:"active"`
+	Expired     bool       `json:"expired" parquet:"expired"`
+	Protected   bool       `json:"protected" parquet:"protected"`
+	ExpiresAt   *time.Time `json:"expires_at" parquet:"expires_at"`
+	CreatedAt   time.Time  `json:"created_at" parquet:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" parquet:"updated_at"`
+}
+
+// ClickExport is one row of a clicks export. IP addresses are left out, visitors can be told
+// apart by VisitorID, a keyed hash of their IP and user agent
+type ClickExport struct {
+	ShortCode      string    `json:"short_code" parquet:"short_code,dict"`
+	ClickedAt      time.Time `json:"clicked_at" parquet:"clicked_at"`
+	VisitorID      string    `json:"visitor_id" parquet:"visitor_id"`
+	TargetURL      string    `json:"target_url" parquet:"target_url,dict"`
+	VariantID      *int64    `json:"variant_id" parquet:"variant_id"`
+	Country        string    `json:"country" parquet:"country,dict"`
+	Region         string    `json:"region" parquet:"region,dict"`
+	Browser        string    `json:"browser" parquet:"browser,dict"`
+	BrowserVersion string    `json:"browser_version" parquet:"browser_version,dict"`
+	OS             string    `json:"os" parquet:"os,dict"`
+	Device         string    `json:"device" parquet:"device,dict"`
+	IsBot          bool      `json:"is_bot" parquet:"is_bot"`
+	BotName        string    `json:"bot_name" parquet:"bot_name,dict"`
+	Referrer       string    `json:"referrer" parquet:"referrer,dict"`
+	UTMSource      string    `json:"utm_source" parquet:"utm_source,dict"`
+	UTMMedium      string    `json:"utm_medium" parquet:"utm_medium,dict"`
+	UTMCampaign    string    `json:"utm_campaign" parquet:"utm_campaign,dict"`
+	UTMTerm        string    `json:"utm_term" parquet:"utm_term,dict"`
+	UTMContent     string    `json:"utm_content" parquet:"utm_content,dict"`
 }
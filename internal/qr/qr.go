@@ -0,0 +1,234 @@
+package qr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+
+	minSize   = 64
+	maxSize   = 2048
+	maxMargin = 16
+)
+
+var recoveryLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// Options controls how a QR code is rendered
+type Options struct {
+	Format     string // png or svg
+	Size       int    // image width and height in pixels
+	Margin     int    // quiet zone width in modules
+	Level      string // error correction level: L, M, Q or H
+	Foreground color.RGBA
+	Background color.RGBA
+}
+
+// DefaultOptions returns a 256px black on white PNG with medium error correction
+func DefaultOptions() Options {
+	return Options{
+		Format:     FormatPNG,
+		Size:       256,
+		Margin:     4,
+		Level:      "M",
+		Foreground: color.RGBA{0, 0, 0, 255},
+		Background: color.RGBA{255, 255, 255, 255},
+	}
+}
+
+// ParseOptions reads size, margin, ec, fg, bg and format query params on top of the defaults
+func ParseOptions(query url.Values) (Options, error) {
+	opts := DefaultOptions()
+
+	if v := query.Get("format"); v != "" {
+		v = strings.ToLower(v)
+		if v != FormatPNG && v != FormatSVG {
+			return opts, fmt.Errorf("format must be %s or %s", FormatPNG, FormatSVG)
+		}
+		opts.Format = v
+	}
+
+	if v := query.Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < minSize || n > maxSize {
+			return opts, fmt.Errorf("size must be a number between %d and %d", minSize, maxSize)
+		}
+		opts.Size = n
+	}
+
+	if v := query.Get("margin"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxMargin {
+			return opts, fmt.Errorf("margin must be a number between 0 and %d", maxMargin)
+		}
+		opts.Margin = n
+	}
+
+	if v := query.Get("ec"); v != "" {
+		v = strings.ToUpper(v)
+		if _, ok := recoveryLevels[v]; !ok {
+			return opts, fmt.Errorf("ec must be one of L, M, Q, H")
+		}
+		opts.Level = v
+	}
+
+	var err error
+	if v := query.Get("fg"); v != "" {
+		if opts.Foreground, err = parseHexColor(v); err != nil {
+			return opts, fmt.Errorf("fg %w", err)
+		}
+	}
+	if v := query.Get("bg"); v != "" {
+		if opts.Background, err = parseHexColor(v); err != nil {
+			return opts, fmt.Errorf("bg %w", err)
+		}
+	}
+
+	return opts, nil
+}
+
+// ETag identifies a rendered image, so clients can revalidate without it being regenerated
+func ETag(content string, opts Options) string {
+	key := fmt.Sprintf("%s|%s|%d|%d|%s|%x|%x", content, opts.Format, opts.Size, opts.Margin, opts.Level, opts.Foreground, opts.Background)
+	sum := sha256.Sum256([]byte(key))
+
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// ContentType returns the MIME type for the rendered format
+func ContentType(opts Options) string {
+	if opts.Format == FormatSVG {
+		return "image/svg+xml"
+	}
+
+	return "image/png"
+}
+
+// Render encodes content as a QR code in the requested format
+func Render(content string, opts Options) ([]byte, error) {
+	modules, err := bitmap(content, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Format == FormatSVG {
+		return renderSVG(modules, opts), nil
+	}
+
+	return renderPNG(modules, opts)
+}
+
+// bitmap returns the QR modules surrounded by the requested margin
+func bitmap(content string, opts Options) ([][]bool, error) {
+	level, ok := recoveryLevels[opts.Level]
+	if !ok {
+		level = qrcode.Medium
+	}
+
+	code, err := qrcode.New(content, level)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode QR code: %w", err)
+	}
+	code.DisableBorder = true // the margin is added below so it can be configured
+
+	symbol := code.Bitmap()
+	n := len(symbol) + 2*opts.Margin
+	modules := make([][]bool, n)
+	for y := range modules {
+		modules[y] = make([]bool, n)
+	}
+	for y, row := range symbol {
+		copy(modules[y+opts.Margin][opts.Margin:], row)
+	}
+
+	return modules, nil
+}
+
+func renderPNG(modules [][]bool, opts Options) ([]byte, error) {
+	n := len(modules)
+	scale := opts.Size / n
+	if scale < 1 {
+		scale = 1
+	}
+	size := scale * n
+	if size < opts.Size {
+		size = opts.Size
+	}
+	offset := (size - scale*n) / 2 // centre the code when size isn't a multiple of the module count
+
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{opts.Background, opts.Foreground})
+	for y, row := range modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(offset+x*scale+dx, offset+y*scale+dy, 1)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func renderSVG(modules [][]bool, opts Options) []byte {
+	n := len(modules)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, opts.Size, opts.Size, n, n)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="%s"/>`, n, n, hexColor(opts.Background))
+	fmt.Fprintf(&buf, `<path fill="%s" d="`, hexColor(opts.Foreground))
+	for y, row := range modules {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&buf, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+
+	return buf.Bytes()
+}
+
+// parseHexColor accepts RGB or RRGGBB, with or without a leading #
+func parseHexColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 3 {
+		return color.RGBA{}, fmt.Errorf("must be a hex color such as 000000 or #fff")
+	}
+
+	return color.RGBA{b[0], b[1], b[2], 255}, nil
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,87 @@
+package qr
+
+import (
+	"bytes"
+	"image/png"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseOptions(t *testing.T) {
+	opts, err := ParseOptions(url.Values{
+		"format": {"SVG"},
+		"size":   {"512"},
+		"margin": {"2"},
+		"ec":     {"h"},
+		"fg":     {"#f00"},
+		"bg":     {"00ff00"},
+	})
+	if err != nil {
+		t.Fatalf("ParseOptions failed: %v", err)
+	}
+
+	if opts.Format != FormatSVG || opts.Size != 512 || opts.Margin != 2 || opts.Level != "H" {
+		t.Fatalf("Unexpected options: %+v", opts)
+	}
+	if hexColor(opts.Foreground) != "#ff0000" || hexColor(opts.Background) != "#00ff00" {
+		t.Fatalf("Unexpected colors: fg %s bg %s", hexColor(opts.Foreground), hexColor(opts.Background))
+	}
+}
+
+func TestParseOptionsRejectsInvalid(t *testing.T) {
+	for _, query := range []url.Values{
+		{"format": {"gif"}},
+		{"size": {"10"}},
+		{"size": {"abc"}},
+		{"margin": {"-1"}},
+		{"ec": {"X"}},
+		{"fg": {"zzzzzz"}},
+	} {
+		if _, err := ParseOptions(query); err == nil {
+			t.Errorf("Expected %v to be rejected", query)
+		}
+	}
+}
+
+func TestRenderPNG(t *testing.T) {
+	opts := DefaultOptions()
+	data, err := Render("http://localhost/abc123", opts)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Rendered data is not a valid PNG: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != opts.Size || b.Dy() != opts.Size {
+		t.Fatalf("Expected %dx%d image, got %dx%d", opts.Size, opts.Size, b.Dx(), b.Dy())
+	}
+}
+
+func TestRenderSVG(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Format = FormatSVG
+
+	data, err := Render("http://localhost/abc123", opts)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "<svg") || !strings.Contains(string(data), "<path") {
+		t.Fatalf("Expected an SVG document, got %q", data[:40])
+	}
+}
+
+func TestETagChangesWithOptions(t *testing.T) {
+	opts := DefaultOptions()
+	a := ETag("http://localhost/abc123", opts)
+
+	opts.Size = 512
+	if b := ETag("http://localhost/abc123", opts); a == b {
+		t.Fatal("Expected ETag to change with size")
+	}
+	if c := ETag("http://localhost/other", DefaultOptions()); a == c {
+		t.Fatal("Expected ETag to change with content")
+	}
+}
@@ -2,38 +2,89 @@ package services
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
+	"time"
 
 	"minify/internal/models"
 )
 
+var ErrInvalidPeriod = errors.New("invalid period, use: hour, day, month, year")
+
+// periodIntervals maps the supported reporting periods to postgres intervals
+var periodIntervals = map[string]string{
+	"hour":  "1 hour",
+	"day":   "1 day",
+	"month": "1 month",
+	"year":  "1 year",
+}
+
+// periodInterval returns the interval for period, or nil (all time) if period is empty
+func periodInterval(period string) (*string, error) {
+	if period == "" {
+		return nil, nil
+	}
+	interval, ok := periodIntervals[period]
+	if !ok {
+		return nil, ErrInvalidPeriod
+	}
+
+	return &interval, nil
+}
+
 type AnalyticsService struct {
-	db *sql.DB
+	db        *sql.DB
+	clicks    *ClickPipeline
+	stream    *ClickStream // live click feed, nil if streaming is off
+	countBots bool         // include bot clicks in totals, they're always in the bot report
 }
 
-func NewAnalyticsService(db *sql.DB) *AnalyticsService {
-	return &AnalyticsService{db: db}
+func NewAnalyticsService(db *sql.DB, clicks *ClickPipeline, stream *ClickStream, countBots bool) *AnalyticsService {
+	return &AnalyticsService{db: db, clicks: clicks, stream: stream, countBots: countBots}
 }
 
-// RecordClick logs a click asynchronously, storing user agent and ip
-func (s *AnalyticsService) RecordClick(urlID int, userAgent, ipAddress string) error {
-	log.Printf("[AnalyticsService] Recording click for URL ID %d\n", urlID)
-
-	go func() {
-		query := `
-			INSERT INTO clicks (url_id, user_agent, ip_address)
-			VALUES ($1, $2, $3)
-		`
-		if _, err := s.db.Exec(query, urlID, userAgent, ipAddress); err != nil {
-			log.Println("[AnalyticsService] Failed to record click:", err)
-		} else {
-			log.Printf("[AnalyticsService] Click recorded for URL ID %d\n", urlID)
-		}
-	}()
+// clickFilter is a SQL condition on clicks that leaves out bots unless they're counted
+func (s *AnalyticsService) clickFilter() string {
+	if s.countBots {
+		return "TRUE"
+	}
 
-	return nil
+	return "NOT is_bot"
+}
+
+// RecordClick queues a click to be written by the click pipeline, storing user agent, ip, location
+// and the URL (and A/B variant) the visitor was sent to, and pushes it to live click streams
+func (s *AnalyticsService) RecordClick(url *models.URL, click models.Click) {
+	if click.ClickedAt.IsZero() {
+		click.ClickedAt = time.Now().UTC()
+	}
+	if !s.clicks.Enqueue(click) {
+		log.Printf("[AnalyticsService] Dropped click for URL ID %d\n", click.URLID)
+		return
+	}
+
+	if click.IsBot && !s.countBots {
+		return
+	}
+	s.stream.Publish(models.ClickEvent{
+		ShortCode: url.ShortCode,
+		Timestamp: click.ClickedAt,
+		Country:   click.Country,
+		Device:    click.Device,
+		Referrer:  click.Referrer,
+		OwnerID:   url.UserID,
+	})
+}
+
+// SubscribeClicks opens a live stream of every click, or only clicks on ownerID's links if it isn't nil
+func (s *AnalyticsService) SubscribeClicks(ownerID *int) (*ClickSubscription, error) {
+	if s.stream == nil {
+		return nil, ErrStreamClosed
+	}
+
+	return s.stream.Subscribe(ownerID)
 }
 
 // GetOverview concurrently fetches the overall stats for the service
@@ -44,7 +95,7 @@ func (s *AnalyticsService) GetOverview() (*models.OverviewStats, error) {
 	var err error
 
 	stats := &models.OverviewStats{TimeframeData: make(map[string]interface{})}
-	wg.Add(4)
+	wg.Add(5)
 
 	go func() { // total users
 		defer wg.Done()
@@ -68,15 +119,32 @@ func (s *AnalyticsService) GetOverview() (*models.OverviewStats, error) {
 		}
 	}()
 
-	go func() { // total Minified URL clicks
+	go func() { // total Minified URL clicks, mostly from the daily rollups
 		defer wg.Done()
-		query := `SELECT COALESCE(SUM(clicks), 0) FROM urls`
-		if scanErr := s.db.QueryRow(query).Scan(&stats.TotalClicks); scanErr != nil {
-			mu.Lock()
-			err = fmt.Errorf("failed to get total clicks: %w", scanErr)
-			mu.Unlock()
-			log.Println("[AnalyticsService] Error fetching total clicks:", scanErr)
+		rolled, queryErr := s.rollupRange()
+		var clicks int
+		if queryErr == nil {
+			clicks, queryErr = s.countClicks(nil, planRollups(time.Time{}, time.Now().UTC(), rolled))
+		}
+		mu.Lock()
+		if queryErr != nil {
+			err = fmt.Errorf("failed to get total clicks: %w", queryErr)
+			log.Println("[AnalyticsService] Error fetching total clicks:", queryErr)
+		}
+		stats.TotalClicks = clicks
+		mu.Unlock()
+	}()
+
+	go func() { // unique visitors, all time
+		defer wg.Done()
+		visitors, queryErr := s.uniqueVisitors(nil, time.Time{}, time.Now())
+		mu.Lock()
+		if queryErr != nil {
+			err = fmt.Errorf("failed to get total visitors: %w", queryErr)
+			log.Println("[AnalyticsService] Error fetching total visitors:", queryErr)
 		}
+		stats.TotalVisitors = visitors
+		mu.Unlock()
 	}()
 
 	go func() { // recent users
@@ -111,11 +179,18 @@ func (s *AnalyticsService) GetOverview() (*models.OverviewStats, error) {
 
 	// timeframe data
 	timeframes := []string{"hour", "day", "month", "year"}
+	wg.Add(len(timeframes))
 	for _, period := range timeframes {
-		if data, tfErr := s.GetTimeframeStats(period); tfErr == nil {
-			stats.TimeframeData[period] = data
-		}
+		go func(period string) {
+			defer wg.Done()
+			if data, tfErr := s.GetTimeframeStats(period, ""); tfErr == nil {
+				mu.Lock()
+				stats.TimeframeData[period] = data
+				mu.Unlock()
+			}
+		}(period)
 	}
+	wg.Wait()
 	log.Println("[AnalyticsService] Overview stats fetched successfully")
 
 	return stats, nil
@@ -157,70 +232,184 @@ func (s *AnalyticsService) GetPopularURLs(limit int) ([]*models.PopularURL, erro
 	return urls, nil
 }
 
-// GetTimeframeStats gets clicks, urls, and unique users for a given interval
-func (s *AnalyticsService) GetTimeframeStats(period string) (*models.TimeframeStats, error) {
-	log.Println("[AnalyticsService] Fetching timeframe stats for period:", period)
-	var interval string
-
-	switch period {
-	case "hour":
-		interval = "1 hour"
-	case "day":
-		interval = "1 day"
-	case "month":
-		interval = "1 month"
-	case "year":
-		interval = "1 year"
-	default:
-		return nil, fmt.Errorf("invalid period: %s", period)
-	}
-
-	stats := &models.TimeframeStats{Period: period}
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	var err error
+// GetClicksByCountry counts clicks per visitor country, for one URL or across all URLs if urlID is nil.
+// Clicks that couldn't be located are grouped under an empty country. Like the other breakdowns it's
+// read from raw clicks, so it only covers the clicks since RawClicksFrom
+func (s *AnalyticsService) GetClicksByCountry(urlID *int) ([]*models.CountryClicks, error) {
+	log.Println("[AnalyticsService] Fetching clicks by country")
 
-	wg.Add(3)
+	query := `
+		SELECT COALESCE(country, ''), COUNT(*)
+		FROM clicks
+		WHERE ($1::int IS NULL OR url_id = $1) AND ` + s.clickFilter() + `
+		GROUP BY 1
+		ORDER BY 2 DESC, 1
+	`
+	rows, err := s.db.Query(query, urlID)
+	if err != nil {
+		log.Println("[AnalyticsService] Failed to query clicks by country:", err)
+		return nil, fmt.Errorf("failed to get clicks by country: %w", err)
+	}
+	defer rows.Close()
 
-	go func() { // click count
-		defer wg.Done()
-		query := fmt.Sprintf("SELECT COUNT(*) FROM clicks WHERE clicked_at >= NOW() - INTERVAL '%s'", interval)
-		if scanErr := s.db.QueryRow(query).Scan(&stats.ClickCount); scanErr != nil {
-			mu.Lock()
-			err = fmt.Errorf("failed to get click count: %w", scanErr)
-			mu.Unlock()
-			log.Println("[AnalyticsService] Error fetching click count:", scanErr)
+	countries := []*models.CountryClicks{}
+	for rows.Next() {
+		var c models.CountryClicks
+		if scanErr := rows.Scan(&c.Country, &c.Clicks); scanErr != nil {
+			log.Println("[AnalyticsService] Failed to scan country clicks:", scanErr)
+			continue
 		}
-	}()
+		countries = append(countries, &c)
+	}
 
-	go func() { // URL count
-		defer wg.Done()
-		query := fmt.Sprintf("SELECT COUNT(*) FROM urls WHERE created_at >= NOW() - INTERVAL '%s'", interval)
-		if scanErr := s.db.QueryRow(query).Scan(&stats.URLCount); scanErr != nil {
-			mu.Lock()
-			err = fmt.Errorf("failed to get URL count: %w", scanErr)
-			mu.Unlock()
-			log.Println("[AnalyticsService] Error fetching URL count:", scanErr)
+	return countries, nil
+}
+
+// GetTopReferrers returns the domains referring the most clicks, for one URL or across all URLs if urlID
+// is nil, over the given period (all time if empty)
+func (s *AnalyticsService) GetTopReferrers(urlID *int, period string, limit int) ([]*models.ReferrerClicks, error) {
+	log.Println("[AnalyticsService] Fetching top referrers for period:", period)
+	interval, err := periodInterval(period)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT COALESCE(referrer_domain, ''), COUNT(*)
+		FROM clicks
+		WHERE ($1::int IS NULL OR url_id = $1)
+		AND ($2::interval IS NULL OR clicked_at >= NOW() - $2::interval)
+		AND ` + s.clickFilter() + `
+		GROUP BY 1
+		ORDER BY 2 DESC, 1
+		LIMIT $3
+	`
+	rows, err := s.db.Query(query, urlID, interval, limit)
+	if err != nil {
+		log.Println("[AnalyticsService] Failed to query top referrers:", err)
+		return nil, fmt.Errorf("failed to get top referrers: %w", err)
+	}
+	defer rows.Close()
+
+	referrers := []*models.ReferrerClicks{}
+	for rows.Next() {
+		var ref models.ReferrerClicks
+		if scanErr := rows.Scan(&ref.Referrer, &ref.Clicks); scanErr != nil {
+			log.Println("[AnalyticsService] Failed to scan referrer clicks:", scanErr)
+			continue
 		}
-	}()
+		referrers = append(referrers, &ref)
+	}
 
-	go func() { // unique users
-		defer wg.Done()
-		query := fmt.Sprintf("SELECT COUNT(DISTINCT user_id) FROM urls WHERE user_id IS NOT NULL AND created_at >= NOW() - INTERVAL '%s'", interval)
-		if scanErr := s.db.QueryRow(query).Scan(&stats.UniqueUsers); scanErr != nil {
-			mu.Lock()
-			err = fmt.Errorf("failed to get unique users: %w", scanErr)
-			mu.Unlock()
-			log.Println("[AnalyticsService] Error fetching unique users:", scanErr)
+	return referrers, nil
+}
+
+// GetTopUTM returns the utm source/medium/campaign combinations with the most clicks, for one URL
+// or across all URLs if urlID is nil, over the given period (all time if empty). Untagged clicks are left out
+func (s *AnalyticsService) GetTopUTM(urlID *int, period string, limit int) ([]*models.UTMClicks, error) {
+	log.Println("[AnalyticsService] Fetching top UTM combinations for period:", period)
+	interval, err := periodInterval(period)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT COALESCE(utm_source, ''), COALESCE(utm_medium, ''), COALESCE(utm_campaign, ''), COUNT(*)
+		FROM clicks
+		WHERE ($1::int IS NULL OR url_id = $1)
+		AND ($2::interval IS NULL OR clicked_at >= NOW() - $2::interval)
+		AND (utm_source IS NOT NULL OR utm_medium IS NOT NULL OR utm_campaign IS NOT NULL)
+		AND ` + s.clickFilter() + `
+		GROUP BY 1, 2, 3
+		ORDER BY 4 DESC, 1, 2, 3
+		LIMIT $3
+	`
+	rows, err := s.db.Query(query, urlID, interval, limit)
+	if err != nil {
+		log.Println("[AnalyticsService] Failed to query top UTM combinations:", err)
+		return nil, fmt.Errorf("failed to get top UTM combinations: %w", err)
+	}
+	defer rows.Close()
+
+	combos := []*models.UTMClicks{}
+	for rows.Next() {
+		var c models.UTMClicks
+		if scanErr := rows.Scan(&c.Source, &c.Medium, &c.Campaign, &c.Clicks); scanErr != nil {
+			log.Println("[AnalyticsService] Failed to scan UTM clicks:", scanErr)
+			continue
 		}
-	}()
+		combos = append(combos, &c)
+	}
 
-	wg.Wait()
+	return combos, nil
+}
+
+// GetBotClicks counts bot clicks per crawler, for one URL or across all URLs if urlID is nil
+func (s *AnalyticsService) GetBotClicks(urlID *int) ([]*models.BotClicks, error) {
+	log.Println("[AnalyticsService] Fetching bot clicks")
+
+	query := `
+		SELECT COALESCE(bot_name, 'other'), COUNT(*), MAX(clicked_at)
+		FROM clicks
+		WHERE is_bot AND ($1::int IS NULL OR url_id = $1)
+		GROUP BY 1
+		ORDER BY 2 DESC, 1
+	`
+	rows, err := s.db.Query(query, urlID)
+	if err != nil {
+		log.Println("[AnalyticsService] Failed to query bot clicks:", err)
+		return nil, fmt.Errorf("failed to get bot clicks: %w", err)
+	}
+	defer rows.Close()
+
+	bots := []*models.BotClicks{}
+	for rows.Next() {
+		var b models.BotClicks
+		if scanErr := rows.Scan(&b.Bot, &b.Clicks, &b.LastSeen); scanErr != nil {
+			log.Println("[AnalyticsService] Failed to scan bot clicks:", scanErr)
+			continue
+		}
+		bots = append(bots, &b)
+	}
+
+	return bots, nil
+}
+
+// GetVariantClicks counts the clicks served by each of a URL's A/B destinations, in split order. The
+// rolled range is read from the variant rollups, so results still cover pruned clicks
+func (s *AnalyticsService) GetVariantClicks(urlID int) ([]*models.VariantClicks, error) {
+	log.Printf("[AnalyticsService] Fetching variant clicks for URL ID %d\n", urlID)
+	rolled, err := s.rollupRange()
 	if err != nil {
 		return nil, err
 	}
 
-	log.Println("[AnalyticsService] Timeframe stats fetched for period:", period)
+	query := `
+		SELECT d.id, d.destination_url, d.weight,
+			(SELECT COALESCE(SUM(` + s.rollupClicks() + `), 0)::bigint FROM variant_rollups_hourly r
+				WHERE r.variant_id = d.id AND r.bucket >= $2 AND r.bucket < $3)
+			+ (SELECT COUNT(*) FROM clicks c
+				WHERE c.variant_id = d.id AND (c.clicked_at < $2 OR c.clicked_at >= $3) AND ` + s.clickFilter() + `)
+		FROM url_destinations d
+		WHERE d.url_id = $1
+		ORDER BY d.position
+	`
+	rows, err := s.db.Query(query, urlID, rolled.from, rolled.to)
+	if err != nil {
+		log.Println("[AnalyticsService] Failed to query variant clicks:", err)
+		return nil, fmt.Errorf("failed to get variant clicks: %w", err)
+	}
+	defer rows.Close()
+
+	variants := []*models.VariantClicks{}
+	for rows.Next() {
+		var v models.VariantClicks
+		if scanErr := rows.Scan(&v.ID, &v.URL, &v.Weight, &v.Clicks); scanErr != nil {
+			log.Println("[AnalyticsService] Failed to scan variant clicks:", scanErr)
+			continue
+		}
+		variants = append(variants, &v)
+	}
 
-	return stats, nil
+	return variants, nil
 }
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"minify/internal/models"
+)
+
+// clickArchive writes pruned clicks to a gzipped NDJSON file before they're deleted. Each write is
+// its own gzip member, synced to disk, so the clicks of a delete that's rolled back can be cut off
+// again. The file has a .partial suffix until it's closed, and is removed if no clicks were kept
+type clickArchive struct {
+	path    string
+	file    *os.File
+	kept    int64 // end of the last kept write
+	written int64 // end of the last write
+	pending int   // clicks written since the last keep
+	rows    int   // clicks kept
+}
+
+func createClickArchive(dir, name string) (*clickArchive, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	path := filepath.Join(dir, name+".ndjson.gz")
+	file, err := os.OpenFile(path+".partial", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %w", err)
+	}
+
+	return &clickArchive{path: path, file: file}, nil
+}
+
+// write appends clicks as one NDJSON gzip member and syncs the file
+func (a *clickArchive) write(clicks []models.Click) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+
+	buf := bufio.NewWriter(a.file)
+	gz := gzip.NewWriter(buf)
+	enc := json.NewEncoder(gz)
+	for _, click := range clicks {
+		if err := enc.Encode(click); err != nil {
+			return fmt.Errorf("failed to encode click: %w", err)
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress clicks: %w", err)
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := a.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync archive: %w", err)
+	}
+
+	end, err := a.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	a.written = end
+	a.pending += len(clicks)
+
+	return nil
+}
+
+// keep marks the clicks written since the last keep as deleted, returning how many there were
+func (a *clickArchive) keep() int {
+	n := a.pending
+	a.kept, a.pending = a.written, 0
+	a.rows += n
+
+	return n
+}
+
+// close cuts off the clicks that weren't kept and moves the file into place, or removes it if no
+// clicks were kept
+func (a *clickArchive) close() error {
+	partial := a.file.Name()
+	err := a.file.Truncate(a.kept)
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// the kept clicks are already deleted, so the partial file is left for an operator to recover
+		return fmt.Errorf("failed to close archive %s: %w", partial, err)
+	}
+	if a.rows == 0 {
+		return os.Remove(partial)
+	}
+
+	if err := os.Rename(partial, a.path); err != nil {
+		return fmt.Errorf("failed to move archive into place: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"minify/internal/models"
+)
+
+func archivedClicks(t *testing.T, path string) []models.Click {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Archive isn't gzipped: %v", err)
+	}
+
+	var clicks []models.Click
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var click models.Click
+		if err := json.Unmarshal(scanner.Bytes(), &click); err != nil {
+			t.Fatalf("Line isn't a click: %v", err)
+		}
+		clicks = append(clicks, click)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+
+	return clicks
+}
+
+func TestClickArchive(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "archive")
+	clickedAt := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	click := func(id int) models.Click { return models.Click{ID: id, URLID: 7, Country: "DE", ClickedAt: clickedAt} }
+
+	archive, err := createClickArchive(dir, "clicks_p202403")
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	archive.write([]models.Click{click(1), click(2)})
+	if n := archive.keep(); n != 2 {
+		t.Fatalf("Expected 2 kept clicks, got %d", n)
+	}
+	archive.write([]models.Click{click(3)})
+	archive.keep()
+
+	t.Log("Clicks whose delete was rolled back are cut off")
+	archive.write([]models.Click{click(4), click(5)})
+	if err := archive.close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+
+	path := filepath.Join(dir, "clicks_p202403.ndjson.gz")
+	if _, err := os.Stat(path + ".partial"); !os.IsNotExist(err) {
+		t.Fatalf("Expected the partial file to be moved into place, got %v", err)
+	}
+	clicks := archivedClicks(t, path)
+	if len(clicks) != 3 {
+		t.Fatalf("Expected 3 clicks across the gzip members, got %d", len(clicks))
+	}
+	if clicks[2].ID != 3 || clicks[2].Country != "DE" || !clicks[2].ClickedAt.Equal(clickedAt) {
+		t.Fatalf("Unexpected click: %+v", clicks[2])
+	}
+}
+
+func TestClickArchiveWithoutKeptClicks(t *testing.T) {
+	dir := t.TempDir()
+	archive, err := createClickArchive(dir, "clicks-20240310T120000Z")
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	archive.write([]models.Click{{ID: 1}})
+	if err := archive.close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Fatalf("Expected no file without kept clicks, got %d", len(entries))
+	}
+}
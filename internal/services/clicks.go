@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"minify/internal/metrics"
+	"minify/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// enqueueWait is how long a redirect waits for room in a full queue before dropping its click
+const enqueueWait = 5 * time.Millisecond
+
+var ErrPipelineClosed = errors.New("click pipeline is shut down")
+
+// ClickPipeline buffers clicks in a bounded queue and writes them behind the redirect. Workers
+// COPY each batch into clicks, add the batch's per-URL totals to urls.clicks in one UPDATE and
+// merge its visitors into the daily unique visitor sketches
+type ClickPipeline struct {
+	db            *sql.DB
+	countBots     bool   // whether bot clicks are added to urls.clicks and unique visitors
+	visitorSalt   []byte // keys the visitor hashes in the unique visitor sketches
+	queue         chan models.Click
+	workers       int
+	batchSize     int
+	flushInterval time.Duration
+
+	mu     sync.RWMutex // guards closed, so Enqueue never sends on a closed queue
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewClickPipeline(db *sql.DB, queueSize, workers, batchSize int, flushInterval time.Duration, countBots bool, visitorSalt string) *ClickPipeline {
+	return &ClickPipeline{
+		db:            db,
+		countBots:     countBots,
+		visitorSalt:   []byte(visitorSalt),
+		queue:         make(chan models.Click, queueSize),
+		workers:       workers,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+	}
+}
+
+// Start launches the workers
+func (p *ClickPipeline) Start() {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+}
+
+// Enqueue adds a click without blocking the redirect for more than enqueueWait. Returns false
+// if the click was dropped because the queue stayed full or the pipeline is shut down
+func (p *ClickPipeline) Enqueue(click models.Click) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		metrics.RecordClicksDropped("shutdown", 1)
+		return false
+	}
+	if click.ClickedAt.IsZero() {
+		click.ClickedAt = time.Now().UTC()
+	}
+
+	select {
+	case p.queue <- click:
+		metrics.SetClickQueueDepth(float64(len(p.queue)))
+		return true
+	default:
+	}
+
+	// queue is full, give the workers a moment to catch up before giving up on the click
+	metrics.RecordClickBackpressure()
+	timer := time.NewTimer(enqueueWait)
+	defer timer.Stop()
+
+	select {
+	case p.queue <- click:
+		return true
+	case <-timer.C:
+		metrics.RecordClicksDropped("queue_full", 1)
+		return false
+	}
+}
+
+// Shutdown stops accepting clicks and waits for the workers to flush everything already queued
+func (p *ClickPipeline) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("click pipeline did not drain: %w", ctx.Err())
+	}
+}
+
+// work collects clicks into batches, flushing when a batch is full, on every flush interval,
+// and once more when the queue is closed
+func (p *ClickPipeline) work() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.Click, 0, p.batchSize)
+	for {
+		select {
+		case click, ok := <-p.queue:
+			if !ok {
+				p.flush(batch)
+				return
+			}
+			batch = append(batch, click)
+			if len(batch) >= p.batchSize {
+				p.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			p.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (p *ClickPipeline) flush(batch []models.Click) {
+	if len(batch) == 0 {
+		return
+	}
+	metrics.SetClickQueueDepth(float64(len(p.queue)))
+
+	if err := p.writeBatch(batch); err != nil {
+		log.Printf("[ClickPipeline] Failed to write %d clicks: %v\n", len(batch), err)
+		metrics.RecordClicksDropped("write_failed", len(batch))
+
+		return
+	}
+	metrics.RecordClicksFlushed(len(batch))
+}
+
+// writeBatch stores a batch of clicks, bumps urls.clicks by the uncounted clicks per URL, updates the
+// unique visitor sketches and queues click.recorded webhook events, in one transaction
+func (p *ClickPipeline) writeBatch(batch []models.Click) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(pq.CopyIn("clicks", "url_id", "user_agent", "ip_address", "target_url", "country", "region",
+		"variant_id", "clicked_at", "browser", "browser_version", "os", "device", "is_bot", "bot_name",
+		"referrer_domain", "utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content"))
+	if err != nil {
+		return fmt.Errorf("failed to prepare copy: %w", err)
+	}
+
+	increments := make(map[int]int)
+	var events []models.Click // clicks reported to webhooks, uncounted bots are left out
+	for _, click := range batch {
+		if _, err := stmt.Exec(click.URLID, click.UserAgent, click.IPAddress, click.TargetURL, nullIfEmpty(click.Country),
+			nullIfEmpty(click.Region), click.VariantID, click.ClickedAt, nullIfEmpty(click.Browser), nullIfEmpty(click.BrowserVersion),
+			nullIfEmpty(click.OS), nullIfEmpty(click.Device), click.IsBot, nullIfEmpty(click.BotName),
+			nullIfEmpty(click.Referrer), nullIfEmpty(click.UTMSource), nullIfEmpty(click.UTMMedium), nullIfEmpty(click.UTMCampaign),
+			nullIfEmpty(click.UTMTerm), nullIfEmpty(click.UTMContent)); err != nil {
+			stmt.Close()
+			return fmt.Errorf("failed to copy click: %w", err)
+		}
+		// clicks on limited links were already counted when they were claimed
+		if click.IsBot && !p.countBots {
+			continue
+		}
+		if !click.Counted {
+			increments[click.URLID]++
+		}
+		events = append(events, click)
+	}
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return fmt.Errorf("failed to flush copy: %w", err)
+	}
+	if err := stmt.Close(); err != nil {
+		return fmt.Errorf("failed to close copy: %w", err)
+	}
+
+	if len(increments) > 0 {
+		ids := make([]int64, 0, len(increments))
+		counts := make([]int64, 0, len(increments))
+		for id, n := range increments {
+			ids = append(ids, int64(id))
+			counts = append(counts, int64(n))
+		}
+
+		query := `
+			UPDATE urls SET clicks = urls.clicks + batch.n, updated_at = CURRENT_TIMESTAMP
+			FROM unnest($1::int[], $2::int[]) AS batch(id, n)
+			WHERE urls.id = batch.id
+		`
+		if _, err := tx.Exec(query, pq.Array(ids), pq.Array(counts)); err != nil {
+			return fmt.Errorf("failed to update click counts: %w", err)
+		}
+	}
+
+	if err := p.updateVisitorSketches(tx, batch); err != nil {
+		return err
+	}
+	if err := enqueueClickEvents(tx, events); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit clicks: %w", err)
+	}
+
+	return nil
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+
+	return s
+}
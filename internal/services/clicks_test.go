@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"minify/internal/models"
+)
+
+func TestClickPipelineDropsWhenFull(t *testing.T) {
+	// no workers are started, so nothing drains the queue
+	p := NewClickPipeline(nil, 2, 1, 10, time.Second, false, "salt")
+
+	for i := 0; i < 2; i++ {
+		if !p.Enqueue(models.Click{URLID: 1}) {
+			t.Fatalf("Expected click %d to be queued", i+1)
+		}
+	}
+
+	start := time.Now()
+	if p.Enqueue(models.Click{URLID: 1}) {
+		t.Fatal("Expected click to be dropped when the queue is full")
+	}
+	if waited := time.Since(start); waited < enqueueWait {
+		t.Fatalf("Expected enqueue to wait %s before dropping, waited %s", enqueueWait, waited)
+	}
+}
+
+func TestClickPipelineRejectsAfterShutdown(t *testing.T) {
+	p := NewClickPipeline(nil, 10, 1, 10, time.Second, false, "salt")
+	p.Start()
+
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if p.Enqueue(models.Click{URLID: 1}) {
+		t.Fatal("Expected clicks to be rejected after shutdown")
+	}
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Expected a second shutdown to be a no-op, got %v", err)
+	}
+}
+
+func TestClickPipelineStampsClickTime(t *testing.T) {
+	p := NewClickPipeline(nil, 1, 1, 10, time.Second, false, "salt")
+	before := time.Now()
+	p.Enqueue(models.Click{URLID: 1})
+
+	click := <-p.queue
+	if click.ClickedAt.Before(before) {
+		t.Fatalf("Expected click time to be set at enqueue, got %s", click.ClickedAt)
+	}
+}
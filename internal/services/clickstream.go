@@ -0,0 +1,116 @@
+package services
+
+import (
+	"errors"
+	"sync"
+
+	"minify/internal/metrics"
+	"minify/internal/models"
+)
+
+var (
+	ErrStreamFull   = errors.New("too many live click streams")
+	ErrStreamClosed = errors.New("click stream is shut down")
+)
+
+// ClickStream fans recorded clicks out to live subscribers. Publishing never blocks the redirect:
+// a subscriber whose buffer is full is evicted, its channel closed so it can reconnect
+type ClickStream struct {
+	bufferSize     int
+	maxSubscribers int
+
+	mu     sync.Mutex
+	subs   map[*ClickSubscription]struct{}
+	closed bool
+}
+
+// ClickSubscription receives the clicks of one live stream, optionally only one owner's links
+type ClickSubscription struct {
+	events  chan models.ClickEvent
+	ownerID *int
+	stream  *ClickStream
+}
+
+func NewClickStream(bufferSize, maxSubscribers int) *ClickStream {
+	return &ClickStream{
+		bufferSize:     bufferSize,
+		maxSubscribers: maxSubscribers,
+		subs:           make(map[*ClickSubscription]struct{}),
+	}
+}
+
+// Subscribe opens a stream of every click, or only clicks on ownerID's links if it isn't nil
+func (s *ClickStream) Subscribe(ownerID *int) (*ClickSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrStreamClosed
+	}
+	if len(s.subs) >= s.maxSubscribers {
+		return nil, ErrStreamFull
+	}
+
+	sub := &ClickSubscription{events: make(chan models.ClickEvent, s.bufferSize), ownerID: ownerID, stream: s}
+	s.subs[sub] = struct{}{}
+	metrics.SetClickStreamSubscribers(float64(len(s.subs)))
+
+	return sub, nil
+}
+
+// Publish hands a click to every matching subscriber, evicting those that have fallen behind
+func (s *ClickStream) Publish(event models.ClickEvent) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sub := range s.subs {
+		if sub.ownerID != nil && (event.OwnerID == nil || *event.OwnerID != *sub.ownerID) {
+			continue
+		}
+
+		select {
+		case sub.events <- event:
+		default:
+			s.remove(sub)
+			metrics.RecordClickStreamEviction()
+		}
+	}
+}
+
+// Close ends every subscription and rejects new ones, so streaming responses finish on shutdown
+func (s *ClickStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for sub := range s.subs {
+		s.remove(sub)
+	}
+}
+
+// remove drops a subscriber and closes its channel, the caller must hold mu
+func (s *ClickStream) remove(sub *ClickSubscription) {
+	if _, ok := s.subs[sub]; !ok {
+		return
+	}
+	delete(s.subs, sub)
+	close(sub.events)
+	metrics.SetClickStreamSubscribers(float64(len(s.subs)))
+}
+
+// Events delivers the subscription's clicks, it's closed when the subscriber is evicted or the
+// stream shuts down
+func (sub *ClickSubscription) Events() <-chan models.ClickEvent {
+	return sub.events
+}
+
+// Close unsubscribes, it's safe to call after an eviction
+func (sub *ClickSubscription) Close() {
+	sub.stream.mu.Lock()
+	defer sub.stream.mu.Unlock()
+
+	sub.stream.remove(sub)
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"minify/internal/models"
+)
+
+func TestClickStreamFiltersByOwner(t *testing.T) {
+	s := NewClickStream(4, 10)
+	alice, bob := 1, 2
+
+	all, _ := s.Subscribe(nil)
+	mine, _ := s.Subscribe(&alice)
+	defer all.Close()
+	defer mine.Close()
+
+	s.Publish(models.ClickEvent{ShortCode: "bobs", OwnerID: &bob})
+	s.Publish(models.ClickEvent{ShortCode: "anon"})
+	s.Publish(models.ClickEvent{ShortCode: "alices", OwnerID: &alice})
+
+	if got := len(all.Events()); got != 3 {
+		t.Fatalf("Expected the unfiltered stream to get 3 clicks, got %d", got)
+	}
+	if got := len(mine.Events()); got != 1 {
+		t.Fatalf("Expected the owner's stream to get 1 click, got %d", got)
+	}
+	if event := <-mine.Events(); event.ShortCode != "alices" {
+		t.Fatalf("Expected the owner's click, got %s", event.ShortCode)
+	}
+}
+
+func TestClickStreamEvictsSlowSubscribers(t *testing.T) {
+	s := NewClickStream(2, 10)
+	slow, _ := s.Subscribe(nil)
+
+	for i := 0; i < 3; i++ {
+		s.Publish(models.ClickEvent{ShortCode: "abc"})
+	}
+
+	t.Log("The buffered clicks are still delivered before the channel closes")
+	received := 0
+	for range slow.Events() {
+		received++
+	}
+	if received != 2 {
+		t.Fatalf("Expected 2 buffered clicks, got %d", received)
+	}
+
+	slow.Close() // closing after an eviction is a no-op
+	if len(s.subs) != 0 {
+		t.Fatalf("Expected the slow subscriber to be removed, %d left", len(s.subs))
+	}
+}
+
+func TestClickStreamLimitsAndClose(t *testing.T) {
+	s := NewClickStream(1, 1)
+	sub, err := s.Subscribe(nil)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	if _, err := s.Subscribe(nil); !errors.Is(err, ErrStreamFull) {
+		t.Fatalf("Expected ErrStreamFull, got %v", err)
+	}
+
+	s.Close()
+	if _, ok := <-sub.Events(); ok {
+		t.Fatal("Expected subscriptions to end on close")
+	}
+	if _, err := s.Subscribe(nil); !errors.Is(err, ErrStreamClosed) {
+		t.Fatalf("Expected ErrStreamClosed after close, got %v", err)
+	}
+
+	// publishing to a closed or missing stream is a no-op
+	s.Publish(models.ClickEvent{ShortCode: "abc"})
+	var none *ClickStream
+	none.Publish(models.ClickEvent{ShortCode: "abc"})
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"minify/internal/models"
+
+	"github.com/lib/pq"
+)
+
+const (
+	exportFetchSize     = 1000 // rows fetched from the cursor at a time
+	maxExportShortCodes = 500
+)
+
+var ErrInvalidExportQuery = errors.New("invalid export query")
+
+// ExportQuery filters an export. From is inclusive and To exclusive, either can be zero for an open
+// range. Links are filtered by creation time and clicks by click time. An empty ShortCodes covers
+// all of the owner's links
+type ExportQuery struct {
+	From       time.Time
+	To         time.Time
+	ShortCodes []string
+}
+
+func (q *ExportQuery) Validate() error {
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidExportQuery)
+	}
+	if len(q.ShortCodes) > maxExportShortCodes {
+		return fmt.Errorf("%w: at most %d links can be selected", ErrInvalidExportQuery, maxExportShortCodes)
+	}
+
+	return nil
+}
+
+// args returns the query's from, to and short code params, open ends are NULL
+func (q *ExportQuery) args() []interface{} {
+	var from, to *time.Time
+	if !q.From.IsZero() {
+		t := q.From.UTC()
+		from = &t
+	}
+	if !q.To.IsZero() {
+		t := q.To.UTC()
+		to = &t
+	}
+	shortCodes := q.ShortCodes
+	if shortCodes == nil {
+		shortCodes = []string{}
+	}
+
+	return []interface{}{from, to, pq.Array(shortCodes)}
+}
+
+// ExportService streams a user's links and clicks for bulk export
+type ExportService struct {
+	db          *sql.DB
+	visitorSalt []byte
+}
+
+func NewExportService(db *sql.DB, visitorSalt string) *ExportService {
+	return &ExportService{db: db, visitorSalt: []byte(visitorSalt)}
+}
+
+// ExportLinks calls emit with each of the owner's links matching q, oldest first
+func (s *ExportService) ExportLinks(ctx context.Context, userID int, q ExportQuery, emit func(models.LinkExport) error) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+	log.Printf("[ExportService] Exporting links of user %d\n", userID)
+
+	query := `
+		SELECT short_code, original_url, clicks, max_clicks, active,
+			expired OR (expires_at IS NOT NULL AND expires_at <= NOW()) OR (max_clicks IS NOT NULL AND clicks >= max_clicks),
+			password_hash IS NOT NULL, expires_at, created_at, updated_at
+		FROM urls
+		WHERE user_id = $1 AND deleted_at IS NULL
+		AND ($2::timestamp IS NULL OR created_at >= $2)
+		AND ($3::timestamp IS NULL OR created_at < $3)
+		AND (cardinality($4::text[]) = 0 OR short_code = ANY($4))
+		ORDER BY created_at, id
+	`
+	args := append([]interface{}{userID}, q.args()...)
+
+	return s.streamCursor(ctx, query, args, func(rows *sql.Rows) error {
+		var link models.LinkExport
+		if err := rows.Scan(&link.ShortCode, &link.OriginalURL, &link.Clicks, &link.MaxClicks, &link.Active, &link.Expired,
+			&link.Protected, &link.ExpiresAt, &link.CreatedAt, &link.UpdatedAt); err != nil {
+			return fmt.Errorf("failed to scan link: %w", err)
+		}
+
+		return emit(link)
+	})
+}
+
+// ExportClicks calls emit with each click on the owner's links matching q, oldest first
+func (s *ExportService) ExportClicks(ctx context.Context, userID int, q ExportQuery, emit func(models.ClickExport) error) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+	log.Printf("[ExportService] Exporting clicks of user %d\n", userID)
+
+	query := `
+		SELECT u.short_code, c.clicked_at, COALESCE(c.ip_address, ''), COALESCE(c.user_agent, ''),
+			COALESCE(c.target_url, ''), c.variant_id, COALESCE(c.country, ''), COALESCE(c.region, ''),
+			COALESCE(c.browser, ''), COALESCE(c.browser_version, ''), COALESCE(c.os, ''), COALESCE(c.device, ''),
+			c.is_bot, COALESCE(c.bot_name, ''), COALESCE(c.referrer_domain, ''),
+			COALESCE(c.utm_source, ''), COALESCE(c.utm_medium, ''), COALESCE(c.utm_campaign, ''),
+			COALESCE(c.utm_term, ''), COALESCE(c.utm_content, '')
+		FROM clicks c
+		JOIN urls u ON u.id = c.url_id
+		WHERE u.user_id = $1 AND u.deleted_at IS NULL
+		AND ($2::timestamp IS NULL OR c.clicked_at >= $2)
+		AND ($3::timestamp IS NULL OR c.clicked_at < $3)
+		AND (cardinality($4::text[]) = 0 OR u.short_code = ANY($4))
+		ORDER BY c.clicked_at, c.id
+	`
+	args := append([]interface{}{userID}, q.args()...)
+
+	return s.streamCursor(ctx, query, args, func(rows *sql.Rows) error {
+		var click models.ClickExport
+		var ip, userAgent string
+		if err := rows.Scan(&click.ShortCode, &click.ClickedAt, &ip, &userAgent, &click.TargetURL, &click.VariantID,
+			&click.Country, &click.Region, &click.Browser, &click.BrowserVersion, &click.OS, &click.Device,
+			&click.IsBot, &click.BotName, &click.Referrer, &click.UTMSource, &click.UTMMedium, &click.UTMCampaign,
+			&click.UTMTerm, &click.UTMContent); err != nil {
+			return fmt.Errorf("failed to scan click: %w", err)
+		}
+		click.VisitorID = s.visitorID(ip, userAgent)
+
+		return emit(click)
+	})
+}
+
+// visitorID is the hex of the visitor hash counted by the unique visitor sketches, so exported
+// visitors match the dashboard's, "" when the click has neither an IP nor a user agent
+func (s *ExportService) visitorID(ip, userAgent string) string {
+	if ip == "" && userAgent == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%016x", visitorHash(s.visitorSalt, ip, userAgent))
+}
+
+// streamCursor runs query through a server-side cursor and calls scan for each row, fetching
+// exportFetchSize rows at a time so neither side holds the whole result in memory
+func (s *ExportService) streamCursor(ctx context.Context, query string, args []interface{}, scan func(*sql.Rows) error) error {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	return fetchCursor(ctx, tx, query, args, scan)
+}
+
+// fetchCursor declares a cursor for query in tx and scans its rows exportFetchSize at a time,
+// closing the cursor once they've all been read
+func fetchCursor(ctx context.Context, tx *sql.Tx, query string, args []interface{}, scan func(*sql.Rows) error) error {
+	if _, err := tx.ExecContext(ctx, `DECLARE export_cursor NO SCROLL CURSOR FOR `+query, args...); err != nil {
+		return fmt.Errorf("failed to open cursor: %w", err)
+	}
+
+	fetch := `FETCH FORWARD ` + strconv.Itoa(exportFetchSize) + ` FROM export_cursor`
+	for {
+		n, err := fetchRows(ctx, tx, fetch, scan)
+		if err != nil {
+			return err
+		}
+		if n < exportFetchSize {
+			break
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `CLOSE export_cursor`); err != nil {
+		return fmt.Errorf("failed to close cursor: %w", err)
+	}
+
+	return nil
+}
+
+// fetchRows runs one FETCH and scans its rows, returning how many there were
+func fetchRows(ctx context.Context, tx *sql.Tx, fetch string, scan func(*sql.Rows) error) (int, error) {
+	rows, err := tx.QueryContext(ctx, fetch)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch rows: %w", err)
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, rows.Err()
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExportQueryValidate(t *testing.T) {
+	from := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	valid := []ExportQuery{
+		{},
+		{From: from},
+		{To: from},
+		{From: from, To: from.Add(time.Hour), ShortCodes: []string{"abc"}},
+	}
+	for _, q := range valid {
+		if err := q.Validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", q, err)
+		}
+	}
+
+	invalid := []ExportQuery{
+		{From: from, To: from},
+		{From: from, To: from.Add(-time.Hour)},
+		{ShortCodes: make([]string, maxExportShortCodes+1)},
+	}
+	for _, q := range invalid {
+		if err := q.Validate(); !errors.Is(err, ErrInvalidExportQuery) {
+			t.Errorf("Expected ErrInvalidExportQuery for %+v, got %v", q, err)
+		}
+	}
+}
+
+func TestExportVisitorID(t *testing.T) {
+	s := NewExportService(nil, "salt")
+
+	id := s.visitorID("203.0.113.7", "Mozilla/5.0")
+	if len(id) != 16 || id != s.visitorID("203.0.113.7", "Mozilla/5.0") {
+		t.Fatalf("Expected a stable 16 digit hex id, got %q", id)
+	}
+	if id == s.visitorID("203.0.113.8", "Mozilla/5.0") || id == NewExportService(nil, "pepper").visitorID("203.0.113.7", "Mozilla/5.0") {
+		t.Fatal("Expected the id to depend on the visitor and the salt")
+	}
+	if s.visitorID("", "") != "" {
+		t.Fatal("Expected no id without an IP or user agent")
+	}
+}
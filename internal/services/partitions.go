@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"minify/internal/metrics"
+
+	"github.com/lib/pq"
+)
+
+const (
+	clickPartitionsAhead = 3                     // months of click partitions created past the current one
+	partitionBoundLayout = "2006-01-02 15:04:05" // how postgres prints timestamp partition bounds
+)
+
+// partitionBoundPattern matches the range bound of a clicks partition as printed by pg_get_expr
+var partitionBoundPattern = regexp.MustCompile(`^FOR VALUES FROM \((MINVALUE|'[^']*')\) TO \(('[^']*')\)$`)
+
+// clickPartition is a partition of the clicks table holding the clicks in [from, to). from is zero
+// for MINVALUE, the partition the unpartitioned table was converted into
+type clickPartition struct {
+	name string
+	from time.Time
+	to   time.Time
+}
+
+// parsePartitionBound reads the range of a partition from its bound, false for bounds that aren't
+// managed here such as a default partition
+func parsePartitionBound(bound string) (time.Time, time.Time, bool) {
+	m := partitionBoundPattern.FindStringSubmatch(bound)
+	if m == nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	var from time.Time
+	if m[1] != "MINVALUE" {
+		t, err := time.Parse(partitionBoundLayout, strings.Trim(m[1], "'"))
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+		from = t
+	}
+	to, err := time.Parse(partitionBoundLayout, strings.Trim(m[2], "'"))
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	return from, to, true
+}
+
+// nextClickPartitions returns the monthly partitions needed for the months up to ahead months past
+// now's to be covered, continuing from the end of the existing ones so there are no gaps
+func nextClickPartitions(existing []clickPartition, now time.Time, ahead int) []clickPartition {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := next.AddDate(0, ahead+1, 0)
+	if len(existing) > 0 {
+		next = existing[0].to
+		for _, p := range existing[1:] {
+			if p.to.After(next) {
+				next = p.to
+			}
+		}
+	}
+
+	var partitions []clickPartition
+	for next.Before(end) {
+		to := time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		partitions = append(partitions, clickPartition{name: "clicks_p" + next.Format("200601"), from: next, to: to})
+		next = to
+	}
+
+	return partitions
+}
+
+// EnsurePartitions creates the monthly clicks partitions through clickPartitionsAhead months from
+// now, clicks can't be written without a partition for their time
+func (s *RetentionService) EnsurePartitions(ctx context.Context) error {
+	_, err := s.ensurePartitions(ctx)
+
+	return err
+}
+
+// ensurePartitions creates the missing clicks partitions and returns them all, oldest first
+func (s *RetentionService) ensurePartitions(ctx context.Context) ([]clickPartition, error) {
+	partitions, err := s.partitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := nextClickPartitions(partitions, time.Now(), clickPartitionsAhead)
+	if len(missing) == 0 {
+		metrics.SetClickPartitions(float64(len(partitions)))
+
+		return partitions, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// instances starting together would otherwise race to create the same partitions
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('clicks_partitions'))`); err != nil {
+		return nil, fmt.Errorf("failed to lock partitions: %w", err)
+	}
+	for _, p := range missing {
+		query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s PARTITION OF clicks FOR VALUES FROM ('%s') TO ('%s')`,
+			pq.QuoteIdentifier(p.name), p.from.Format(partitionBoundLayout), p.to.Format(partitionBoundLayout))
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return nil, fmt.Errorf("failed to create partition %s: %w", p.name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit partitions: %w", err)
+	}
+
+	for _, p := range missing {
+		log.Printf("[RetentionService] Created partition %s\n", p.name)
+	}
+	partitions = append(partitions, missing...)
+	metrics.SetClickPartitions(float64(len(partitions)))
+
+	return partitions, nil
+}
+
+// partitions lists the range partitions of clicks, oldest first
+func (s *RetentionService) partitions(ctx context.Context) ([]clickPartition, error) {
+	query := `
+		SELECT c.relname, pg_get_expr(c.relpartbound, c.oid)
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'clicks'::regclass
+	`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions: %w", err)
+	}
+	defer rows.Close()
+
+	var partitions []clickPartition
+	for rows.Next() {
+		var name string
+		var bound sql.NullString
+		if err := rows.Scan(&name, &bound); err != nil {
+			return nil, fmt.Errorf("failed to scan partition: %w", err)
+		}
+		from, to, ok := parsePartitionBound(bound.String)
+		if !ok {
+			log.Printf("[RetentionService] Ignoring partition %s with bound %q\n", name, bound.String)
+			continue
+		}
+		partitions = append(partitions, clickPartition{name: name, from: from, to: to})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list partitions: %w", err)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].from.Before(partitions[j].from) })
+
+	return partitions, nil
+}
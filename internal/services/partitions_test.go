@@ -0,0 +1,59 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePartitionBound(t *testing.T) {
+	from, to, ok := parsePartitionBound("FOR VALUES FROM ('2024-03-01 00:00:00') TO ('2024-04-01 00:00:00')")
+	if !ok || !from.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) || !to.Equal(time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected bound [%s, %s), ok %v", from, to, ok)
+	}
+
+	from, to, ok = parsePartitionBound("FOR VALUES FROM (MINVALUE) TO ('2024-11-01 00:00:00')")
+	if !ok || !from.IsZero() || !to.Equal(time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Expected the converted table's partition to start at MINVALUE, got [%s, %s), ok %v", from, to, ok)
+	}
+
+	for _, bound := range []string{"DEFAULT", "FOR VALUES IN (1)", "FOR VALUES FROM ('2024-03-01') TO (MAXVALUE)", ""} {
+		if _, _, ok := parsePartitionBound(bound); ok {
+			t.Errorf("Expected %q not to be managed", bound)
+		}
+	}
+}
+
+func TestNextClickPartitions(t *testing.T) {
+	now := time.Date(2024, 11, 17, 12, 0, 0, 0, time.UTC)
+	month := func(year int, m time.Month) time.Time { return time.Date(year, m, 1, 0, 0, 0, 0, time.UTC) }
+
+	t.Log("Partitions continue from the converted table through 3 months ahead")
+	existing := []clickPartition{{name: "clicks_legacy", to: month(2024, 12)}}
+	got := nextClickPartitions(existing, now, 3)
+	want := []string{"clicks_p202412", "clicks_p202501", "clicks_p202502"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d partitions, got %+v", len(want), got)
+	}
+	for i, p := range got {
+		if p.name != want[i] || !p.from.Equal(month(2024, 12).AddDate(0, i, 0)) || !p.to.Equal(p.from.AddDate(0, 1, 0)) {
+			t.Fatalf("Unexpected partition %d: %+v", i, p)
+		}
+	}
+
+	t.Log("Nothing is created when the months ahead are covered")
+	existing = append(existing, got...)
+	if got := nextClickPartitions(existing, now, 3); len(got) != 0 {
+		t.Fatalf("Expected no partitions, got %+v", got)
+	}
+
+	t.Log("Months missed while the server was down are filled in")
+	existing = []clickPartition{{name: "clicks_p202407", from: month(2024, 7), to: month(2024, 8)}}
+	if got := nextClickPartitions(existing, now, 0); len(got) != 4 || got[0].name != "clicks_p202408" || got[3].name != "clicks_p202411" {
+		t.Fatalf("Expected August through November, got %+v", got)
+	}
+
+	t.Log("Without partitions the current month is the first")
+	if got := nextClickPartitions(nil, now, 1); len(got) != 2 || got[0].name != "clicks_p202411" {
+		t.Fatalf("Expected November and December, got %+v", got)
+	}
+}
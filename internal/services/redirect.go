@@ -0,0 +1,87 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	neturl "net/url"
+	"path"
+	"strings"
+
+	"minify/internal/models"
+)
+
+// query forwarding modes for models.URL.ForwardQuery
+const (
+	ForwardQueryOff             = ""                 // incoming query string is dropped
+	ForwardQueryKeepDestination = "keep_destination" // params already on the destination win conflicts
+	ForwardQueryPreferIncoming  = "prefer_incoming"  // incoming params replace the destination's on conflict
+)
+
+var (
+	ErrInvalidRedirectType = errors.New("redirect_type must be one of 301, 302, 307, 308")
+	ErrInvalidForwardQuery = errors.New("forward_query must be empty, keep_destination or prefer_incoming")
+	ErrPathNotForwarded    = errors.New("URL does not forward extra path segments")
+)
+
+// ValidateRedirectType checks for a supported redirect status. 301/308 are cached by browsers, so
+// disabling or expiring such a link won't affect visitors who already followed it
+func ValidateRedirectType(status int) error {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return nil
+	default:
+		return ErrInvalidRedirectType
+	}
+}
+
+// ValidateForwardQuery checks for a supported query forwarding mode
+func ValidateForwardQuery(mode string) error {
+	switch mode {
+	case ForwardQueryOff, ForwardQueryKeepDestination, ForwardQueryPreferIncoming:
+		return nil
+	default:
+		return ErrInvalidForwardQuery
+	}
+}
+
+// QRPathSegment after a short code serves the link's QR code, so it's the one extra path never forwarded
+const QRPathSegment = "qr"
+
+// BuildDestination applies a link's passthrough settings to its target: extraPath (anything after the
+// short code) is appended to the destination path and the incoming query is merged into its query
+func BuildDestination(url *models.URL, target, extraPath string, incoming neturl.Values) (string, error) {
+	if extraPath != "" && (!url.ForwardPath || extraPath == QRPathSegment) {
+		return "", ErrPathNotForwarded
+	}
+	if extraPath == "" && (url.ForwardQuery == ForwardQueryOff || len(incoming) == 0) {
+		return target, nil
+	}
+
+	dest, err := neturl.Parse(target)
+	if err != nil {
+		return "", err
+	}
+
+	if extraPath != "" {
+		// cleaning stops "../" segments from escaping the destination path
+		cleaned := path.Clean("/" + extraPath)
+		if strings.HasSuffix(extraPath, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		dest.Path = strings.TrimRight(dest.Path, "/") + cleaned
+		dest.RawPath = ""
+	}
+
+	if url.ForwardQuery != ForwardQueryOff && len(incoming) > 0 {
+		query := dest.Query()
+		for key, values := range incoming {
+			if _, exists := query[key]; exists && url.ForwardQuery == ForwardQueryKeepDestination {
+				continue
+			}
+			query[key] = values
+		}
+		dest.RawQuery = query.Encode()
+	}
+
+	return dest.String(), nil
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"minify/internal/models"
+)
+
+func TestBuildDestination(t *testing.T) {
+	cases := []struct {
+		name      string
+		link      models.URL
+		target    string
+		extraPath string
+		query     string
+		want      string
+	}{
+		{"no forwarding", models.URL{}, "https://example.com/a?x=1", "", "y=2", "https://example.com/a?x=1"},
+		{"merge keeps destination", models.URL{ForwardQuery: ForwardQueryKeepDestination}, "https://example.com/a?x=1", "", "x=9&y=2", "https://example.com/a?x=1&y=2"},
+		{"merge prefers incoming", models.URL{ForwardQuery: ForwardQueryPreferIncoming}, "https://example.com/a?x=1", "", "x=9&y=2", "https://example.com/a?x=9&y=2"},
+		{"path appended", models.URL{ForwardPath: true}, "https://example.com/base/", "docs/page", "", "https://example.com/base/docs/page"},
+		{"path trailing slash kept", models.URL{ForwardPath: true}, "https://example.com", "docs/", "", "https://example.com/docs/"},
+		{"path cannot escape", models.URL{ForwardPath: true}, "https://example.com/base", "../../etc", "", "https://example.com/base/etc"},
+		{"path and query", models.URL{ForwardPath: true, ForwardQuery: ForwardQueryKeepDestination}, "https://example.com/base", "p", "q=1", "https://example.com/base/p?q=1"},
+	}
+
+	for _, c := range cases {
+		query, _ := url.ParseQuery(c.query)
+		got, err := BuildDestination(&c.link, c.target, c.extraPath, query)
+		if err != nil {
+			t.Fatalf("%s: BuildDestination failed: %v", c.name, err)
+		}
+		if got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestBuildDestinationRejectsPathWithoutForwarding(t *testing.T) {
+	_, err := BuildDestination(&models.URL{}, "https://example.com", "docs", nil)
+	if !errors.Is(err, ErrPathNotForwarded) {
+		t.Fatalf("Expected ErrPathNotForwarded, got %v", err)
+	}
+
+	t.Log("A bare qr segment is the QR code, even on links that forward paths")
+	_, err = BuildDestination(&models.URL{ForwardPath: true}, "https://example.com", QRPathSegment, nil)
+	if !errors.Is(err, ErrPathNotForwarded) {
+		t.Fatalf("Expected ErrPathNotForwarded for %q, got %v", QRPathSegment, err)
+	}
+	if got, err := BuildDestination(&models.URL{ForwardPath: true}, "https://example.com", "docs/qr", nil); err != nil || got != "https://example.com/docs/qr" {
+		t.Fatalf("Expected a nested qr segment to be forwarded, got %q, %v", got, err)
+	}
+}
+
+func TestValidateRedirectType(t *testing.T) {
+	for _, status := range []int{301, 302, 307, 308} {
+		if err := ValidateRedirectType(status); err != nil {
+			t.Errorf("Expected %d to be valid, got %v", status, err)
+		}
+	}
+	for _, status := range []int{0, 200, 303, 404} {
+		if err := ValidateRedirectType(status); err == nil {
+			t.Errorf("Expected %d to be rejected", status)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"minify/internal/metrics"
+	"minify/internal/models"
+
+	"github.com/lib/pq"
+)
+
+const (
+	retentionChunkSize   = 5000 // raw clicks deleted, or archived, at a time
+	retentionLockTimeout = "5s" // how long a partition drop waits for queries on clicks before trying again next run
+)
+
+// archiveColumns selects a click for scanArchivedClick
+const archiveColumns = `id, COALESCE(url_id, 0), COALESCE(user_agent, ''), COALESCE(ip_address, ''),
+	COALESCE(target_url, ''), COALESCE(country, ''), COALESCE(region, ''), variant_id,
+	COALESCE(browser, ''), COALESCE(browser_version, ''), COALESCE(os, ''), COALESCE(device, ''),
+	is_bot, COALESCE(bot_name, ''), COALESCE(referrer_domain, ''), COALESCE(utm_source, ''),
+	COALESCE(utm_medium, ''), COALESCE(utm_campaign, ''), COALESCE(utm_term, ''), COALESCE(utm_content, ''),
+	clicked_at`
+
+// RetentionService keeps the monthly clicks partitions ahead of time and prunes raw clicks older than
+// the retention once they're in the rollups, which are kept forever. Partitions entirely past the
+// cutoff are dropped whole, the rest is deleted in chunks so locks are only held briefly. Pruned
+// clicks are written to gzipped NDJSON files first if an archive directory is set
+type RetentionService struct {
+	db         *sql.DB
+	retention  time.Duration // how long raw clicks are kept, 0 keeps them forever
+	archiveDir string        // where pruned clicks are archived, they're not if empty
+}
+
+func NewRetentionService(db *sql.DB, retention time.Duration, archiveDir string) *RetentionService {
+	return &RetentionService{db: db, retention: retention, archiveDir: archiveDir}
+}
+
+// Run enforces the retention every interval until ctx is cancelled
+func (s *RetentionService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Enforce(ctx); err != nil {
+				log.Println("[RetentionService] Enforcing click retention failed:", err)
+			}
+		}
+	}
+}
+
+// Enforce creates the upcoming partitions and prunes the raw clicks past the retention
+func (s *RetentionService) Enforce(ctx context.Context) error {
+	start := time.Now()
+	err := s.enforce(ctx)
+	metrics.RecordRetentionRun(err == nil, time.Since(start))
+
+	return err
+}
+
+func (s *RetentionService) enforce(ctx context.Context) error {
+	partitions, err := s.ensurePartitions(ctx)
+	if err != nil || s.retention <= 0 {
+		return err
+	}
+
+	cutoff, ok, err := s.cutoff(ctx)
+	if err != nil || !ok {
+		return err
+	}
+	metrics.SetRetentionCutoff(cutoff)
+	// recorded before anything is pruned, so stats never count a partly pruned range as complete
+	if err := s.markPruned(ctx, cutoff); err != nil {
+		return err
+	}
+
+	dropped := 0
+	defer func() { metrics.SetClickPartitions(float64(len(partitions) - dropped)) }()
+	for _, p := range partitions {
+		if p.to.After(cutoff) {
+			continue
+		}
+		if err := s.dropPartition(ctx, p); err != nil {
+			return err
+		}
+		dropped++
+	}
+
+	return s.pruneBefore(ctx, cutoff)
+}
+
+// cutoff returns the time raw clicks are pruned before, false if none can be pruned yet. Only
+// rolled up clicks are pruned, so their totals are still counted
+func (s *RetentionService) cutoff(ctx context.Context) (time.Time, bool, error) {
+	var rolled rolledRange
+	query := `SELECT rolled_from, rolled_to FROM rollup_state WHERE name = $1`
+	err := s.db.QueryRowContext(ctx, query, rollupName).Scan(&rolled.from, &rolled.to)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Println("[RetentionService] Clicks aren't rolled up yet, none are pruned")
+
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to read rollup state: %w", err)
+	}
+	rolled.from, rolled.to = rolled.from.UTC(), rolled.to.UTC()
+
+	var unrolled bool
+	query = `SELECT EXISTS (SELECT 1 FROM clicks WHERE clicked_at < $1)`
+	if err := s.db.QueryRowContext(ctx, query, rolled.from).Scan(&unrolled); err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to check for clicks that aren't rolled up: %w", err)
+	}
+	if unrolled {
+		log.Printf("[RetentionService] Clicks before %s aren't rolled up, none are pruned until backfill-rollups is run\n",
+			rolled.from.Format(time.RFC3339))
+
+		return time.Time{}, false, nil
+	}
+
+	return retentionCutoff(time.Now(), s.retention, rolled), true, nil
+}
+
+// markPruned records that raw clicks are pruned before cutoff, for the stats only raw clicks can answer
+func (s *RetentionService) markPruned(ctx context.Context, cutoff time.Time) error {
+	query := `UPDATE rollup_state SET pruned_before = GREATEST(pruned_before, $2) WHERE name = $1`
+	if _, err := s.db.ExecContext(ctx, query, rollupName, cutoff.UTC()); err != nil {
+		return fmt.Errorf("failed to record retention cutoff: %w", err)
+	}
+
+	return nil
+}
+
+// retentionCutoff is retention before now, or the end of the rolled range if that's earlier
+func retentionCutoff(now time.Time, retention time.Duration, rolled rolledRange) time.Time {
+	cutoff := now.UTC().Add(-retention)
+	if rolled.to.Before(cutoff) {
+		return rolled.to
+	}
+
+	return cutoff
+}
+
+// dropPartition archives and drops a partition whose clicks are all past the cutoff
+func (s *RetentionService) dropPartition(ctx context.Context, p clickPartition) error {
+	var archive *clickArchive
+	if s.archiveDir != "" {
+		var err error
+		if archive, err = createClickArchive(s.archiveDir, p.name); err != nil {
+			return err
+		}
+	}
+
+	pruned, err := s.drop(ctx, p, archive)
+	if archive != nil {
+		if err == nil {
+			metrics.RecordClicksArchived(archive.keep())
+		}
+		if closeErr := archive.close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	metrics.RecordClicksPruned(pruned)
+	metrics.RecordClickPartitionDropped()
+	log.Printf("[RetentionService] Dropped partition %s with %d clicks\n", p.name, pruned)
+
+	return nil
+}
+
+// drop archives the partition's clicks, or counts them, and drops it in one transaction. The
+// partition is locked against writes meanwhile, and the drop gives up after retentionLockTimeout
+// rather than queue every query on clicks behind it
+func (s *RetentionService) drop(ctx context.Context, p clickPartition, archive *clickArchive) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	table := pq.QuoteIdentifier(p.name)
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE `+table+` IN EXCLUSIVE MODE`); err != nil {
+		return 0, fmt.Errorf("failed to lock partition %s: %w", p.name, err)
+	}
+
+	pruned := 0
+	if archive == nil {
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+table).Scan(&pruned); err != nil {
+			return 0, fmt.Errorf("failed to count clicks in partition %s: %w", p.name, err)
+		}
+	} else {
+		batch := make([]models.Click, 0, retentionChunkSize)
+		err := fetchCursor(ctx, tx, `SELECT `+archiveColumns+` FROM `+table, nil, func(rows *sql.Rows) error {
+			click, err := scanArchivedClick(rows)
+			if err != nil {
+				return err
+			}
+			batch = append(batch, click)
+			pruned++
+			if len(batch) < retentionChunkSize {
+				return nil
+			}
+			err = archive.write(batch)
+			batch = batch[:0]
+
+			return err
+		})
+		if err == nil {
+			err = archive.write(batch)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to archive partition %s: %w", p.name, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `SET LOCAL lock_timeout = '`+retentionLockTimeout+`'`); err != nil {
+		return 0, fmt.Errorf("failed to set lock timeout: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DROP TABLE `+table); err != nil {
+		return 0, fmt.Errorf("failed to drop partition %s: %w", p.name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit partition drop: %w", err)
+	}
+
+	return pruned, nil
+}
+
+// pruneBefore deletes the remaining clicks before cutoff, a chunk per transaction, archiving the
+// whole run's clicks to one file
+func (s *RetentionService) pruneBefore(ctx context.Context, cutoff time.Time) error {
+	var archive *clickArchive
+	if s.archiveDir != "" {
+		var err error
+		if archive, err = createClickArchive(s.archiveDir, "clicks-"+time.Now().UTC().Format("20060102T150405Z")); err != nil {
+			return err
+		}
+	}
+
+	pruned := 0
+	var err error
+	for {
+		var n int
+		n, err = s.pruneChunk(ctx, cutoff.UTC(), archive)
+		pruned += n
+		if err != nil || n < retentionChunkSize {
+			break
+		}
+	}
+	if archive != nil {
+		if closeErr := archive.close(); err == nil {
+			err = closeErr
+		}
+	}
+	if pruned > 0 {
+		log.Printf("[RetentionService] Pruned %d clicks before %s\n", pruned, cutoff.Format(time.RFC3339))
+	}
+
+	return err
+}
+
+// pruneChunk deletes up to retentionChunkSize clicks before cutoff, archiving them before the delete
+// is committed, and returns how many it deleted
+func (s *RetentionService) pruneChunk(ctx context.Context, cutoff time.Time, archive *clickArchive) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	query := `
+		DELETE FROM clicks
+		WHERE clicked_at < $1 AND (id, clicked_at) IN (
+			SELECT id, clicked_at FROM clicks WHERE clicked_at < $1 LIMIT $2
+		)
+	`
+	if archive == nil {
+		res, err := s.db.ExecContext(ctx, query, cutoff, retentionChunkSize)
+		if err != nil {
+			return 0, fmt.Errorf("failed to prune clicks: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to prune clicks: %w", err)
+		}
+		metrics.RecordClicksPruned(int(n))
+
+		return int(n), nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query+` RETURNING `+archiveColumns, cutoff, retentionChunkSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune clicks: %w", err)
+	}
+	var clicks []models.Click
+	for rows.Next() {
+		click, err := scanArchivedClick(rows)
+		if err != nil {
+			rows.Close()
+
+			return 0, err
+		}
+		clicks = append(clicks, click)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to prune clicks: %w", err)
+	}
+
+	if err := archive.write(clicks); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit pruned clicks: %w", err)
+	}
+	metrics.RecordClicksArchived(archive.keep())
+	metrics.RecordClicksPruned(len(clicks))
+
+	return len(clicks), nil
+}
+
+func scanArchivedClick(rows *sql.Rows) (models.Click, error) {
+	var c models.Click
+	if err := rows.Scan(&c.ID, &c.URLID, &c.UserAgent, &c.IPAddress, &c.TargetURL, &c.Country, &c.Region, &c.VariantID,
+		&c.Browser, &c.BrowserVersion, &c.OS, &c.Device, &c.IsBot, &c.BotName, &c.Referrer, &c.UTMSource,
+		&c.UTMMedium, &c.UTMCampaign, &c.UTMTerm, &c.UTMContent, &c.ClickedAt); err != nil {
+		return c, fmt.Errorf("failed to scan click: %w", err)
+	}
+	c.ClickedAt = c.ClickedAt.UTC()
+
+	return c, nil
+}
@@ -0,0 +1,28 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetentionCutoff(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	retention := 90 * 24 * time.Hour
+	rolled := rolledRange{from: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), to: time.Date(2024, 6, 1, 11, 0, 0, 0, time.UTC)}
+
+	if got, want := retentionCutoff(now, retention, rolled), now.Add(-retention); !got.Equal(want) {
+		t.Fatalf("Expected the cutoff at %s, got %s", want, got)
+	}
+
+	t.Log("Clicks that aren't rolled up are never pruned, however old")
+	rolled.to = time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	if got := retentionCutoff(now, retention, rolled); !got.Equal(rolled.to) {
+		t.Fatalf("Expected the cutoff at the end of the rollups, got %s", got)
+	}
+
+	t.Log("The cutoff is in UTC")
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	if got := retentionCutoff(now.In(berlin), time.Hour, rolledRange{to: now}); got.Location() != time.UTC {
+		t.Fatalf("Expected a UTC cutoff, got %s", got)
+	}
+}
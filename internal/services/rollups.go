@@ -0,0 +1,367 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	rollupName  = "clicks"       // the rollup_state row tracking the click rollups
+	rollupChunk = 24 * time.Hour // clicks rolled up per transaction
+)
+
+// rolledRange is the range of clicks already in the rollups, [from, to) on hour boundaries.
+// It's empty until the aggregator first runs
+type rolledRange struct {
+	from time.Time
+	to   time.Time
+}
+
+// RollupAggregator keeps the hourly and daily click rollups up to date. Each run rolls up the whole
+// hours since the end of the rolled range, so every click is counted once
+type RollupAggregator struct {
+	db  *sql.DB
+	lag time.Duration // how long after an hour ends its clicks are rolled up, so queued clicks are written first
+}
+
+func NewRollupAggregator(db *sql.DB, lag time.Duration) *RollupAggregator {
+	return &RollupAggregator{db: db, lag: lag}
+}
+
+// Run rolls up new clicks every interval until ctx is cancelled
+func (a *RollupAggregator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := a.Aggregate(ctx); err != nil {
+				log.Println("[RollupAggregator] Rolling up clicks failed:", err)
+			}
+		}
+	}
+}
+
+// Aggregate rolls up clicks from the end of the rolled range to the last whole hour that ended at
+// least lag ago, returning the new end. The first run starts the range there, older clicks are
+// added by Backfill
+func (a *RollupAggregator) Aggregate(ctx context.Context) (time.Time, error) {
+	target := time.Now().UTC().Add(-a.lag).Truncate(time.Hour)
+
+	for {
+		rolled, advanced, err := a.advance(ctx, target, func(r rolledRange) (time.Time, time.Time, bool) {
+			if !r.to.Before(target) {
+				return r.to, r.to, false
+			}
+			end := r.to.Add(rollupChunk)
+			if end.After(target) {
+				end = target
+			}
+
+			return r.to, end, true
+		})
+		if err != nil || !advanced {
+			return rolled.to, err
+		}
+	}
+}
+
+// Backfill extends the rolled range back to the earliest click, a chunk at a time, so clicks recorded
+// before the aggregator first ran are read from the rollups too
+func (a *RollupAggregator) Backfill(ctx context.Context) error {
+	if _, err := a.Aggregate(ctx); err != nil {
+		return err
+	}
+
+	var earliest sql.NullTime
+	if err := a.db.QueryRowContext(ctx, `SELECT MIN(clicked_at) FROM clicks`).Scan(&earliest); err != nil {
+		return fmt.Errorf("failed to find earliest click: %w", err)
+	}
+	if !earliest.Valid {
+		return nil
+	}
+	start := earliest.Time.UTC().Truncate(time.Hour)
+
+	for {
+		rolled, advanced, err := a.advance(ctx, start, func(r rolledRange) (time.Time, time.Time, bool) {
+			if !start.Before(r.from) {
+				return r.from, r.from, false
+			}
+			begin := r.from.Add(-rollupChunk)
+			if begin.Before(start) {
+				begin = start
+			}
+
+			return begin, r.from, true
+		})
+		if err != nil {
+			return err
+		}
+		if !advanced {
+			return nil
+		}
+		log.Printf("[RollupAggregator] Backfilled rollups from %s\n", rolled.from.Format(time.RFC3339))
+	}
+}
+
+// advance rolls up the range next picks next to the rolled range, in one transaction with the
+// rolled range locked so instances don't roll up the same clicks. The rolled range starts empty
+// at start if it doesn't exist yet
+func (a *RollupAggregator) advance(ctx context.Context, start time.Time,
+	next func(rolledRange) (time.Time, time.Time, bool)) (rolledRange, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return rolledRange{}, false, err
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return rolledRange{}, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	init := `INSERT INTO rollup_state (name, rolled_from, rolled_to) VALUES ($1, $2, $2) ON CONFLICT (name) DO NOTHING`
+	if _, err := tx.Exec(init, rollupName, start); err != nil {
+		return rolledRange{}, false, fmt.Errorf("failed to start rollup state: %w", err)
+	}
+
+	var r rolledRange
+	query := `SELECT rolled_from, rolled_to FROM rollup_state WHERE name = $1 FOR UPDATE`
+	if err := tx.QueryRow(query, rollupName).Scan(&r.from, &r.to); err != nil {
+		return r, false, fmt.Errorf("failed to lock rollup state: %w", err)
+	}
+	r.from, r.to = r.from.UTC(), r.to.UTC()
+
+	from, to, ok := next(r)
+	if !ok {
+		return r, false, tx.Commit()
+	}
+	if err := rollUp(tx, from, to); err != nil {
+		return r, false, err
+	}
+
+	if from.Before(r.from) {
+		r.from = from
+	}
+	if to.After(r.to) {
+		r.to = to
+	}
+	query = `UPDATE rollup_state SET rolled_from = $2, rolled_to = $3 WHERE name = $1`
+	if _, err := tx.Exec(query, rollupName, r.from, r.to); err != nil {
+		return r, false, fmt.Errorf("failed to update rollup state: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return r, false, fmt.Errorf("failed to commit rollups: %w", err)
+	}
+
+	return r, true, nil
+}
+
+// rollUp rebuilds the hourly link and variant rollups for [from, to) from raw clicks, then the site
+// and daily rollups of the hours and days it touches from the hourly ones
+func rollUp(tx *sql.Tx, from, to time.Time) error {
+	dayFrom, dayTo := from.Truncate(24*time.Hour), ceilTo(to, 24*time.Hour)
+
+	steps := []struct {
+		what  string
+		query string
+		args  []interface{}
+	}{
+		{"clear hourly link rollups", `DELETE FROM click_rollups_hourly WHERE bucket >= $1 AND bucket < $2`, []interface{}{from, to}},
+		{"roll up hourly link clicks", `
+			INSERT INTO click_rollups_hourly (url_id, bucket, clicks, bot_clicks)
+			SELECT url_id, date_trunc('hour', clicked_at), COUNT(*) FILTER (WHERE NOT is_bot), COUNT(*) FILTER (WHERE is_bot)
+			FROM clicks
+			WHERE clicked_at >= $1 AND clicked_at < $2 AND url_id IS NOT NULL
+			GROUP BY 1, 2
+		`, []interface{}{from, to}},
+		{"clear hourly variant rollups", `DELETE FROM variant_rollups_hourly WHERE bucket >= $1 AND bucket < $2`, []interface{}{from, to}},
+		{"roll up hourly variant clicks", `
+			INSERT INTO variant_rollups_hourly (variant_id, bucket, clicks, bot_clicks)
+			SELECT c.variant_id, date_trunc('hour', c.clicked_at), COUNT(*) FILTER (WHERE NOT c.is_bot), COUNT(*) FILTER (WHERE c.is_bot)
+			FROM clicks c
+			JOIN url_destinations d ON d.id = c.variant_id
+			WHERE c.clicked_at >= $1 AND c.clicked_at < $2
+			GROUP BY 1, 2
+		`, []interface{}{from, to}},
+		{"clear hourly site rollups", `DELETE FROM site_rollups_hourly WHERE bucket >= $1 AND bucket < $2`, []interface{}{from, to}},
+		{"roll up hourly site clicks", `
+			INSERT INTO site_rollups_hourly (bucket, clicks, bot_clicks)
+			SELECT bucket, SUM(clicks), SUM(bot_clicks)
+			FROM click_rollups_hourly
+			WHERE bucket >= $1 AND bucket < $2
+			GROUP BY 1
+		`, []interface{}{from, to}},
+		{"clear daily link rollups", `DELETE FROM click_rollups_daily WHERE bucket >= $1 AND bucket < $2`, []interface{}{dayFrom, dayTo}},
+		{"roll up daily link clicks", `
+			INSERT INTO click_rollups_daily (url_id, bucket, clicks, bot_clicks)
+			SELECT url_id, date_trunc('day', bucket), SUM(clicks), SUM(bot_clicks)
+			FROM click_rollups_hourly
+			WHERE bucket >= $1 AND bucket < $2
+			GROUP BY 1, 2
+		`, []interface{}{dayFrom, dayTo}},
+		{"clear daily site rollups", `DELETE FROM site_rollups_daily WHERE bucket >= $1 AND bucket < $2`, []interface{}{dayFrom, dayTo}},
+		{"roll up daily site clicks", `
+			INSERT INTO site_rollups_daily (bucket, clicks, bot_clicks)
+			SELECT date_trunc('day', bucket), SUM(clicks), SUM(bot_clicks)
+			FROM site_rollups_hourly
+			WHERE bucket >= $1 AND bucket < $2
+			GROUP BY 1
+		`, []interface{}{dayFrom, dayTo}},
+	}
+	for _, step := range steps {
+		if _, err := tx.Exec(step.query, step.args...); err != nil {
+			return fmt.Errorf("failed to %s: %w", step.what, err)
+		}
+	}
+
+	return nil
+}
+
+// rollupPlan splits [from, to) by where its clicks are read from: daily rollups for the whole UTC
+// days in [dayFrom, dayTo), hourly rollups for the other whole hours in [hourFrom, hourTo), and raw
+// clicks for the partial hours at the edges and anything outside the rolled range
+type rollupPlan struct {
+	from, to         time.Time
+	hourFrom, hourTo time.Time
+	dayFrom, dayTo   time.Time
+}
+
+// planRollups reads as much of [from, to) from the rollups as the rolled range allows. An empty
+// rolled range reads everything from raw clicks. Times are converted to UTC, the timestamps they're
+// compared against
+func planRollups(from, to time.Time, rolled rolledRange) rollupPlan {
+	from, to = from.UTC(), to.UTC()
+	p := rollupPlan{from: from, to: to, hourFrom: from, hourTo: from, dayFrom: from, dayTo: from}
+
+	hourFrom, hourTo := ceilTo(from, time.Hour), to.Truncate(time.Hour)
+	if hourFrom.Before(rolled.from) {
+		hourFrom = rolled.from
+	}
+	if rolled.to.Before(hourTo) {
+		hourTo = rolled.to
+	}
+	if !hourFrom.Before(hourTo) {
+		return p
+	}
+	p.hourFrom, p.hourTo = hourFrom, hourTo
+	p.dayFrom, p.dayTo = hourFrom, hourFrom
+
+	if dayFrom, dayTo := ceilTo(hourFrom, 24*time.Hour), hourTo.Truncate(24*time.Hour); dayFrom.Before(dayTo) {
+		p.dayFrom, p.dayTo = dayFrom, dayTo
+	}
+
+	return p
+}
+
+// ceilTo rounds t up to a multiple of d since the zero time, which for hours and days is UTC aligned
+func ceilTo(t time.Time, d time.Duration) time.Time {
+	c := t.Truncate(d)
+	if c.Before(t) {
+		c = c.Add(d)
+	}
+
+	return c
+}
+
+// alignsToHours reports whether loc is a whole number of hours off UTC at both ends of a range, so
+// hourly rollups fall entirely into its local buckets
+func alignsToHours(loc *time.Location, from, to time.Time) bool {
+	for _, t := range []time.Time{from, to} {
+		if _, offset := t.In(loc).Zone(); offset%3600 != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rollupRange returns the range of clicks in the rollups
+func (s *AnalyticsService) rollupRange() (rolledRange, error) {
+	var r rolledRange
+	err := s.db.QueryRow(`SELECT rolled_from, rolled_to FROM rollup_state WHERE name = $1`, rollupName).Scan(&r.from, &r.to)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, nil
+	}
+	if err != nil {
+		return r, fmt.Errorf("failed to get rollup state: %w", err)
+	}
+
+	return rolledRange{from: r.from.UTC(), to: r.to.UTC()}, nil
+}
+
+// RawClicksFrom returns the time raw clicks are pruned before, zero if none have been. Reports read
+// from raw clicks only cover the clicks since
+func (s *AnalyticsService) RawClicksFrom() (time.Time, error) {
+	var t sql.NullTime
+	err := s.db.QueryRow(`SELECT pruned_before FROM rollup_state WHERE name = $1`, rollupName).Scan(&t)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, fmt.Errorf("failed to get retention cutoff: %w", err)
+	}
+
+	return t.Time.UTC(), nil
+}
+
+// rollupClicks is the SQL sum of a rollup row's clicks, bot clicks are added only if they're counted
+func (s *AnalyticsService) rollupClicks() string {
+	if s.countBots {
+		return "clicks + bot_clicks"
+	}
+
+	return "clicks"
+}
+
+// rollupTables returns the hourly and daily rollup tables for one link or the whole service, and a
+// condition selecting the link ($1) from them
+func rollupTables(urlID *int) (hourly, daily, cond string) {
+	if urlID == nil {
+		return "site_rollups_hourly", "site_rollups_daily", "$1::int IS NULL"
+	}
+
+	return "click_rollups_hourly", "click_rollups_daily", "url_id = $1"
+}
+
+// countClicks counts the clicks in a plan's range, for one link or the whole service if urlID is nil
+func (s *AnalyticsService) countClicks(urlID *int, p rollupPlan) (int, error) {
+	hourly, daily, cond := rollupTables(urlID)
+	query := fmt.Sprintf(`
+		SELECT
+			(SELECT COALESCE(SUM(%[4]s), 0)::bigint FROM %[2]s WHERE %[3]s AND bucket >= $2 AND bucket < $3)
+			+ (SELECT COALESCE(SUM(%[4]s), 0)::bigint FROM %[1]s
+				WHERE %[3]s AND ((bucket >= $4 AND bucket < $2) OR (bucket >= $3 AND bucket < $5)))
+			+ (SELECT COUNT(*) FROM clicks
+				WHERE ($1::int IS NULL OR url_id = $1)
+				AND ((clicked_at >= $6 AND clicked_at < $4) OR (clicked_at >= $5 AND clicked_at < $7))
+				AND %[5]s)
+	`, hourly, daily, cond, s.rollupClicks(), s.clickFilter())
+
+	var n int
+	err := s.db.QueryRow(query, urlID, p.dayFrom, p.dayTo, p.hourFrom, p.hourTo, p.from, p.to).Scan(&n)
+
+	return n, err
+}
+
+// rolledClicks is a subquery of (at, clicks) rows covering a plan's range: a row per hourly rollup
+// in [hourFrom, hourTo) and a row per raw click outside it. It takes the link id (NULL for the whole
+// service) as $1, and from, hourFrom, hourTo and to as $2 to $5
+func (s *AnalyticsService) rolledClicks(urlID *int) string {
+	hourly, _, cond := rollupTables(urlID)
+
+	return fmt.Sprintf(`(
+		SELECT bucket AS at, %[3]s AS clicks
+		FROM %[1]s
+		WHERE %[2]s AND bucket >= $3 AND bucket < $4
+		UNION ALL
+		SELECT clicked_at, 1
+		FROM clicks
+		WHERE ($1::int IS NULL OR url_id = $1)
+		AND ((clicked_at >= $2 AND clicked_at < $3) OR (clicked_at >= $4 AND clicked_at < $5))
+		AND %[4]s
+	)`, hourly, cond, s.rollupClicks(), s.clickFilter())
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlanRollups(t *testing.T) {
+	at := func(day, hour, minute int) time.Time { return time.Date(2024, 3, day, hour, minute, 0, 0, time.UTC) }
+	rolled := rolledRange{from: at(1, 0, 0), to: at(20, 6, 0)}
+
+	tests := []struct {
+		name             string
+		from, to         time.Time
+		rolled           rolledRange
+		hourFrom, hourTo time.Time
+		dayFrom, dayTo   time.Time
+	}{
+		{
+			name: "partial hours at the edges, whole days in the middle",
+			from: at(10, 9, 30), to: at(13, 14, 15), rolled: rolled,
+			hourFrom: at(10, 10, 0), hourTo: at(13, 14, 0),
+			dayFrom: at(11, 0, 0), dayTo: at(13, 0, 0),
+		},
+		{
+			name: "no whole day, hourly only",
+			from: at(10, 9, 0), to: at(10, 18, 0), rolled: rolled,
+			hourFrom: at(10, 9, 0), hourTo: at(10, 18, 0),
+			dayFrom: at(10, 9, 0), dayTo: at(10, 9, 0),
+		},
+		{
+			name: "tail past the rolled range is raw",
+			from: at(19, 0, 0), to: at(25, 0, 0), rolled: rolled,
+			hourFrom: at(19, 0, 0), hourTo: at(20, 6, 0),
+			dayFrom: at(19, 0, 0), dayTo: at(20, 0, 0),
+		},
+		{
+			name: "before the rolled range is raw",
+			from: time.Time{}, to: at(2, 12, 0), rolled: rolled,
+			hourFrom: at(1, 0, 0), hourTo: at(2, 12, 0),
+			dayFrom: at(1, 0, 0), dayTo: at(2, 0, 0),
+		},
+		{
+			name: "within one hour, all raw",
+			from: at(10, 9, 10), to: at(10, 9, 50), rolled: rolled,
+			hourFrom: at(10, 9, 10), hourTo: at(10, 9, 10),
+			dayFrom: at(10, 9, 10), dayTo: at(10, 9, 10),
+		},
+		{
+			name: "nothing rolled up yet, all raw",
+			from: at(10, 0, 0), to: at(12, 0, 0),
+			hourFrom: at(10, 0, 0), hourTo: at(10, 0, 0),
+			dayFrom: at(10, 0, 0), dayTo: at(10, 0, 0),
+		},
+	}
+	for _, tt := range tests {
+		p := planRollups(tt.from, tt.to, tt.rolled)
+		if !p.hourFrom.Equal(tt.hourFrom) || !p.hourTo.Equal(tt.hourTo) || !p.dayFrom.Equal(tt.dayFrom) || !p.dayTo.Equal(tt.dayTo) {
+			t.Errorf("%s: got hours [%s, %s) days [%s, %s)", tt.name, p.hourFrom, p.hourTo, p.dayFrom, p.dayTo)
+		}
+		if !p.from.Equal(tt.from) || !p.to.Equal(tt.to) {
+			t.Errorf("%s: expected the plan to keep its range", tt.name)
+		}
+	}
+}
+
+func TestPlanRollupsWithLocalTimes(t *testing.T) {
+	t.Log("Rollups are aligned to UTC hours and days whatever the location of the range's times")
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	rolled := rolledRange{from: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), to: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)}
+
+	p := planRollups(time.Date(2024, 1, 10, 0, 30, 0, 0, berlin), time.Date(2024, 1, 12, 0, 0, 0, 0, berlin), rolled)
+	if want := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC); !p.hourFrom.Equal(want) {
+		t.Fatalf("Expected hourly rollups from %s, got %s", want, p.hourFrom)
+	}
+	if want := time.Date(2024, 1, 11, 23, 0, 0, 0, time.UTC); !p.hourTo.Equal(want) {
+		t.Fatalf("Expected hourly rollups to %s, got %s", want, p.hourTo)
+	}
+	if from, to := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC); !p.dayFrom.Equal(from) || !p.dayTo.Equal(to) {
+		t.Fatalf("Expected daily rollups for the one whole UTC day, got [%s, %s)", p.dayFrom, p.dayTo)
+	}
+
+	t.Log("Every boundary is in UTC, postgres drops the offset when comparing with a timestamp")
+	for _, b := range []time.Time{p.from, p.to, p.hourFrom, p.hourTo, p.dayFrom, p.dayTo} {
+		if b.Location() != time.UTC {
+			t.Fatalf("Expected UTC boundaries, got %s", b)
+		}
+	}
+}
+
+func TestAlignsToHours(t *testing.T) {
+	from, to := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	for name, want := range map[string]bool{
+		"UTC":              true,
+		"Europe/Berlin":    true,
+		"America/New_York": true,
+		"Asia/Kolkata":     false,
+		"Asia/Kathmandu":   false,
+	} {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			t.Fatalf("Failed to load %s: %v", name, err)
+		}
+		if got := alignsToHours(loc, from, to); got != want {
+			t.Errorf("alignsToHours(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"minify/internal/models"
+)
+
+const (
+	maxStatsBuckets   = 2000
+	statsBreakdownTop = 10
+)
+
+var ErrInvalidStatsQuery = errors.New("invalid stats query")
+
+// granularities are the supported time series bucket sizes
+var granularities = map[string]time.Duration{
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// statsBreakdowns maps each breakdown to its clicks column. Column names can't be query
+// parameters, so only these fixed names are ever formatted into SQL
+var statsBreakdowns = map[string]string{
+	"browsers":  "browser",
+	"os":        "os",
+	"countries": "country",
+	"referrers": "referrer_domain",
+}
+
+// StatsQuery selects the clicks covered by a link's stats, From inclusive and To exclusive
+type StatsQuery struct {
+	From        time.Time
+	To          time.Time
+	Granularity string // minute, hour or day, picked from the range if empty
+}
+
+// Normalize fills in defaults (the last 7 days, and a granularity suited to the range) and
+// checks the range is valid and doesn't produce too many buckets
+func (q *StatsQuery) Normalize(now time.Time) error {
+	if q.To.IsZero() {
+		q.To = now
+	}
+	if q.From.IsZero() {
+		q.From = q.To.Add(-7 * 24 * time.Hour)
+	}
+	q.From, q.To = q.From.UTC(), q.To.UTC()
+	if !q.From.Before(q.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidStatsQuery)
+	}
+
+	span := q.To.Sub(q.From)
+	if q.Granularity == "" {
+		switch {
+		case span <= 6*time.Hour:
+			q.Granularity = "minute"
+		case span <= 14*24*time.Hour:
+			q.Granularity = "hour"
+		default:
+			q.Granularity = "day"
+		}
+	}
+
+	step, ok := granularities[q.Granularity]
+	if !ok {
+		return fmt.Errorf("%w: granularity must be minute, hour or day", ErrInvalidStatsQuery)
+	}
+	if span/step > maxStatsBuckets {
+		return fmt.Errorf("%w: range is too long for %s buckets (max %d)", ErrInvalidStatsQuery, q.Granularity, maxStatsBuckets)
+	}
+
+	return nil
+}
+
+// GetLinkStats concurrently fetches a link's click totals, time series and breakdowns for the query range
+func (s *AnalyticsService) GetLinkStats(urlID int, q StatsQuery) (*models.LinkStats, error) {
+	if err := q.Normalize(time.Now()); err != nil {
+		return nil, err
+	}
+	log.Printf("[AnalyticsService] Fetching stats for URL ID %d from %s to %s by %s\n", urlID, q.From, q.To, q.Granularity)
+
+	stats := &models.LinkStats{
+		From:        q.From,
+		To:          q.To,
+		Granularity: q.Granularity,
+		Series:      []models.StatsBucket{},
+		Breakdowns:  make(map[string][]models.StatsCount, len(statsBreakdowns)),
+	}
+	rolled, err := s.rollupRange()
+	if err != nil {
+		return nil, err
+	}
+	// unique clicks and breakdowns come from raw clicks only, so they start where those do
+	pruned, err := s.RawClicksFrom()
+	if err != nil {
+		return nil, err
+	}
+	rawQ := q
+	if pruned.After(q.From) {
+		rawQ.From = pruned
+		stats.RawClicksFrom = &pruned
+	}
+	// rollups are hourly, so minute series are counted from raw clicks
+	seriesPlan := planRollups(q.From, q.To, rolledRange{})
+	if q.Granularity != "minute" {
+		seriesPlan = planRollups(q.From, q.To, rolled)
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	fail := func(what string, queryErr error) {
+		mu.Lock()
+		err = fmt.Errorf("failed to get %s: %w", what, queryErr)
+		mu.Unlock()
+		log.Printf("[AnalyticsService] Error fetching %s: %v\n", what, queryErr)
+	}
+
+	wg.Add(4 + len(statsBreakdowns))
+
+	go func() { // total clicks
+		defer wg.Done()
+		clicks, queryErr := s.countClicks(&urlID, planRollups(q.From, q.To, rolled))
+		if queryErr != nil {
+			fail("click totals", queryErr)
+			return
+		}
+		mu.Lock()
+		stats.TotalClicks = clicks
+		mu.Unlock()
+	}()
+
+	go func() { // unique clicks, distinct IPs can't be rolled up
+		defer wg.Done()
+		query := `
+			SELECT COUNT(DISTINCT ip_address)
+			FROM clicks
+			WHERE url_id = $1 AND clicked_at >= $2 AND clicked_at < $3 AND ` + s.clickFilter()
+		var unique int
+		if scanErr := s.db.QueryRow(query, urlID, rawQ.From, rawQ.To).Scan(&unique); scanErr != nil {
+			fail("unique clicks", scanErr)
+			return
+		}
+		mu.Lock()
+		stats.UniqueClicks = unique
+		mu.Unlock()
+	}()
+
+	go func() { // unique visitors
+		defer wg.Done()
+		visitors, queryErr := s.uniqueVisitors(&urlID, q.From, q.To)
+		if queryErr != nil {
+			fail("unique visitors", queryErr)
+			return
+		}
+		mu.Lock()
+		stats.UniqueVisitors = visitors
+		mu.Unlock()
+	}()
+
+	go func() { // time series
+		defer wg.Done()
+		series, queryErr := s.linkSeries(urlID, q, seriesPlan)
+		if queryErr != nil {
+			fail("click series", queryErr)
+			return
+		}
+		mu.Lock()
+		if len(series) > 0 {
+			stats.Series = series
+		}
+		mu.Unlock()
+	}()
+
+	for name, column := range statsBreakdowns {
+		go func(name, column string) {
+			defer wg.Done()
+			counts, queryErr := s.clickBreakdown(urlID, column, rawQ)
+			if queryErr != nil {
+				fail(name+" breakdown", queryErr)
+				return
+			}
+			mu.Lock()
+			stats.Breakdowns[name] = counts
+			mu.Unlock()
+		}(name, column)
+	}
+
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// linkSeries counts a link's clicks per bucket from the plan's sources, and its unique clicks from raw
+// clicks. Only buckets with clicks are returned
+func (s *AnalyticsService) linkSeries(urlID int, q StatsQuery, p rollupPlan) ([]models.StatsBucket, error) {
+	query := `
+		SELECT date_trunc($6, at) AS bucket, SUM(clicks)
+		FROM ` + s.rolledClicks(&urlID) + ` c
+		GROUP BY bucket
+		HAVING SUM(clicks) > 0
+		ORDER BY bucket
+	`
+	rows, err := s.db.Query(query, urlID, p.from, p.hourFrom, p.hourTo, p.to, q.Granularity)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var series []models.StatsBucket
+	for rows.Next() {
+		var b models.StatsBucket
+		if err := rows.Scan(&b.Start, &b.Clicks); err != nil {
+			return nil, err
+		}
+		series = append(series, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT date_trunc($4, clicked_at) AS bucket, COUNT(DISTINCT ip_address)
+		FROM clicks
+		WHERE url_id = $1 AND clicked_at >= $2 AND clicked_at < $3 AND ` + s.clickFilter() + `
+		GROUP BY bucket
+	`
+	rows, err = s.db.Query(query, urlID, q.From, q.To, q.Granularity)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	unique := make(map[int64]int) // keyed by unix time, scanned times needn't share a location
+	for rows.Next() {
+		var bucket time.Time
+		var n int
+		if err := rows.Scan(&bucket, &n); err != nil {
+			return nil, err
+		}
+		unique[bucket.Unix()] = n
+	}
+	for i := range series {
+		series[i].UniqueClicks = unique[series[i].Start.Unix()]
+	}
+
+	return series, rows.Err()
+}
+
+// clickBreakdown returns the most common values of a clicks column in the query range, with
+// missing values grouped under ""
+func (s *AnalyticsService) clickBreakdown(urlID int, column string, q StatsQuery) ([]models.StatsCount, error) {
+	query := fmt.Sprintf(`
+		SELECT COALESCE(%s, ''), COUNT(*)
+		FROM clicks
+		WHERE url_id = $1 AND clicked_at >= $2 AND clicked_at < $3 AND %s
+		GROUP BY 1
+		ORDER BY 2 DESC, 1
+		LIMIT $4
+	`, column, s.clickFilter())
+
+	rows, err := s.db.Query(query, urlID, q.From, q.To, statsBreakdownTop)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []models.StatsCount{}
+	for rows.Next() {
+		var c models.StatsCount
+		if err := rows.Scan(&c.Value, &c.Clicks); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
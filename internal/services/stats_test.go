@@ -0,0 +1,40 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStatsQueryNormalize(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	t.Log("Defaults to the last 7 days by hour")
+	q := StatsQuery{}
+	if err := q.Normalize(now); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if !q.To.Equal(now) || !q.From.Equal(now.Add(-7*24*time.Hour)) || q.Granularity != "hour" {
+		t.Fatalf("Unexpected defaults: %+v", q)
+	}
+
+	t.Log("Short ranges default to minutes, long ones to days")
+	short := StatsQuery{From: now.Add(-time.Hour), To: now}
+	short.Normalize(now)
+	long := StatsQuery{From: now.Add(-90 * 24 * time.Hour), To: now}
+	long.Normalize(now)
+	if short.Granularity != "minute" || long.Granularity != "day" {
+		t.Fatalf("Expected minute and day, got %s and %s", short.Granularity, long.Granularity)
+	}
+
+	invalid := []StatsQuery{
+		{From: now, To: now.Add(-time.Hour)},
+		{From: now.Add(-time.Hour), To: now, Granularity: "week"},
+		{From: now.Add(-30 * 24 * time.Hour), To: now, Granularity: "minute"},
+	}
+	for _, q := range invalid {
+		if err := q.Normalize(now); !errors.Is(err, ErrInvalidStatsQuery) {
+			t.Fatalf("Expected ErrInvalidStatsQuery for %+v, got %v", q, err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"minify/internal/models"
+	"minify/internal/useragent"
+	"minify/internal/utils"
+)
+
+const (
+	maxTargetingRules   = 20
+	maxCountryOverrides = 250
+)
+
+var ErrInvalidTargeting = errors.New("invalid targeting rules")
+
+// ValidateTargetingRules checks that every rule has a valid target URL and at least one known condition.
+// Condition values are normalized to lowercase in place
+func ValidateTargetingRules(rules []models.TargetingRule) error {
+	if len(rules) > maxTargetingRules {
+		return fmt.Errorf("%w: at most %d rules are allowed", ErrInvalidTargeting, maxTargetingRules)
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		rule.OS = strings.ToLower(strings.TrimSpace(rule.OS))
+		rule.Device = strings.ToLower(strings.TrimSpace(rule.Device))
+		rule.Browser = strings.ToLower(strings.TrimSpace(rule.Browser))
+
+		if !utils.IsValidURL(rule.URL) {
+			return fmt.Errorf("%w: rule %d has an invalid url", ErrInvalidTargeting, i+1)
+		}
+		if rule.OS == "" && rule.Device == "" && rule.Browser == "" {
+			return fmt.Errorf("%w: rule %d needs at least one of os, device or browser", ErrInvalidTargeting, i+1)
+		}
+		if rule.OS != "" && !contains(useragent.Known.OS, rule.OS) {
+			return fmt.Errorf("%w: rule %d has unknown os %q", ErrInvalidTargeting, i+1, rule.OS)
+		}
+		if rule.Device != "" && !contains(useragent.Known.Devices, rule.Device) {
+			return fmt.Errorf("%w: rule %d has unknown device %q", ErrInvalidTargeting, i+1, rule.Device)
+		}
+		if rule.Browser != "" && !contains(useragent.Known.Browsers, rule.Browser) {
+			return fmt.Errorf("%w: rule %d has unknown browser %q", ErrInvalidTargeting, i+1, rule.Browser)
+		}
+	}
+
+	return nil
+}
+
+// ValidateCountryOverrides checks that keys are two letter ISO country codes and values are valid URLs,
+// returning the overrides with upper-cased keys
+func ValidateCountryOverrides(overrides map[string]string) (map[string]string, error) {
+	if len(overrides) > maxCountryOverrides {
+		return nil, fmt.Errorf("%w: at most %d country overrides are allowed", ErrInvalidTargeting, maxCountryOverrides)
+	}
+
+	normalized := make(map[string]string, len(overrides))
+	for country, target := range overrides {
+		code := strings.ToUpper(strings.TrimSpace(country))
+		if !isCountryCode(code) {
+			return nil, fmt.Errorf("%w: %q is not a two letter country code", ErrInvalidTargeting, country)
+		}
+		if !utils.IsValidURL(target) {
+			return nil, fmt.Errorf("%w: country %s has an invalid url", ErrInvalidTargeting, code)
+		}
+		normalized[code] = target
+	}
+
+	return normalized, nil
+}
+
+// SelectTarget returns the visitor's country override if there is one, otherwise the URL of the first
+// targeting rule matching the visitor. Empty rule fields match anything. ok is false if nothing matched
+func SelectTarget(url *models.URL, ua useragent.Info, country string) (target string, ok bool) {
+	if target, ok := url.CountryOverrides[country]; ok && country != "" {
+		return target, true
+	}
+
+	for _, rule := range url.TargetingRules {
+		if rule.OS != "" && rule.OS != ua.OS {
+			continue
+		}
+		if rule.Device != "" && rule.Device != ua.Device {
+			continue
+		}
+		if rule.Browser != "" && rule.Browser != ua.Browser {
+			continue
+		}
+
+		return rule.URL, true
+	}
+
+	return "", false
+}
+
+// marshalTargetingRules encodes rules for the targeting_rules JSONB column, with no rules stored as NULL
+func marshalTargetingRules(rules []models.TargetingRule) (*string, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(rules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode targeting rules: %w", err)
+	}
+	encoded := string(b)
+
+	return &encoded, nil
+}
+
+// marshalCountryOverrides encodes overrides for the country_overrides JSONB column, with none stored as NULL
+func marshalCountryOverrides(overrides map[string]string) (*string, error) {
+	if len(overrides) == 0 {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(overrides)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode country overrides: %w", err)
+	}
+	encoded := string(b)
+
+	return &encoded, nil
+}
+
+func isCountryCode(code string) bool {
+	return len(code) == 2 && code[0] >= 'A' && code[0] <= 'Z' && code[1] >= 'A' && code[1] <= 'Z'
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
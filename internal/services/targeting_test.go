@@ -0,0 +1,90 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"minify/internal/models"
+	"minify/internal/useragent"
+)
+
+func TestValidateTargetingRules(t *testing.T) {
+	rules := []models.TargetingRule{{OS: " iOS ", URL: "https://apps.apple.com/app/1"}}
+	if err := ValidateTargetingRules(rules); err != nil {
+		t.Fatalf("Expected valid rule, got %v", err)
+	}
+	if rules[0].OS != useragent.OSiOS {
+		t.Fatalf("Expected os to be normalized to %q, got %q", useragent.OSiOS, rules[0].OS)
+	}
+
+	invalid := [][]models.TargetingRule{
+		{{URL: "https://example.com"}},
+		{{OS: "ios", URL: "not a url"}},
+		{{OS: "symbian", URL: "https://example.com"}},
+		{{Device: "watch", URL: "https://example.com"}},
+	}
+	for _, rules := range invalid {
+		if err := ValidateTargetingRules(rules); !errors.Is(err, ErrInvalidTargeting) {
+			t.Fatalf("Expected ErrInvalidTargeting for %+v, got %v", rules, err)
+		}
+	}
+}
+
+func TestSelectTarget(t *testing.T) {
+	url := &models.URL{
+		OriginalURL: "https://example.com",
+		TargetingRules: []models.TargetingRule{
+			{OS: useragent.OSiOS, URL: "https://apps.apple.com/app/1"},
+			{OS: useragent.OSAndroid, Device: useragent.DeviceMobile, URL: "https://play.google.com/store/apps/1"},
+		},
+	}
+
+	tests := []struct {
+		ua   useragent.Info
+		want string
+	}{
+		{useragent.Info{OS: useragent.OSiOS, Device: useragent.DeviceTablet}, "https://apps.apple.com/app/1"},
+		{useragent.Info{OS: useragent.OSAndroid, Device: useragent.DeviceMobile}, "https://play.google.com/store/apps/1"},
+		{useragent.Info{OS: useragent.OSAndroid, Device: useragent.DeviceTablet}, ""},
+		{useragent.Info{OS: useragent.OSUnknown, Device: useragent.DeviceUnknown}, ""},
+	}
+
+	for _, tt := range tests {
+		if got, _ := SelectTarget(url, tt.ua, ""); got != tt.want {
+			t.Fatalf("SelectTarget(%+v) = %q, want %q", tt.ua, got, tt.want)
+		}
+	}
+}
+
+func TestCountryOverrides(t *testing.T) {
+	overrides, err := ValidateCountryOverrides(map[string]string{"gb": "https://example.co.uk"})
+	if err != nil {
+		t.Fatalf("Expected valid overrides, got %v", err)
+	}
+	if overrides["GB"] != "https://example.co.uk" {
+		t.Fatalf("Expected country code to be upper-cased, got %v", overrides)
+	}
+
+	for _, invalid := range []map[string]string{{"GBR": "https://example.com"}, {"G1": "https://example.com"}, {"DE": "nope"}} {
+		if _, err := ValidateCountryOverrides(invalid); !errors.Is(err, ErrInvalidTargeting) {
+			t.Fatalf("Expected ErrInvalidTargeting for %v, got %v", invalid, err)
+		}
+	}
+
+	url := &models.URL{
+		OriginalURL:      "https://example.com",
+		CountryOverrides: overrides,
+		TargetingRules:   []models.TargetingRule{{OS: useragent.OSiOS, URL: "https://apps.apple.com/app/1"}},
+	}
+	ios := useragent.Info{OS: useragent.OSiOS}
+
+	if got, _ := SelectTarget(url, ios, "GB"); got != "https://example.co.uk" {
+		t.Fatalf("Expected country override to win, got %q", got)
+	}
+	if got, _ := SelectTarget(url, ios, "FR"); got != "https://apps.apple.com/app/1" {
+		t.Fatalf("Expected targeting rule for other countries, got %q", got)
+	}
+	if _, ok := SelectTarget(url, useragent.Info{}, ""); ok {
+		t.Fatal("Expected no match for unknown country and device")
+	}
+}
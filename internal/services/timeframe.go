@@ -0,0 +1,313 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"minify/internal/models"
+)
+
+const maxTimeframeBuckets = 1000
+
+var ErrInvalidTimeframe = errors.New("invalid timeframe")
+
+// timeframeBuckets are the supported series bucket sizes, with the longest length of each for
+// bounding the number of buckets in a range
+var timeframeBuckets = map[string]time.Duration{
+	"hour":  time.Hour,
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 28 * 24 * time.Hour,
+}
+
+// TimeframeQuery selects a range for the service-wide timeframe stats, From inclusive and To exclusive.
+// Buckets are aligned to TimeZone, so e.g. day buckets start at local midnight
+type TimeframeQuery struct {
+	From     time.Time
+	To       time.Time
+	TimeZone string // IANA name, UTC if empty
+	Bucket   string // hour, day, week or month, picked from the range if empty
+
+	loc *time.Location
+}
+
+// Normalize fills in defaults (the last 30 days in UTC, and a bucket suited to the range) and checks
+// the time zone, range and bucket count are valid
+func (q *TimeframeQuery) Normalize(now time.Time) error {
+	if q.TimeZone == "" {
+		q.TimeZone = "UTC"
+	}
+	// "Local" would mean the server's zone, which postgres doesn't know by that name
+	loc, err := time.LoadLocation(q.TimeZone)
+	if err != nil || q.TimeZone == "Local" {
+		return fmt.Errorf("%w: unknown time zone %q", ErrInvalidTimeframe, q.TimeZone)
+	}
+	q.loc = loc
+
+	if q.To.IsZero() {
+		q.To = now
+	}
+	if q.From.IsZero() {
+		q.From = q.To.AddDate(0, 0, -30)
+	}
+	q.From, q.To = q.From.UTC(), q.To.UTC()
+	if !q.From.Before(q.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidTimeframe)
+	}
+
+	span := q.To.Sub(q.From)
+	if q.Bucket == "" {
+		switch {
+		case span <= 2*24*time.Hour:
+			q.Bucket = "hour"
+		case span <= 90*24*time.Hour:
+			q.Bucket = "day"
+		case span <= 366*24*time.Hour:
+			q.Bucket = "week"
+		default:
+			q.Bucket = "month"
+		}
+	}
+
+	step, ok := timeframeBuckets[q.Bucket]
+	if !ok {
+		return fmt.Errorf("%w: bucket must be hour, day, week or month", ErrInvalidTimeframe)
+	}
+	if span/step > maxTimeframeBuckets {
+		return fmt.Errorf("%w: range is too long for %s buckets (max %d)", ErrInvalidTimeframe, q.Bucket, maxTimeframeBuckets)
+	}
+
+	return nil
+}
+
+// previous returns the start of the equal-length period just before the query range
+func (q *TimeframeQuery) previous() time.Time {
+	return q.From.Add(-q.To.Sub(q.From))
+}
+
+// TimeframePeriod expands a shorthand period (hour, day, month, year) into the query covering
+// that much time up to now
+func TimeframePeriod(period string, now time.Time) (TimeframeQuery, error) {
+	q := TimeframeQuery{To: now}
+	switch period {
+	case "hour":
+		q.From, q.Bucket = now.Add(-time.Hour), "hour"
+	case "day":
+		q.From, q.Bucket = now.AddDate(0, 0, -1), "hour"
+	case "month":
+		q.From, q.Bucket = now.AddDate(0, -1, 0), "day"
+	case "year":
+		q.From, q.Bucket = now.AddDate(-1, 0, 0), "month"
+	default:
+		return q, fmt.Errorf("%w: %s", ErrInvalidPeriod, period)
+	}
+
+	return q, nil
+}
+
+// GetTimeframeStats gets the timeframe stats for a shorthand period (hour, day, month, year) up to now
+func (s *AnalyticsService) GetTimeframeStats(period, timeZone string) (*models.TimeframeStats, error) {
+	q, err := TimeframePeriod(period, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	q.TimeZone = timeZone
+
+	stats, err := s.GetTimeframe(q)
+	if err != nil {
+		return nil, err
+	}
+	stats.Period = period
+
+	return stats, nil
+}
+
+// GetTimeframe concurrently gets clicks, urls, unique users and unique visitors for a range, as totals,
+// a gap-filled series and changes against the previous period of the same length
+func (s *AnalyticsService) GetTimeframe(q TimeframeQuery) (*models.TimeframeStats, error) {
+	if err := q.Normalize(time.Now()); err != nil {
+		return nil, err
+	}
+	log.Printf("[AnalyticsService] Fetching timeframe stats from %s to %s by %s in %s\n", q.From, q.To, q.Bucket, q.TimeZone)
+
+	stats := &models.TimeframeStats{
+		From:     q.From.In(q.loc),
+		To:       q.To.In(q.loc),
+		TimeZone: q.TimeZone,
+		Bucket:   q.Bucket,
+		Series:   []models.TimeframeBucket{},
+	}
+	prevFrom := q.previous()
+	rolled, err := s.rollupRange()
+	if err != nil {
+		return nil, err
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	fail := func(what string, queryErr error) {
+		mu.Lock()
+		err = fmt.Errorf("failed to get %s: %w", what, queryErr)
+		mu.Unlock()
+		log.Printf("[AnalyticsService] Error fetching %s: %v\n", what, queryErr)
+	}
+
+	wg.Add(6)
+
+	for _, period := range []struct {
+		from, to time.Time
+		dest     *int
+	}{
+		{q.From, q.To, &stats.ClickCount},
+		{prevFrom, q.From, &stats.Previous.ClickCount},
+	} {
+		go func(from, to time.Time, dest *int) { // click counts, current and previous period
+			defer wg.Done()
+			clicks, queryErr := s.countClicks(nil, planRollups(from, to, rolled))
+			if queryErr != nil {
+				fail("click count", queryErr)
+				return
+			}
+			mu.Lock()
+			*dest = clicks
+			mu.Unlock()
+		}(period.from, period.to, period.dest)
+	}
+
+	go func() { // URL counts and unique users, current and previous period
+		defer wg.Done()
+		query := `
+			SELECT COUNT(*) FILTER (WHERE created_at >= $2),
+			       COUNT(*) FILTER (WHERE created_at < $2),
+			       COUNT(DISTINCT user_id) FILTER (WHERE created_at >= $2),
+			       COUNT(DISTINCT user_id) FILTER (WHERE created_at < $2)
+			FROM urls
+			WHERE created_at >= $1 AND created_at < $3
+		`
+		scanErr := s.db.QueryRow(query, prevFrom, q.From, q.To).Scan(
+			&stats.URLCount, &stats.Previous.URLCount, &stats.UniqueUsers, &stats.Previous.UniqueUsers,
+		)
+		if scanErr != nil {
+			fail("URL count", scanErr)
+		}
+	}()
+
+	for _, period := range []struct {
+		from, to time.Time
+		dest     *int
+	}{
+		{q.From, q.To, &stats.UniqueVisitors},
+		{prevFrom, q.From, &stats.Previous.UniqueVisitors},
+	} {
+		go func(from, to time.Time, dest *int) { // unique visitors, current and previous period
+			defer wg.Done()
+			visitors, queryErr := s.uniqueVisitors(nil, from, to)
+			if queryErr != nil {
+				fail("unique visitors", queryErr)
+				return
+			}
+			mu.Lock()
+			*dest = visitors
+			mu.Unlock()
+		}(period.from, period.to, period.dest)
+	}
+
+	go func() { // series
+		defer wg.Done()
+		series, queryErr := s.timeframeSeries(q, rolled)
+		if queryErr != nil {
+			fail("timeframe series", queryErr)
+			return
+		}
+		mu.Lock()
+		stats.Series = series
+		mu.Unlock()
+	}()
+
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Change = models.TimeframeChange{
+		ClickCount:            stats.ClickCount - stats.Previous.ClickCount,
+		ClickCountPercent:     percentChange(stats.ClickCount, stats.Previous.ClickCount),
+		URLCount:              stats.URLCount - stats.Previous.URLCount,
+		URLCountPercent:       percentChange(stats.URLCount, stats.Previous.URLCount),
+		UniqueUsers:           stats.UniqueUsers - stats.Previous.UniqueUsers,
+		UniqueUsersPercent:    percentChange(stats.UniqueUsers, stats.Previous.UniqueUsers),
+		UniqueVisitors:        stats.UniqueVisitors - stats.Previous.UniqueVisitors,
+		UniqueVisitorsPercent: percentChange(stats.UniqueVisitors, stats.Previous.UniqueVisitors),
+	}
+
+	return stats, nil
+}
+
+// timeframeSeries counts clicks and new URLs per bucket, with every bucket in the range present.
+// Timestamps are stored as UTC, so they're converted to the query's zone before truncating. Hourly
+// rollups are used unless the zone is offset by a fraction of an hour, which would split them
+func (s *AnalyticsService) timeframeSeries(q TimeframeQuery, rolled rolledRange) ([]models.TimeframeBucket, error) {
+	p := planRollups(q.From, q.To, rolledRange{})
+	if alignsToHours(q.loc, q.From, q.To) {
+		p = planRollups(q.From, q.To, rolled)
+	}
+
+	query := `
+		WITH buckets AS (
+			SELECT generate_series(
+				date_trunc($6, ($2::timestamp AT TIME ZONE 'UTC') AT TIME ZONE $7),
+				($5::timestamp AT TIME ZONE 'UTC') AT TIME ZONE $7,
+				('1 ' || $6)::interval
+			) AS bucket
+		),
+		click_counts AS (
+			SELECT date_trunc($6, (at AT TIME ZONE 'UTC') AT TIME ZONE $7) AS bucket, SUM(clicks) AS clicks
+			FROM ` + s.rolledClicks(nil) + ` c
+			GROUP BY 1
+		),
+		url_counts AS (
+			SELECT date_trunc($6, (created_at AT TIME ZONE 'UTC') AT TIME ZONE $7) AS bucket, COUNT(*) AS urls
+			FROM urls
+			WHERE created_at >= $2 AND created_at < $5
+			GROUP BY 1
+		)
+		SELECT b.bucket AT TIME ZONE $7, COALESCE(c.clicks, 0), COALESCE(u.urls, 0)
+		FROM buckets b
+		LEFT JOIN click_counts c ON c.bucket = b.bucket
+		LEFT JOIN url_counts u ON u.bucket = b.bucket
+		WHERE b.bucket < ($5::timestamp AT TIME ZONE 'UTC') AT TIME ZONE $7
+		ORDER BY b.bucket
+	`
+	rows, err := s.db.Query(query, nil, p.from, p.hourFrom, p.hourTo, p.to, q.Bucket, q.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	series := []models.TimeframeBucket{}
+	for rows.Next() {
+		var b models.TimeframeBucket
+		if err := rows.Scan(&b.Start, &b.ClickCount, &b.URLCount); err != nil {
+			return nil, err
+		}
+		b.Start = b.Start.In(q.loc)
+		series = append(series, b)
+	}
+
+	return series, rows.Err()
+}
+
+// percentChange is the change from prev to cur as a percentage rounded to one decimal,
+// or nil if there's nothing to compare against
+func percentChange(cur, prev int) *float64 {
+	if prev == 0 {
+		return nil
+	}
+	pct := math.Round(float64(cur-prev)/float64(prev)*1000) / 10
+
+	return &pct
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTimeframeQueryNormalize(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	t.Log("Defaults to the last 30 days by day in UTC")
+	q := TimeframeQuery{}
+	if err := q.Normalize(now); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if !q.To.Equal(now) || !q.From.Equal(now.AddDate(0, 0, -30)) || q.Bucket != "day" || q.TimeZone != "UTC" {
+		t.Fatalf("Unexpected defaults: %+v", q)
+	}
+
+	t.Log("Times are stored as UTC whatever zone they were given in")
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	q = TimeframeQuery{From: time.Date(2024, 3, 1, 0, 0, 0, 0, berlin), To: now, TimeZone: "Europe/Berlin"}
+	if err := q.Normalize(now); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if q.From.Location() != time.UTC || q.From.Hour() != 23 {
+		t.Fatalf("Expected from in UTC, got %s", q.From)
+	}
+
+	t.Log("The previous period has the same length and ends where the range starts")
+	if got := q.previous(); !got.Equal(q.From.Add(-q.To.Sub(q.From))) {
+		t.Fatalf("Unexpected previous period start: %s", got)
+	}
+
+	invalid := []TimeframeQuery{
+		{From: now, To: now.Add(-time.Hour)},
+		{TimeZone: "Mars/Olympus_Mons"},
+		{TimeZone: "Local"},
+		{Bucket: "minute"},
+		{From: now.AddDate(-1, 0, 0), To: now, Bucket: "hour"},
+	}
+	for _, q := range invalid {
+		if err := q.Normalize(now); !errors.Is(err, ErrInvalidTimeframe) {
+			t.Fatalf("Expected ErrInvalidTimeframe for %+v, got %v", q, err)
+		}
+	}
+}
+
+func TestTimeframePeriod(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		period string
+		from   time.Time
+		bucket string
+	}{
+		{"hour", now.Add(-time.Hour), "hour"},
+		{"day", now.AddDate(0, 0, -1), "hour"},
+		{"month", now.AddDate(0, -1, 0), "day"},
+		{"year", now.AddDate(-1, 0, 0), "month"},
+	}
+	for _, tt := range tests {
+		q, err := TimeframePeriod(tt.period, now)
+		if err != nil {
+			t.Fatalf("TimeframePeriod(%q) failed: %v", tt.period, err)
+		}
+		if !q.From.Equal(tt.from) || !q.To.Equal(now) || q.Bucket != tt.bucket {
+			t.Errorf("TimeframePeriod(%q) = %+v", tt.period, q)
+		}
+		if err := q.Normalize(now); err != nil {
+			t.Errorf("Shorthand %q doesn't normalize: %v", tt.period, err)
+		}
+	}
+
+	if _, err := TimeframePeriod("week", now); !errors.Is(err, ErrInvalidPeriod) {
+		t.Fatalf("Expected ErrInvalidPeriod, got %v", err)
+	}
+}
+
+func TestPercentChange(t *testing.T) {
+	if got := percentChange(5, 0); got != nil {
+		t.Fatalf("Expected nil with nothing to compare against, got %v", *got)
+	}
+	if got := percentChange(150, 100); got == nil || *got != 50 {
+		t.Fatalf("Expected 50, got %v", got)
+	}
+	if got := percentChange(1, 3); got == nil || *got != -66.7 {
+		t.Fatalf("Expected -66.7, got %v", got)
+	}
+}
@@ -1,161 +1,834 @@
 package services
 
 import (
-	"crypto/rand"
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"math/big"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
 
+	"minify/internal/codegen"
 	"minify/internal/models"
+	"minify/internal/utils"
 )
 
+const (
+	minAliasLength = 3
+	maxAliasLength = 32 // matches urls.short_code column width
+
+	bulkBatchSize     = 100 // links inserted per transaction by BulkMinify
+	maxInsertAttempts = 10  // generated codes tried before giving up on an insert
+)
+
+var (
+	ErrURLNotFound   = errors.New("URL not found")
+	ErrNotOwner      = errors.New("URL belongs to another user")
+	ErrRestoreWindow = errors.New("URL can no longer be restored")
+	ErrInvalidURL    = errors.New("invalid URL format")
+	ErrAliasInvalid  = fmt.Errorf("alias must be %d-%d characters of letters, digits, '-' or '_'", minAliasLength, maxAliasLength)
+	ErrAliasReserved = errors.New("alias is reserved")
+	ErrAliasTaken    = errors.New("alias is already in use")
+
+	ErrInvalidExpiry    = errors.New("expires_at must be in the future")
+	ErrInvalidMaxClicks = errors.New("max_clicks must be greater than 0")
+)
+
+var aliasPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// reservedAliases are paths served by the backend router or the frontend pages,
+// so short codes can't shadow them
+var reservedAliases = map[string]bool{
+	"api":       true,
+	"health":    true,
+	"metrics":   true,
+	"admin":     true,
+	"login":     true,
+	"register":  true,
+	"dashboard": true,
+}
+
+// urlColumns lists the columns scanned by scanURL, in order
+const urlColumns = `id, short_code, original_url, user_id, clicks, expires_at, max_clicks, expired, active, deleted_at,
+	COALESCE(password_hash, ''), redirect_type, forward_query, forward_path, targeting_rules, country_overrides, sticky_variants,
+	` + destinationsColumn + `, created_at, updated_at`
+
 type URLService struct {
-	db *sql.DB
+	db      *sql.DB
+	codeGen codegen.CodeGenerator
+	cache   *URLCache // redirect lookups, nil disables caching
+}
+
+// MinifyOptions holds the optional settings for a new short link
+type MinifyOptions struct {
+	Alias     string     // custom short code, generated if empty
+	ExpiresAt *time.Time // link stops resolving after this time
+	MaxClicks *int       // link stops resolving after this many clicks
+	Password  string     // visitors must enter this before being redirected
+	Dedup     bool       // reuse the owner's existing link for the same destination
+
+	RedirectType int    // redirect status code, 302 if zero
+	ForwardQuery string // how the incoming query string is merged into the destination (see ForwardQuery* consts)
+	ForwardPath  bool   // append path segments after the short code to the destination path
+
+	TargetingRules   []models.TargetingRule // device/platform specific destinations, checked in order
+	CountryOverrides map[string]string      // destinations by visitor country, checked before TargetingRules
+
+	Destinations   []models.Destination // weighted A/B split used instead of the original URL
+	StickyVariants bool                 // pin returning visitors to the variant they were first served
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// queryRower is implemented by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
 }
 
-func NewURLService(db *sql.DB) *URLService {
-	return &URLService{db: db}
+func NewURLService(db *sql.DB, codeGen codegen.CodeGenerator, cache *URLCache) *URLService {
+	return &URLService{db: db, codeGen: codeGen, cache: cache}
 }
 
-// MinifyURL generates a unique short code and inserts the original URL into the db
-func (s *URLService) MinifyURL(originalURL string, userID *int) (*models.URL, error) {
-	shortCode, err := s.generateShortCode()
+// MinifyURL inserts the original URL into the db under the given alias, or under a
+// generated unique short code if no alias is provided. With opts.Dedup set, the owner's existing
+// link for the same destination is returned instead and reused is true
+func (s *URLService) MinifyURL(originalURL string, userID *int, opts MinifyOptions) (url *models.URL, reused bool, err error) {
+	// a transaction keeps the link and its destinations together
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	url, reused, err = s.insertURL(tx, originalURL, userID, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	if !reused {
+		if err := enqueueLinkEvent(tx, EventLinkCreated, url); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("failed to commit URL: %w", err)
+	}
+	// the code may have been cached as a miss, e.g. a freshly claimed alias
+	if !reused {
+		s.cache.Invalidate(url.ShortCode)
+	}
+
+	return url, reused, nil
+}
+
+// BulkMinify shortens many URLs for one owner, inserting them in batched transactions.
+// Each item gets its own result so one bad entry doesn't fail the whole batch
+func (s *URLService) BulkMinify(items []models.MinifyRequest, userID *int) []models.BulkMinifyResult {
+	results := make([]models.BulkMinifyResult, len(items))
+
+	for start := 0; start < len(items); start += bulkBatchSize {
+		end := start + bulkBatchSize
+		if end > len(items) {
+			end = len(items)
+		}
+
+		if err := s.bulkInsertBatch(items[start:end], results[start:end], start, userID); err != nil {
+			log.Println("[URLService] Bulk batch failed:", err)
+			for i := start; i < end; i++ {
+				results[i] = models.BulkMinifyResult{Index: i, URL: items[i].URL, Error: "failed to minify URL"}
+			}
+		}
+	}
+
+	return results
+}
+
+// bulkInsertBatch inserts one batch in a single transaction, using a savepoint per item
+// so a failed insert (e.g. a taken alias) only rolls back that item
+func (s *URLService) bulkInsertBatch(items []models.MinifyRequest, results []models.BulkMinifyResult, offset int, userID *int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for i, item := range items {
+		result := models.BulkMinifyResult{Index: offset + i, URL: item.URL}
+
+		if !utils.IsValidURL(item.URL) {
+			result.Error = ErrInvalidURL.Error()
+			results[i] = result
+			continue
+		}
+
+		if _, err := tx.Exec(`SAVEPOINT bulk_item`); err != nil {
+			return fmt.Errorf("failed to create savepoint: %w", err)
+		}
+
+		opts := MinifyOptions{
+			Alias:        item.Alias,
+			ExpiresAt:    item.ExpiresAt,
+			MaxClicks:    item.MaxClicks,
+			Password:     item.Password,
+			Dedup:        DedupEnabled(item.Dedup),
+			RedirectType: item.RedirectType,
+			ForwardQuery: item.ForwardQuery,
+			ForwardPath:  item.ForwardPath,
+
+			TargetingRules:   item.TargetingRules,
+			CountryOverrides: item.CountryOverrides,
+
+			Destinations:   item.Destinations,
+			StickyVariants: item.StickyVariants,
+		}
+		url, reused, err := s.insertURL(tx, item.URL, userID, opts)
+		if err == nil && !reused {
+			err = enqueueLinkEvent(tx, EventLinkCreated, url)
+		}
+		if err != nil {
+			if _, rbErr := tx.Exec(`ROLLBACK TO SAVEPOINT bulk_item`); rbErr != nil {
+				return fmt.Errorf("failed to roll back savepoint: %w", rbErr)
+			}
+			result.Error = bulkItemError(err)
+			results[i] = result
+			continue
+		}
+
+		result.ShortCode = url.ShortCode
+		result.Reused = reused
+		results[i] = result
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit bulk insert: %w", err)
+	}
+	for _, result := range results {
+		if result.ShortCode != "" && !result.Reused {
+			s.cache.Invalidate(result.ShortCode)
+		}
+	}
+
+	return nil
+}
+
+// bulkItemError hides internal errors from bulk results while keeping validation messages
+func bulkItemError(err error) string {
+	for _, known := range []error{ErrAliasInvalid, ErrAliasReserved, ErrAliasTaken, ErrInvalidExpiry, ErrInvalidMaxClicks,
+		ErrInvalidRedirectType, ErrInvalidForwardQuery} {
+		if errors.Is(err, known) {
+			return known.Error()
+		}
+	}
+	if errors.Is(err, ErrInvalidTargeting) || errors.Is(err, ErrInvalidDestinations) {
+		return err.Error()
+	}
+	log.Println("[URLService] Bulk item failed:", err)
+
+	return "failed to minify URL"
+}
+
+// DedupEnabled resolves the optional per-request dedup flag, dedup is on unless the caller opts out
+func DedupEnabled(flag *bool) bool {
+	return flag == nil || *flag
+}
+
+// insertURL validates the options and inserts a new link in tx
+func (s *URLService) insertURL(tx *sql.Tx, originalURL string, userID *int, opts MinifyOptions) (*models.URL, bool, error) {
+	var shortCode string
+
+	if opts.ExpiresAt != nil && !opts.ExpiresAt.After(time.Now()) {
+		return nil, false, ErrInvalidExpiry
+	}
+	if opts.MaxClicks != nil && *opts.MaxClicks <= 0 {
+		return nil, false, ErrInvalidMaxClicks
+	}
+
+	if opts.RedirectType == 0 {
+		opts.RedirectType = http.StatusFound
+	}
+	if err := ValidateRedirectType(opts.RedirectType); err != nil {
+		return nil, false, err
+	}
+	if err := ValidateForwardQuery(opts.ForwardQuery); err != nil {
+		return nil, false, err
+	}
+
+	canonicalURL, err := utils.CanonicalizeURL(originalURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate short code: %w", err)
+		return nil, false, ErrInvalidURL
 	}
 
-	for s.shortCodeExists(shortCode) {
-		shortCode, err = s.generateShortCode()
+	if err := ValidateTargetingRules(opts.TargetingRules); err != nil {
+		return nil, false, err
+	}
+	targetingRules, err := marshalTargetingRules(opts.TargetingRules)
+	if err != nil {
+		return nil, false, err
+	}
+	if opts.CountryOverrides, err = ValidateCountryOverrides(opts.CountryOverrides); err != nil {
+		return nil, false, err
+	}
+	countryOverrides, err := marshalCountryOverrides(opts.CountryOverrides)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := ValidateDestinations(opts.Destinations); err != nil {
+		return nil, false, err
+	}
+
+	// links with their own alias or limits are deliberately separate, so only plain links are deduplicated
+	plain := opts.Alias == "" && opts.ExpiresAt == nil && opts.MaxClicks == nil && opts.Password == "" &&
+		opts.RedirectType == http.StatusFound && opts.ForwardQuery == ForwardQueryOff && !opts.ForwardPath &&
+		len(opts.TargetingRules) == 0 && len(opts.CountryOverrides) == 0 && len(opts.Destinations) == 0
+	// anonymous links have no owner to share them with, so they're never deduplicated
+	if opts.Dedup && plain && userID != nil {
+		// serialize the owner's creates for this destination until commit, so concurrent ones can't both miss
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1, hashtext($2))`, *userID, canonicalURL); err != nil {
+			return nil, false, fmt.Errorf("failed to lock canonical URL: %w", err)
+		}
+		existing, err := findDuplicate(tx, canonicalURL, *userID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to generate unique short code: %w", err)
+			return nil, false, err
+		}
+		if existing != nil {
+			return existing, true, nil
 		}
 	}
 
+	var passwordHash *string
+	if opts.Password != "" {
+		hash, err := hashPassword(opts.Password)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to hash password: %w", err)
+		}
+		passwordHash = &hash
+	}
+
+	if opts.Alias != "" {
+		if err := ValidateAlias(opts.Alias); err != nil {
+			return nil, false, err
+		}
+	}
+
+	// rely on the unique constraint rather than checking first, ON CONFLICT DO NOTHING returns
+	// no row for a taken code (and doesn't abort the surrounding transaction)
 	query := `
-		INSERT INTO urls (short_code, original_url, user_id)
-		VALUES ($1, $2, $3)
+		INSERT INTO urls (short_code, original_url, canonical_url, user_id, expires_at, max_clicks, password_hash,
+			redirect_type, forward_query, forward_path, targeting_rules, country_overrides, sticky_variants)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (short_code) DO NOTHING
 		RETURNING id, created_at, updated_at
 	`
 
 	var url models.URL
-	err = s.db.QueryRow(query, shortCode, originalURL, userID).Scan(
-		&url.ID,
-		&url.CreatedAt,
-		&url.UpdatedAt,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to insert URL: %w", err)
+	for attempt := 0; ; attempt++ {
+		if attempt >= maxInsertAttempts {
+			return nil, false, fmt.Errorf("failed to generate unique short code after %d attempts", maxInsertAttempts)
+		}
+
+		shortCode = opts.Alias
+		if shortCode == "" {
+			shortCode, err = s.codeGen.Generate()
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to generate short code: %w", err)
+			}
+			if reservedAliases[strings.ToLower(shortCode)] {
+				continue
+			}
+		}
+
+		err := tx.QueryRow(query, shortCode, originalURL, canonicalURL, userID, opts.ExpiresAt, opts.MaxClicks, passwordHash,
+			opts.RedirectType, opts.ForwardQuery, opts.ForwardPath, targetingRules, countryOverrides, opts.StickyVariants).Scan(
+			&url.ID,
+			&url.CreatedAt,
+			&url.UpdatedAt,
+		)
+		if err == nil {
+			break
+		}
+		if err != sql.ErrNoRows {
+			return nil, false, fmt.Errorf("failed to insert URL: %w", err)
+		}
+
+		// short code already taken
+		if opts.Alias != "" {
+			return nil, false, ErrAliasTaken
+		}
+		s.codeGen.Collided()
 	}
 
 	url.ShortCode = shortCode
 	url.OriginalURL = originalURL
 	url.UserID = userID
 	url.Clicks = 0
+	url.Active = true
+	url.ExpiresAt = opts.ExpiresAt
+	url.MaxClicks = opts.MaxClicks
+	url.Protected = passwordHash != nil
+	url.RedirectType = opts.RedirectType
+	url.ForwardQuery = opts.ForwardQuery
+	url.ForwardPath = opts.ForwardPath
+	url.TargetingRules = opts.TargetingRules
+	url.CountryOverrides = opts.CountryOverrides
+	url.StickyVariants = opts.StickyVariants
 
-	return &url, nil
+	if len(opts.Destinations) > 0 {
+		if url.Destinations, err = insertDestinations(tx, url.ID, opts.Destinations); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return &url, false, nil
 }
 
-// GetURLByShortCode retrieves a URL record by its short code
-func (s *URLService) GetURLByShortCode(shortCode string) (*models.URL, error) {
+// findDuplicate returns the owner's existing plain, usable link for a canonical URL, or nil if there isn't one
+func findDuplicate(q queryRower, canonicalURL string, userID int) (*models.URL, error) {
 	query := `
-		SELECT id, short_code, original_url, user_id, clicks, created_at, updated_at
+		SELECT ` + urlColumns + `
 		FROM urls
-		WHERE short_code = $1
+		WHERE canonical_url = $1 AND user_id = $2
+		AND deleted_at IS NULL AND active AND NOT expired
+		AND expires_at IS NULL AND max_clicks IS NULL AND password_hash IS NULL
+		AND redirect_type = 302 AND forward_query = '' AND NOT forward_path AND targeting_rules IS NULL AND country_overrides IS NULL
+		AND NOT EXISTS (SELECT 1 FROM url_destinations d WHERE d.url_id = urls.id)
+		ORDER BY created_at
+		LIMIT 1
 	`
 
-	var url models.URL
-	err := s.db.QueryRow(query, shortCode).Scan(
-		&url.ID,
-		&url.ShortCode,
-		&url.OriginalURL,
-		&url.UserID,
-		&url.Clicks,
-		&url.CreatedAt,
-		&url.UpdatedAt,
-	)
+	url, err := scanURL(q.QueryRow(query, canonicalURL, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to look up duplicate URL: %w", err)
+	}
+
+	return url, nil
+}
+
+// GetURLByShortCode retrieves a URL record by its short code through the cache, ignoring deleted links
+func (s *URLService) GetURLByShortCode(shortCode string) (*models.URL, error) {
+	return s.cache.Get(shortCode, func() (*models.URL, error) {
+		return s.loadURLByShortCode(shortCode)
+	})
+}
+
+func (s *URLService) loadURLByShortCode(shortCode string) (*models.URL, error) {
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE short_code = $1 AND deleted_at IS NULL`
 
+	url, err := scanURL(s.db.QueryRow(query, shortCode))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, errors.New("URL not found")
+			return nil, ErrURLNotFound
 		}
 
 		return nil, fmt.Errorf("failed to get URL: %w", err)
 	}
 
-	return &url, nil
+	return url, nil
+}
+
+// GetOwnedURL retrieves a URL record (including soft-deleted ones) and checks that it belongs to the user
+func (s *URLService) GetOwnedURL(shortCode string, userID int) (*models.URL, error) {
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE short_code = $1`
+
+	url, err := scanURL(s.db.QueryRow(query, shortCode))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrURLNotFound
+		}
+
+		return nil, fmt.Errorf("failed to get URL: %w", err)
+	}
+
+	if url.UserID == nil || *url.UserID != userID {
+		return nil, ErrNotOwner
+	}
+	url.Expired = IsExpired(url, time.Now())
+
+	return url, nil
 }
 
-// IncrementClickCount increases the click count for a URL
-func (s *URLService) IncrementClickCount(urlID int) error {
-	query := `UPDATE urls SET clicks = clicks + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $1`
+// UpdateURL changes a link's destination, active flag and/or password, only the fields that are set are updated.
+// An empty password removes the link's protection
+func (s *URLService) UpdateURL(shortCode string, userID int, req models.UpdateURLRequest) (*models.URL, error) {
+	var canonicalURL *string
+	if req.OriginalURL != nil {
+		if !utils.IsValidURL(*req.OriginalURL) {
+			return nil, ErrInvalidURL
+		}
+		canonical, err := utils.CanonicalizeURL(*req.OriginalURL)
+		if err != nil {
+			return nil, ErrInvalidURL
+		}
+		canonicalURL = &canonical
+	}
+
+	if req.RedirectType != nil {
+		if err := ValidateRedirectType(*req.RedirectType); err != nil {
+			return nil, err
+		}
+	}
+	if req.ForwardQuery != nil {
+		if err := ValidateForwardQuery(*req.ForwardQuery); err != nil {
+			return nil, err
+		}
+	}
+
+	var targetingRules *string
+	if req.TargetingRules != nil {
+		if err := ValidateTargetingRules(*req.TargetingRules); err != nil {
+			return nil, err
+		}
+		var err error
+		if targetingRules, err = marshalTargetingRules(*req.TargetingRules); err != nil {
+			return nil, err
+		}
+	}
+
+	var countryOverrides *string
+	if req.CountryOverrides != nil {
+		overrides, err := ValidateCountryOverrides(*req.CountryOverrides)
+		if err != nil {
+			return nil, err
+		}
+		if countryOverrides, err = marshalCountryOverrides(overrides); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Destinations != nil {
+		if err := ValidateDestinations(*req.Destinations); err != nil {
+			return nil, err
+		}
+	}
+
+	var passwordHash *string
+	if req.Password != nil && *req.Password != "" {
+		hash, err := hashPassword(*req.Password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+		passwordHash = &hash
+	}
+
+	url, err := s.GetOwnedURL(shortCode, userID)
+	if err != nil {
+		return nil, err
+	}
+	if url.DeletedAt != nil {
+		return nil, ErrURLNotFound
+	}
+
+	query := `
+		UPDATE urls
+		SET original_url = COALESCE($2, original_url),
+			canonical_url = COALESCE($3, canonical_url),
+			active = COALESCE($4, active),
+			password_hash = CASE WHEN $5 THEN $6 ELSE password_hash END,
+			redirect_type = COALESCE($7, redirect_type),
+			forward_query = COALESCE($8, forward_query),
+			forward_path = COALESCE($9, forward_path),
+			targeting_rules = CASE WHEN $10 THEN $11::jsonb ELSE targeting_rules END,
+			country_overrides = CASE WHEN $12 THEN $13::jsonb ELSE country_overrides END,
+			sticky_variants = COALESCE($14, sticky_variants),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING ` + urlColumns
 
-	_, err := s.db.Exec(query, urlID)
+	tx, err := s.db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to increment click count: %w", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
+
+	// destinations are replaced first so the returned row includes them
+	if req.Destinations != nil {
+		if err := replaceDestinations(tx, url.ID, *req.Destinations); err != nil {
+			return nil, err
+		}
+	}
+
+	url, err = scanURL(tx.QueryRow(query, url.ID, req.OriginalURL, canonicalURL, req.Active, req.Password != nil, passwordHash,
+		req.RedirectType, req.ForwardQuery, req.ForwardPath, req.TargetingRules != nil, targetingRules,
+		req.CountryOverrides != nil, countryOverrides, req.StickyVariants))
+	if err != nil {
+		return nil, fmt.Errorf("failed to update URL: %w", err)
+	}
+	url.Expired = IsExpired(url, time.Now())
+	if err := enqueueLinkEvent(tx, EventLinkUpdated, url); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit URL update: %w", err)
+	}
+	s.cache.Invalidate(shortCode)
+
+	return url, nil
+}
+
+// DeleteURL soft-deletes a link, it stops resolving immediately but can be restored within the restore window
+func (s *URLService) DeleteURL(shortCode string, userID int) error {
+	url, err := s.GetOwnedURL(shortCode, userID)
+	if err != nil {
+		return err
+	}
+	if url.DeletedAt != nil {
+		return ErrURLNotFound
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE urls SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING ` + urlColumns
+	url, err = scanURL(tx.QueryRow(query, url.ID))
+	if err != nil {
+		return fmt.Errorf("failed to delete URL: %w", err)
+	}
+	if err := enqueueLinkEvent(tx, EventLinkDeleted, url); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit URL delete: %w", err)
+	}
+	s.cache.Invalidate(shortCode)
 
 	return nil
 }
 
-// GetUserURLs fetches all URLs created by a user, ordered by newest first
-func (s *URLService) GetUserURLs(userID int) ([]*models.URL, error) {
+// RestoreURL undoes a soft delete as long as the link was deleted less than window ago
+func (s *URLService) RestoreURL(shortCode string, userID int, window time.Duration) (*models.URL, error) {
+	url, err := s.GetOwnedURL(shortCode, userID)
+	if err != nil {
+		return nil, err
+	}
+	if url.DeletedAt == nil {
+		return url, nil
+	}
+	if time.Since(*url.DeletedAt) > window {
+		return nil, ErrRestoreWindow
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	query := `
-		SELECT id, short_code, original_url, user_id, clicks, created_at, updated_at
-		FROM urls
-		WHERE user_id = $1
-		ORDER BY created_at DESC
+		UPDATE urls SET deleted_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING ` + urlColumns
+	url, err = scanURL(tx.QueryRow(query, url.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore URL: %w", err)
+	}
+	// subscribers saw the link.deleted, a restore is reported as an update bringing it back
+	if err := enqueueLinkEvent(tx, EventLinkUpdated, url); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit URL restore: %w", err)
+	}
+	s.cache.Invalidate(shortCode)
+	url.Expired = IsExpired(url, time.Now())
+
+	return url, nil
+}
+
+// ClaimClick atomically counts a click against a link's max_clicks limit, returning false
+// if the limit has already been reached
+func (s *URLService) ClaimClick(urlID int) (bool, error) {
+	query := `
+		UPDATE urls SET clicks = clicks + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND (max_clicks IS NULL OR clicks < max_clicks)
 	`
 
+	res, err := s.db.Exec(query, urlID)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim click: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim click: %w", err)
+	}
+
+	return n == 1, nil
+}
+
+// GetUserURLs fetches all URLs created by a user, ordered by newest first
+func (s *URLService) GetUserURLs(userID int) ([]*models.URL, error) {
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE user_id = $1 AND deleted_at IS NULL ORDER BY created_at DESC`
+
 	rows, err := s.db.Query(query, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user URLs: %w", err)
 	}
 	defer rows.Close()
 
+	now := time.Now()
 	var urls []*models.URL
 	for rows.Next() {
-		var url models.URL
-		err := rows.Scan(
-			&url.ID,
-			&url.ShortCode,
-			&url.OriginalURL,
-			&url.UserID,
-			&url.Clicks,
-			&url.CreatedAt,
-			&url.UpdatedAt,
-		)
-
+		url, err := scanURL(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan URL: %w", err)
 		}
-		urls = append(urls, &url)
+		url.Expired = IsExpired(url, now) // reaper may not have run yet
+		urls = append(urls, url)
 	}
 
 	return urls, nil
 }
 
-// generateShortCode creates a random, url safe alphanumeric short code (max length 8 chars)
-func (s *URLService) generateShortCode() (string, error) {
-	b := make([]rune, 8)
-	var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+// ReapExpired marks links that have passed their expiry time or click limit as expired
+func (s *URLService) ReapExpired() (int64, error) {
+	query := `
+		UPDATE urls SET expired = TRUE, updated_at = CURRENT_TIMESTAMP
+		WHERE NOT expired
+		AND ((expires_at IS NOT NULL AND expires_at <= NOW())
+			OR (max_clicks IS NOT NULL AND clicks >= max_clicks))
+	`
 
-	for i := range b {
-		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(letters))))
-		if err != nil {
-			return "", err
+	res, err := s.db.Exec(query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reap expired URLs: %w", err)
+	}
+
+	return res.RowsAffected()
+}
+
+// PurgeDeleted permanently removes links that were soft-deleted more than window ago
+func (s *URLService) PurgeDeleted(window time.Duration) (int64, error) {
+	query := `DELETE FROM urls WHERE deleted_at IS NOT NULL AND deleted_at <= $1`
+
+	res, err := s.db.Exec(query, time.Now().Add(-window))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge deleted URLs: %w", err)
+	}
+
+	return res.RowsAffected()
+}
+
+// RunReaper periodically marks expired links and purges deleted links past their
+// restore window until ctx is cancelled
+func (s *URLService) RunReaper(ctx context.Context, interval, restoreWindow time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := s.ReapExpired(); err != nil {
+				log.Println("[URLService] Expiry reaper failed:", err)
+			} else if n > 0 {
+				log.Printf("[URLService] Marked %d URLs as expired\n", n)
+			}
+
+			if n, err := s.PurgeDeleted(restoreWindow); err != nil {
+				log.Println("[URLService] Purging deleted URLs failed:", err)
+			} else if n > 0 {
+				log.Printf("[URLService] Purged %d deleted URLs\n", n)
+			}
 		}
-		b[i] = letters[n.Int64()]
+	}
+}
+
+// IsExpired reports whether a link has passed its expiry time or click limit
+func IsExpired(url *models.URL, now time.Time) bool {
+	if url.Expired {
+		return true
+	}
+	if url.ExpiresAt != nil && !url.ExpiresAt.After(now) {
+		return true
+	}
+
+	return url.MaxClicks != nil && url.Clicks >= *url.MaxClicks
+}
+
+// CheckURLPassword reports whether password unlocks a protected link
+func CheckURLPassword(url *models.URL, password string) bool {
+	return url.PasswordHash != "" && checkPassword(url.PasswordHash, password)
+}
+
+// ValidateAlias checks a custom alias against the allowed charset, length, and reserved paths
+func ValidateAlias(alias string) error {
+	if len(alias) < minAliasLength || len(alias) > maxAliasLength || !aliasPattern.MatchString(alias) {
+		return ErrAliasInvalid
 	}
 
-	return string(b), nil
+	if reservedAliases[strings.ToLower(alias)] {
+		return ErrAliasReserved
+	}
+
+	return nil
 }
 
-// shortCodeExists checks if a short code is already in the db
-func (s *URLService) shortCodeExists(shortCode string) bool {
-	query := `SELECT EXISTS(SELECT 1 FROM urls WHERE short_code = $1)`
-	var exists bool
-	s.db.QueryRow(query, shortCode).Scan(&exists)
+// scanURL reads a row selected with urlColumns into a URL
+func scanURL(row rowScanner) (*models.URL, error) {
+	var url models.URL
+	var targetingRules, countryOverrides, destinations []byte
+	err := row.Scan(
+		&url.ID,
+		&url.ShortCode,
+		&url.OriginalURL,
+		&url.UserID,
+		&url.Clicks,
+		&url.ExpiresAt,
+		&url.MaxClicks,
+		&url.Expired,
+		&url.Active,
+		&url.DeletedAt,
+		&url.PasswordHash,
+		&url.RedirectType,
+		&url.ForwardQuery,
+		&url.ForwardPath,
+		&targetingRules,
+		&countryOverrides,
+		&url.StickyVariants,
+		&destinations,
+		&url.CreatedAt,
+		&url.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	url.Protected = url.PasswordHash != ""
+
+	if targetingRules != nil {
+		if err := json.Unmarshal(targetingRules, &url.TargetingRules); err != nil {
+			return nil, fmt.Errorf("failed to decode targeting rules: %w", err)
+		}
+	}
+	if countryOverrides != nil {
+		if err := json.Unmarshal(countryOverrides, &url.CountryOverrides); err != nil {
+			return nil, fmt.Errorf("failed to decode country overrides: %w", err)
+		}
+	}
+	if destinations != nil {
+		if err := json.Unmarshal(destinations, &url.Destinations); err != nil {
+			return nil, fmt.Errorf("failed to decode destinations: %w", err)
+		}
+	}
 
-	return exists
+	return &url, nil
 }
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"minify/internal/codegen"
+	"minify/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestValidateAlias(t *testing.T) {
+	cases := []struct {
+		alias string
+		want  error
+	}{
+		{"spring-sale", nil},
+		{"Promo_2024", nil},
+		{"abc", nil},
+		{"ab", ErrAliasInvalid},
+		{"this-alias-is-way-too-long-for-the-column", ErrAliasInvalid},
+		{"has space", ErrAliasInvalid},
+		{"slash/path", ErrAliasInvalid},
+		{"emoji🙂", ErrAliasInvalid},
+		{"health", ErrAliasReserved},
+		{"Metrics", ErrAliasReserved},
+		{"API", ErrAliasReserved},
+	}
+
+	for _, c := range cases {
+		if err := ValidateAlias(c.alias); !errors.Is(err, c.want) {
+			t.Errorf("ValidateAlias(%q) = %v, want %v", c.alias, err, c.want)
+		}
+	}
+}
+
+func TestIsExpired(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Minute)
+	limit := 3
+
+	cases := []struct {
+		name string
+		url  models.URL
+		want bool
+	}{
+		{"no limits", models.URL{}, false},
+		{"future expiry", models.URL{ExpiresAt: &future}, false},
+		{"past expiry", models.URL{ExpiresAt: &past}, true},
+		{"under click limit", models.URL{MaxClicks: &limit, Clicks: 2}, false},
+		{"click limit reached", models.URL{MaxClicks: &limit, Clicks: 3}, true},
+		{"marked by reaper", models.URL{Expired: true}, true},
+	}
+
+	for _, c := range cases {
+		if got := IsExpired(&c.url, now); got != c.want {
+			t.Errorf("%s: IsExpired = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestMinifyURLDedup(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock db: %v", err)
+	}
+	defer db.Close()
+	s := NewURLService(db, codegen.NewRandom(7), nil)
+	userID := 7
+	now := time.Now()
+	columns := []string{"id", "short_code", "original_url", "user_id", "clicks", "expires_at", "max_clicks", "expired",
+		"active", "deleted_at", "password_hash", "redirect_type", "forward_query", "forward_path", "targeting_rules",
+		"country_overrides", "sticky_variants", "destinations", "created_at", "updated_at"}
+
+	t.Log("The owner's creates lock the canonical URL before looking for a duplicate")
+	mock.ExpectBegin()
+	mock.ExpectExec(`pg_advisory_xact_lock`).WithArgs(userID, "https://example.com/a?id=5&utm_source=x").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`FROM urls\s+WHERE canonical_url = \$1 AND user_id = \$2`).
+		WithArgs("https://example.com/a?id=5&utm_source=x", userID).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(3, "abc1234", "https://example.com/a?utm_source=x&id=5", userID, 0,
+			nil, nil, false, true, nil, "", 302, "", false, nil, nil, false, nil, now, now))
+	mock.ExpectCommit()
+
+	url, reused, err := s.MinifyURL("https://Example.com/a?utm_source=x&id=5", &userID, MinifyOptions{Dedup: true})
+	if err != nil {
+		t.Fatalf("MinifyURL failed: %v", err)
+	}
+	if !reused || url.ShortCode != "abc1234" {
+		t.Fatalf("Expected the existing link to be reused, got %q reused=%v", url.ShortCode, reused)
+	}
+
+	t.Log("Anonymous creates are never deduplicated")
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO urls`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(4, now, now))
+	mock.ExpectCommit()
+
+	url, reused, err = s.MinifyURL("https://example.com/a", nil, MinifyOptions{Dedup: true})
+	if err != nil {
+		t.Fatalf("MinifyURL failed: %v", err)
+	}
+	if reused || url.ID != 4 {
+		t.Fatalf("Expected a new link, got id %d reused=%v", url.ID, reused)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("Unmet db expectations: %v", err)
+	}
+}
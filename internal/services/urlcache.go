@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"minify/internal/cache"
+	"minify/internal/metrics"
+	"minify/internal/models"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const remoteCacheTimeout = 100 * time.Millisecond
+
+// URLCache keeps recently resolved links in memory, with an optional shared tier behind it.
+// Unknown codes are cached too (for negativeTTL) so scans of random codes don't all reach the db.
+// A nil URLCache is valid and loads every lookup directly
+type URLCache struct {
+	local       *cache.LRU[*models.URL] // nil value caches a miss
+	remote      cache.Store             // optional, nil if not configured
+	group       singleflight.Group      // collapses concurrent loads of the same code
+	ttl         time.Duration
+	negativeTTL time.Duration
+}
+
+// cachedURL is how links are stored in the remote tier, keeping the password hash that
+// models.URL leaves out of its JSON. A nil URL records a miss
+type cachedURL struct {
+	URL          *models.URL `json:"url"`
+	PasswordHash string      `json:"password_hash,omitempty"`
+}
+
+func NewURLCache(size int, ttl, negativeTTL time.Duration, remote cache.Store) *URLCache {
+	return &URLCache{
+		local:       cache.NewLRU[*models.URL](size),
+		remote:      remote,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+	}
+}
+
+// Get returns the cached link for shortCode, calling load on a miss. load returning
+// ErrURLNotFound is cached as a miss, other errors aren't cached
+func (c *URLCache) Get(shortCode string, load func() (*models.URL, error)) (*models.URL, error) {
+	if c == nil {
+		return load()
+	}
+
+	if url, ok := c.local.Get(shortCode); ok {
+		metrics.RecordCacheLookup("local", true)
+		return copyURL(url)
+	}
+	metrics.RecordCacheLookup("local", false)
+
+	v, err, _ := c.group.Do(shortCode, func() (interface{}, error) {
+		if url, ok := c.getRemote(shortCode); ok {
+			c.local.Set(shortCode, url, c.entryTTL(url))
+			return url, nil
+		}
+
+		url, err := load()
+		if err != nil && !errors.Is(err, ErrURLNotFound) {
+			return nil, err
+		}
+		c.local.Set(shortCode, url, c.entryTTL(url))
+		c.setRemote(shortCode, url)
+
+		return url, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return copyURL(v.(*models.URL))
+}
+
+// Invalidate drops shortCode from both tiers, called after a link is created, edited or deleted.
+// Other instances' local tiers keep their copy until it expires
+func (c *URLCache) Invalidate(shortCode string) {
+	if c == nil {
+		return
+	}
+
+	c.local.Delete(shortCode)
+	if c.remote != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), remoteCacheTimeout)
+		defer cancel()
+		if err := c.remote.Delete(ctx, shortCode); err != nil {
+			log.Println("[URLCache] Failed to invalidate remote entry:", err)
+		}
+	}
+}
+
+func (c *URLCache) getRemote(shortCode string) (*models.URL, bool) {
+	if c.remote == nil {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), remoteCacheTimeout)
+	defer cancel()
+
+	data, ok, err := c.remote.Get(ctx, shortCode)
+	if err != nil {
+		log.Println("[URLCache] Remote lookup failed:", err)
+	}
+	metrics.RecordCacheLookup("remote", ok)
+	if !ok {
+		return nil, false
+	}
+
+	var entry cachedURL
+	if err := json.Unmarshal(data, &entry); err != nil {
+		log.Println("[URLCache] Failed to decode remote entry:", err)
+		return nil, false
+	}
+	if entry.URL != nil {
+		entry.URL.PasswordHash = entry.PasswordHash
+	}
+
+	return entry.URL, true
+}
+
+func (c *URLCache) setRemote(shortCode string, url *models.URL) {
+	if c.remote == nil {
+		return
+	}
+
+	entry := cachedURL{URL: url}
+	if url != nil {
+		entry.PasswordHash = url.PasswordHash
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("[URLCache] Failed to encode remote entry:", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), remoteCacheTimeout)
+	defer cancel()
+	if err := c.remote.Set(ctx, shortCode, data, c.entryTTL(url)); err != nil {
+		log.Println("[URLCache] Failed to store remote entry:", err)
+	}
+}
+
+func (c *URLCache) entryTTL(url *models.URL) time.Duration {
+	if url == nil {
+		return c.negativeTTL
+	}
+
+	return c.ttl
+}
+
+// copyURL hands each caller its own copy so cached entries can't be modified, or reports a cached miss
+func copyURL(url *models.URL) (*models.URL, error) {
+	if url == nil {
+		return nil, ErrURLNotFound
+	}
+	dup := *url
+
+	return &dup, nil
+}
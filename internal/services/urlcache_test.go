@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"minify/internal/cache"
+	"minify/internal/models"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestURLCacheHitsAndInvalidation(t *testing.T) {
+	c := NewURLCache(10, time.Minute, time.Minute, nil)
+	var loads int
+	load := func() (*models.URL, error) {
+		loads++
+		return &models.URL{ShortCode: "abc", OriginalURL: "https://example.com"}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		url, err := c.Get("abc", load)
+		if err != nil || url.OriginalURL != "https://example.com" {
+			t.Fatalf("Get = %+v, %v", url, err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("Expected 1 load, got %d", loads)
+	}
+
+	c.Invalidate("abc")
+	c.Get("abc", load)
+	if loads != 2 {
+		t.Fatalf("Expected invalidation to force a reload, got %d loads", loads)
+	}
+}
+
+func TestURLCacheNegativeCaching(t *testing.T) {
+	c := NewURLCache(10, time.Minute, time.Minute, nil)
+	var loads int
+
+	t.Log("Misses are cached")
+	for i := 0; i < 3; i++ {
+		_, err := c.Get("missing", func() (*models.URL, error) {
+			loads++
+			return nil, ErrURLNotFound
+		})
+		if !errors.Is(err, ErrURLNotFound) {
+			t.Fatalf("Expected ErrURLNotFound, got %v", err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("Expected 1 load for repeated misses, got %d", loads)
+	}
+
+	t.Log("Other errors are not cached")
+	failing := func() (*models.URL, error) {
+		loads++
+		return nil, errors.New("db down")
+	}
+	c.Get("broken", failing)
+	c.Get("broken", failing)
+	if loads != 3 {
+		t.Fatalf("Expected failed loads to be retried, got %d loads", loads)
+	}
+}
+
+func TestURLCacheSingleflight(t *testing.T) {
+	c := NewURLCache(10, time.Minute, time.Minute, nil)
+	var loads int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Get("hot", func() (*models.URL, error) {
+				atomic.AddInt32(&loads, 1)
+				<-release
+				return &models.URL{ShortCode: "hot"}, nil
+			})
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if loads != 1 {
+		t.Fatalf("Expected concurrent misses to share 1 load, got %d", loads)
+	}
+}
+
+func TestURLCacheRemoteTier(t *testing.T) {
+	server := miniredis.RunT(t)
+	store, err := cache.NewRedisStore(context.Background(), "redis://"+server.Addr(), "")
+	if err != nil {
+		t.Fatalf("NewRedisStore failed: %v", err)
+	}
+	defer store.Close()
+
+	first := NewURLCache(10, time.Minute, time.Minute, store)
+	first.Get("abc", func() (*models.URL, error) {
+		return &models.URL{ShortCode: "abc", PasswordHash: "hash", Protected: true}, nil
+	})
+
+	t.Log("A second instance is served from the shared tier")
+	second := NewURLCache(10, time.Minute, time.Minute, store)
+	url, err := second.Get("abc", func() (*models.URL, error) {
+		t.Fatal("Unexpected db load with a warm remote tier")
+		return nil, nil
+	})
+	if err != nil || url.PasswordHash != "hash" {
+		t.Fatalf("Expected remote entry to keep its password hash, got %+v, %v", url, err)
+	}
+
+	first.Invalidate("abc")
+	if server.Exists("abc") {
+		t.Fatal("Expected invalidation to remove the remote entry")
+	}
+}
+
+func TestNilURLCache(t *testing.T) {
+	var c *URLCache
+	var loads int
+	for i := 0; i < 2; i++ {
+		c.Get("abc", func() (*models.URL, error) {
+			loads++
+			return &models.URL{}, nil
+		})
+	}
+	c.Invalidate("abc")
+
+	if loads != 2 {
+		t.Fatalf("Expected a nil cache to load every time, got %d loads", loads)
+	}
+}
@@ -24,7 +24,7 @@ func (s *UserService) CreateUser(username, email, password string) (*models.User
 	log.Printf("[UserService] Creating user: %s\n", username)
 
 	// hash before storing
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := hashPassword(password)
 	if err != nil {
 		log.Println("[UserService] Failed to hash password:", err)
 		return nil, fmt.Errorf("failed to hash password: %w", err)
@@ -37,7 +37,7 @@ func (s *UserService) CreateUser(username, email, password string) (*models.User
 	`
 
 	var user models.User
-	err = s.db.QueryRow(query, username, email, string(hashedPassword)).Scan(
+	err = s.db.QueryRow(query, username, email, hashedPassword).Scan(
 		&user.ID,
 		&user.CreatedAt,
 	)
@@ -53,7 +53,7 @@ func (s *UserService) CreateUser(username, email, password string) (*models.User
 	return &user, nil
 }
 
-// AuthenticateUser fetches a user by their username for login
+// AuthenticateUser fetches a user by their username for login and checks their password
 func (s *UserService) AuthenticateUser(username, password string) (*models.User, error) {
 	log.Println("[UserService] Authenticating user:", username)
 	query := `
@@ -79,5 +79,25 @@ func (s *UserService) AuthenticateUser(username, password string) (*models.User,
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
+	if !checkPassword(user.PasswordHash, password) {
+		log.Println("[UserService] Invalid password for user:", username)
+		return nil, errors.New("invalid password")
+	}
+
 	return &user, nil
 }
+
+// hashPassword bcrypt-hashes a password for storage
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// checkPassword reports whether password matches a hash created by hashPassword
+func checkPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
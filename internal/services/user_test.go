@@ -0,0 +1,23 @@
+package services
+
+import "testing"
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if hash == "correct horse" {
+		t.Fatal("Expected the password to be hashed")
+	}
+
+	if !checkPassword(hash, "correct horse") {
+		t.Fatal("Expected the password to match its hash")
+	}
+	if checkPassword(hash, "wrong horse") || checkPassword(hash, "") {
+		t.Fatal("Expected other passwords not to match")
+	}
+	if checkPassword("", "correct horse") {
+		t.Fatal("Expected an empty hash never to match")
+	}
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"minify/internal/models"
+	"minify/internal/utils"
+
+	"github.com/lib/pq"
+)
+
+const (
+	maxDestinations      = 10
+	maxDestinationWeight = 1000
+)
+
+var ErrInvalidDestinations = errors.New("invalid destinations")
+
+// destinationsColumn selects a link's A/B destinations as a JSON array, NULL if it has none.
+// It's part of urlColumns so the redirect path loads the link and its variants in one query
+const destinationsColumn = `(SELECT json_agg(json_build_object('id', d.id, 'url', d.destination_url, 'weight', d.weight) ORDER BY d.position)
+		FROM url_destinations d WHERE d.url_id = urls.id)`
+
+// ValidateDestinations checks an A/B split: either no destinations, or 2 to 10 valid URLs with positive weights
+func ValidateDestinations(dests []models.Destination) error {
+	if len(dests) == 0 {
+		return nil
+	}
+	if len(dests) < 2 || len(dests) > maxDestinations {
+		return fmt.Errorf("%w: a split needs between 2 and %d destinations", ErrInvalidDestinations, maxDestinations)
+	}
+
+	for i, dest := range dests {
+		if !utils.IsValidURL(dest.URL) {
+			return fmt.Errorf("%w: destination %d has an invalid url", ErrInvalidDestinations, i+1)
+		}
+		if dest.Weight < 1 || dest.Weight > maxDestinationWeight {
+			return fmt.Errorf("%w: destination %d weight must be between 1 and %d", ErrInvalidDestinations, i+1, maxDestinationWeight)
+		}
+	}
+
+	return nil
+}
+
+// PickDestination returns the destination with ID pinnedID if it still exists, otherwise a destination
+// chosen at random in proportion to the weights. Returns nil if there are no destinations
+func PickDestination(dests []models.Destination, pinnedID int) *models.Destination {
+	return pickDestination(dests, pinnedID, rand.Intn)
+}
+
+func pickDestination(dests []models.Destination, pinnedID int, intn func(int) int) *models.Destination {
+	if len(dests) == 0 {
+		return nil
+	}
+
+	total := 0
+	for i := range dests {
+		if pinnedID != 0 && dests[i].ID == pinnedID {
+			return &dests[i]
+		}
+		total += dests[i].Weight
+	}
+
+	n := intn(total)
+	for i := range dests {
+		if n < dests[i].Weight {
+			return &dests[i]
+		}
+		n -= dests[i].Weight
+	}
+
+	return &dests[len(dests)-1]
+}
+
+// insertDestinations stores a new link's destinations in order, returning them with their IDs
+func insertDestinations(q queryRower, urlID int, dests []models.Destination) ([]models.Destination, error) {
+	inserted := make([]models.Destination, len(dests))
+	for i, dest := range dests {
+		id, err := insertDestination(q, urlID, dest, i)
+		if err != nil {
+			return nil, err
+		}
+		inserted[i] = models.Destination{ID: id, URL: dest.URL, Weight: dest.Weight}
+	}
+
+	return inserted, nil
+}
+
+func insertDestination(q queryRower, urlID int, dest models.Destination, position int) (int, error) {
+	query := `
+		INSERT INTO url_destinations (url_id, destination_url, weight, position)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+
+	var id int
+	if err := q.QueryRow(query, urlID, dest.URL, dest.Weight, position).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to insert destination: %w", err)
+	}
+
+	return id, nil
+}
+
+// replaceDestinations swaps a link's destinations for dests. Entries carrying the ID of an existing
+// destination update it in place, so its click history stays attached when only weights change
+func replaceDestinations(tx *sql.Tx, urlID int, dests []models.Destination) error {
+	keep := make([]int64, 0, len(dests))
+	for _, dest := range dests {
+		if dest.ID != 0 {
+			keep = append(keep, int64(dest.ID))
+		}
+	}
+
+	query := `DELETE FROM url_destinations WHERE url_id = $1 AND NOT (id = ANY($2))`
+	if _, err := tx.Exec(query, urlID, pq.Array(keep)); err != nil {
+		return fmt.Errorf("failed to remove destinations: %w", err)
+	}
+
+	for i, dest := range dests {
+		if dest.ID != 0 {
+			query := `UPDATE url_destinations SET destination_url = $3, weight = $4, position = $5 WHERE id = $1 AND url_id = $2`
+			res, err := tx.Exec(query, dest.ID, urlID, dest.URL, dest.Weight, i)
+			if err != nil {
+				return fmt.Errorf("failed to update destination: %w", err)
+			}
+			if n, _ := res.RowsAffected(); n == 1 {
+				continue
+			}
+		}
+
+		// new destination, or an ID that doesn't belong to this link
+		if _, err := insertDestination(tx, urlID, dest, i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"minify/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestValidateDestinations(t *testing.T) {
+	valid := []models.Destination{{URL: "https://a.example.com", Weight: 70}, {URL: "https://b.example.com", Weight: 30}}
+	if err := ValidateDestinations(valid); err != nil {
+		t.Fatalf("Expected valid split, got %v", err)
+	}
+	if err := ValidateDestinations(nil); err != nil {
+		t.Fatalf("Expected no destinations to be valid, got %v", err)
+	}
+
+	invalid := [][]models.Destination{
+		{{URL: "https://a.example.com", Weight: 100}},
+		{{URL: "https://a.example.com", Weight: 0}, {URL: "https://b.example.com", Weight: 1}},
+		{{URL: "nope", Weight: 1}, {URL: "https://b.example.com", Weight: 1}},
+	}
+	for _, dests := range invalid {
+		if err := ValidateDestinations(dests); !errors.Is(err, ErrInvalidDestinations) {
+			t.Fatalf("Expected ErrInvalidDestinations for %+v, got %v", dests, err)
+		}
+	}
+}
+
+func TestPickDestination(t *testing.T) {
+	dests := []models.Destination{{ID: 1, URL: "https://a.example.com", Weight: 70}, {ID: 2, URL: "https://b.example.com", Weight: 30}}
+
+	t.Log("Rolls are mapped onto the weights in order")
+	for roll, want := range map[int]int{0: 1, 69: 1, 70: 2, 99: 2} {
+		got := pickDestination(dests, 0, func(n int) int {
+			if n != 100 {
+				t.Fatalf("Expected roll over total weight 100, got %d", n)
+			}
+			return roll
+		})
+		if got.ID != want {
+			t.Fatalf("Roll %d picked destination %d, want %d", roll, got.ID, want)
+		}
+	}
+
+	t.Log("A pinned destination is returned without rolling")
+	got := pickDestination(dests, 2, func(int) int { t.Fatal("Unexpected roll for pinned destination"); return 0 })
+	if got.ID != 2 {
+		t.Fatalf("Expected pinned destination 2, got %d", got.ID)
+	}
+
+	t.Log("A stale pin falls back to a weighted pick")
+	if got := pickDestination(dests, 99, func(int) int { return 0 }); got.ID != 1 {
+		t.Fatalf("Expected destination 1 for stale pin, got %d", got.ID)
+	}
+
+	if PickDestination(nil, 0) != nil {
+		t.Fatal("Expected nil without destinations")
+	}
+}
+
+func TestGetVariantClicksReadsRollups(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock db: %v", err)
+	}
+	defer db.Close()
+	rolledFrom := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rolledTo := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Log("The rolled range comes from the variant rollups, the rest from raw clicks")
+	mock.ExpectQuery(`SELECT rolled_from, rolled_to FROM rollup_state`).
+		WillReturnRows(sqlmock.NewRows([]string{"rolled_from", "rolled_to"}).AddRow(rolledFrom, rolledTo))
+	mock.ExpectQuery(`FROM variant_rollups_hourly r\s+WHERE r.variant_id = d.id AND r.bucket >= \$2 AND r.bucket < \$3`).
+		WithArgs(1, rolledFrom, rolledTo).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "destination_url", "weight", "clicks"}).
+			AddRow(10, "https://a.example.com", 70, 700).
+			AddRow(11, "https://b.example.com", 30, 290))
+
+	variants, err := NewAnalyticsService(db, nil, nil, false).GetVariantClicks(1)
+	if err != nil {
+		t.Fatalf("GetVariantClicks failed: %v", err)
+	}
+	if len(variants) != 2 || variants[0].Clicks != 700 || variants[1].Clicks != 290 {
+		t.Fatalf("Unexpected variant clicks: %+v", variants)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("Unmet db expectations: %v", err)
+	}
+}
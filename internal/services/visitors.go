@@ -0,0 +1,218 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"minify/internal/hll"
+	"minify/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// dayLayout formats the UTC day a visitor sketch covers
+const dayLayout = "2006-01-02"
+
+// sketchKey identifies a daily visitor sketch, urlID is 0 for the sketches of all links
+type sketchKey struct {
+	urlID int
+	day   string
+}
+
+// sketchQueries read and write one table of daily sketches. Each takes the keys as $1 (url ids)
+// and $2 (days), update also takes the encoded sketches as $3
+type sketchQueries struct {
+	ensure string // creates missing rows with an empty sketch
+	lock   string // selects url_id, day, sketch of the keys FOR UPDATE
+	update string
+}
+
+var urlSketchQueries = sketchQueries{
+	ensure: `
+		INSERT INTO url_visitor_sketches (url_id, day, sketch)
+		SELECT id, day, '' FROM unnest($1::int[], $2::date[]) AS k(id, day)
+		ON CONFLICT (url_id, day) DO NOTHING
+	`,
+	lock: `
+		SELECT url_id, day, sketch FROM url_visitor_sketches
+		WHERE (url_id, day) IN (SELECT * FROM unnest($1::int[], $2::date[]))
+		ORDER BY url_id, day
+		FOR UPDATE
+	`,
+	update: `
+		UPDATE url_visitor_sketches s SET sketch = k.sketch
+		FROM unnest($1::int[], $2::date[], $3::bytea[]) AS k(id, day, sketch)
+		WHERE s.url_id = k.id AND s.day = k.day
+	`,
+}
+
+var siteSketchQueries = sketchQueries{
+	ensure: `
+		INSERT INTO visitor_sketches (day, sketch)
+		SELECT day, '' FROM unnest($1::int[], $2::date[]) AS k(id, day)
+		ON CONFLICT (day) DO NOTHING
+	`,
+	lock: `
+		SELECT 0, day, sketch FROM visitor_sketches
+		WHERE day IN (SELECT day FROM unnest($1::int[], $2::date[]) AS k(id, day))
+		ORDER BY day
+		FOR UPDATE
+	`,
+	update: `
+		UPDATE visitor_sketches s SET sketch = k.sketch
+		FROM unnest($1::int[], $2::date[], $3::bytea[]) AS k(id, day, sketch)
+		WHERE s.day = k.day
+	`,
+}
+
+// visitorHash identifies a visitor by their IP and user agent. The salt keeps the stored sketches
+// from being checked against guessed IPs
+func visitorHash(salt []byte, ip, userAgent string) uint64 {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(ip))
+	mac.Write([]byte{0})
+	mac.Write([]byte(userAgent))
+
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// updateVisitorSketches adds a batch's visitors to the daily sketches of their links and of the
+// whole service, leaving out bots unless they're counted
+func (p *ClickPipeline) updateVisitorSketches(tx *sql.Tx, batch []models.Click) error {
+	links := make(map[sketchKey]*hll.Sketch)
+	site := make(map[sketchKey]*hll.Sketch)
+	for _, click := range batch {
+		if click.IsBot && !p.countBots {
+			continue
+		}
+		hash := visitorHash(p.visitorSalt, click.IPAddress, click.UserAgent)
+		day := click.ClickedAt.UTC().Format(dayLayout)
+		addToSketch(links, sketchKey{urlID: click.URLID, day: day}, hash)
+		addToSketch(site, sketchKey{day: day}, hash)
+	}
+
+	if err := mergeSketches(tx, urlSketchQueries, links); err != nil {
+		return fmt.Errorf("failed to update link visitor sketches: %w", err)
+	}
+	if err := mergeSketches(tx, siteSketchQueries, site); err != nil {
+		return fmt.Errorf("failed to update visitor sketches: %w", err)
+	}
+
+	return nil
+}
+
+func addToSketch(sketches map[sketchKey]*hll.Sketch, key sketchKey, hash uint64) {
+	s, ok := sketches[key]
+	if !ok {
+		s = hll.New()
+		sketches[key] = s
+	}
+	s.Add(hash)
+}
+
+// mergeSketches merges sketches into their stored rows. Rows are created first and then locked in
+// key order, so concurrent batches touching the same days wait for each other instead of
+// overwriting one another's visitors
+func mergeSketches(tx *sql.Tx, q sketchQueries, sketches map[sketchKey]*hll.Sketch) error {
+	if len(sketches) == 0 {
+		return nil
+	}
+
+	keys := make([]sketchKey, 0, len(sketches))
+	for key := range sketches {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].urlID != keys[j].urlID {
+			return keys[i].urlID < keys[j].urlID
+		}
+		return keys[i].day < keys[j].day
+	})
+	ids := make([]int64, len(keys))
+	days := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i], days[i] = int64(key.urlID), key.day
+	}
+
+	if _, err := tx.Exec(q.ensure, pq.Array(ids), pq.Array(days)); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(q.lock, pq.Array(ids), pq.Array(days))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key sketchKey
+		var day time.Time
+		var encoded []byte
+		if err := rows.Scan(&key.urlID, &day, &encoded); err != nil {
+			return err
+		}
+		key.day = day.Format(dayLayout)
+
+		stored, err := hll.FromBytes(encoded)
+		if err != nil {
+			log.Printf("[ClickPipeline] Replacing unreadable visitor sketch for URL ID %d on %s\n", key.urlID, key.day)
+			continue
+		}
+		if s, ok := sketches[key]; ok {
+			s.Merge(stored)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	encoded := make([][]byte, len(keys))
+	for i, key := range keys {
+		encoded[i] = sketches[key].Bytes()
+	}
+	_, err = tx.Exec(q.update, pq.Array(ids), pq.Array(days), pq.Array(encoded))
+
+	return err
+}
+
+// uniqueVisitors estimates the distinct visitors between from and to, for one link or all links if
+// urlID is nil. Sketches cover whole UTC days, so the range is widened to the days it touches
+func (s *AnalyticsService) uniqueVisitors(urlID *int, from, to time.Time) (int, error) {
+	firstDay := from.UTC().Format(dayLayout)
+	lastDay := to.UTC().Add(-time.Nanosecond).Format(dayLayout)
+
+	var rows *sql.Rows
+	var err error
+	if urlID == nil {
+		rows, err = s.db.Query(`SELECT sketch FROM visitor_sketches WHERE day BETWEEN $1 AND $2`, firstDay, lastDay)
+	} else {
+		rows, err = s.db.Query(`SELECT sketch FROM url_visitor_sketches WHERE url_id = $1 AND day BETWEEN $2 AND $3`,
+			*urlID, firstDay, lastDay)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	visitors := hll.New()
+	for rows.Next() {
+		var encoded []byte
+		if err := rows.Scan(&encoded); err != nil {
+			return 0, err
+		}
+		sketch, err := hll.FromBytes(encoded)
+		if err != nil {
+			log.Println("[AnalyticsService] Skipping unreadable visitor sketch:", err)
+			continue
+		}
+		visitors.Merge(sketch)
+	}
+
+	return int(visitors.Estimate()), rows.Err()
+}
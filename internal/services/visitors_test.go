@@ -0,0 +1,45 @@
+package services
+
+import (
+	"testing"
+
+	"minify/internal/hll"
+)
+
+func TestVisitorHash(t *testing.T) {
+	a := visitorHash([]byte("salt"), "203.0.113.7", "Mozilla/5.0")
+	if a != visitorHash([]byte("salt"), "203.0.113.7", "Mozilla/5.0") {
+		t.Fatal("Expected the same visitor to hash the same")
+	}
+	if a == visitorHash([]byte("other"), "203.0.113.7", "Mozilla/5.0") {
+		t.Fatal("Expected the salt to change the hash")
+	}
+	if visitorHash(nil, "1.2.3.4", "5") == visitorHash(nil, "1.2.3.45", "") {
+		t.Fatal("Expected ip and user agent to be kept apart")
+	}
+}
+
+func TestAddToSketch(t *testing.T) {
+	sketches := make(map[sketchKey]*hll.Sketch)
+	monday := sketchKey{urlID: 1, day: "2024-03-11"}
+	tuesday := sketchKey{urlID: 1, day: "2024-03-12"}
+
+	for i := 0; i < 3; i++ {
+		addToSketch(sketches, monday, visitorHash(nil, "203.0.113.7", "Mozilla/5.0"))
+	}
+	addToSketch(sketches, tuesday, visitorHash(nil, "203.0.113.7", "Mozilla/5.0"))
+	addToSketch(sketches, tuesday, visitorHash(nil, "198.51.100.1", "Mozilla/5.0"))
+
+	if got := sketches[monday].Estimate(); got != 1 {
+		t.Fatalf("Expected 1 visitor on monday, got %d", got)
+	}
+	if got := sketches[tuesday].Estimate(); got != 2 {
+		t.Fatalf("Expected 2 visitors on tuesday, got %d", got)
+	}
+
+	t.Log("Merging the days counts the returning visitor once")
+	sketches[monday].Merge(sketches[tuesday])
+	if got := sketches[monday].Estimate(); got != 2 {
+		t.Fatalf("Expected 2 visitors across both days, got %d", got)
+	}
+}
@@ -0,0 +1,386 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"minify/internal/metrics"
+	"minify/internal/models"
+	"minify/internal/utils"
+)
+
+const (
+	webhookBatchSize     = 50               // deliveries claimed per poll
+	webhookConcurrency   = 8                // deliveries sent at once
+	webhookTimeout       = 10 * time.Second // per request, anything slower counts as a failure
+	webhookLease         = time.Minute      // a claimed delivery is retried after this if its result is never recorded
+	webhookMaxAttempts   = 10               // attempts before a delivery is given up on
+	webhookDisableAfter  = 20               // consecutive failed attempts before a webhook is disabled
+	webhookBaseBackoff   = 30 * time.Second
+	webhookMaxBackoff    = 6 * time.Hour
+	webhookMaxErrorBytes = 500
+	webhookSweepInterval = time.Hour // how often finished deliveries past the retention are deleted
+	webhookSweepChunk    = 5000      // deliveries deleted at a time
+)
+
+var ErrPrivateAddress = errors.New("webhook target resolves to a private address")
+
+// WebhookDispatcher delivers queued webhook events from the webhook_deliveries outbox. Deliveries are
+// at least once: a receiver can see an event again (with the same id) if recording its result failed
+type WebhookDispatcher struct {
+	db        *sql.DB
+	client    *http.Client
+	retention time.Duration // how long delivered and failed deliveries are kept, 0 keeps them forever
+}
+
+// pendingDelivery is a claimed outbox row along with its webhook's target
+type pendingDelivery struct {
+	id        int64
+	webhookID int
+	eventType string
+	payload   []byte
+	attempts  int
+	createdAt time.Time
+	url       string
+	secret    string
+}
+
+// deliveryResult is the outcome of one attempt
+type deliveryResult struct {
+	statusCode int // 0 if no response was received
+	err        error
+	duration   time.Duration
+}
+
+func (r deliveryResult) success() bool {
+	return r.err == nil && r.statusCode >= 200 && r.statusCode < 300
+}
+
+// NewWebhookDispatcher builds a dispatcher whose client refuses to connect to loopback, private and
+// link-local addresses unless allowPrivate is set, so webhooks can't be pointed at internal services.
+// Finished deliveries and their attempts are deleted once they're older than retention
+func NewWebhookDispatcher(db *sql.DB, allowPrivate bool, retention time.Duration) *WebhookDispatcher {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		// checked on the resolved address at connect time, so DNS can't be used to sneak past it
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return ErrPrivateAddress
+			}
+
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &WebhookDispatcher{
+		db:        db,
+		retention: retention,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   webhookTimeout,
+			// a redirect is reported as the failed attempt's status rather than followed
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+}
+
+// Run delivers due events every interval, and sweeps old deliveries every webhookSweepInterval,
+// until ctx is cancelled
+func (d *WebhookDispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastSweep time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if time.Since(lastSweep) >= webhookSweepInterval {
+				lastSweep = time.Now()
+				if _, err := d.Sweep(ctx, lastSweep.Add(-d.retention)); err != nil {
+					log.Println("[WebhookDispatcher] Sweeping old deliveries failed:", err)
+				}
+			}
+			// keep going while full batches are waiting
+			for ctx.Err() == nil {
+				n, err := d.DeliverDue(ctx)
+				if err != nil {
+					log.Println("[WebhookDispatcher] Delivering webhooks failed:", err)
+					break
+				}
+				if n < webhookBatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// Sweep deletes the deliveries that were delivered or given up on before cutoff, a chunk at a time,
+// and returns how many it deleted. Their attempts go with them. Does nothing if retention is 0
+func (d *WebhookDispatcher) Sweep(ctx context.Context, cutoff time.Time) (int, error) {
+	if d.retention <= 0 {
+		return 0, nil
+	}
+
+	query := `
+		DELETE FROM webhook_deliveries
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE COALESCE(delivered_at, failed_at) < $1
+			LIMIT $2
+		)
+	`
+	swept := 0
+	for ctx.Err() == nil {
+		res, err := d.db.ExecContext(ctx, query, cutoff.UTC(), webhookSweepChunk)
+		if err != nil {
+			return swept, fmt.Errorf("failed to delete old webhook deliveries: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return swept, fmt.Errorf("failed to delete old webhook deliveries: %w", err)
+		}
+		swept += int(n)
+		if n < webhookSweepChunk {
+			break
+		}
+	}
+	if swept > 0 {
+		log.Printf("[WebhookDispatcher] Deleted %d deliveries finished before %s\n", swept, cutoff.Format(time.RFC3339))
+	}
+
+	return swept, ctx.Err()
+}
+
+// DeliverDue claims a batch of due deliveries, sends them and records the results, returning the
+// number claimed
+func (d *WebhookDispatcher) DeliverDue(ctx context.Context) (int, error) {
+	pending, err := d.claim()
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, webhookConcurrency)
+	for _, p := range pending {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(p pendingDelivery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result := d.deliver(ctx, p)
+			if ctx.Err() != nil {
+				// shutting down, the lease runs out and the delivery is retried on the next start
+				return
+			}
+			if err := d.record(p, result); err != nil {
+				log.Printf("[WebhookDispatcher] Failed to record delivery %d: %v\n", p.id, err)
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	return len(pending), nil
+}
+
+// claim leases due deliveries of active webhooks by pushing their next attempt past the lease.
+// SKIP LOCKED lets several instances share the outbox without sending the same delivery twice
+func (d *WebhookDispatcher) claim() ([]pendingDelivery, error) {
+	query := `
+		WITH due AS (
+			SELECT d.id FROM webhook_deliveries d
+			JOIN webhooks w ON w.id = d.webhook_id
+			WHERE d.delivered_at IS NULL AND d.failed_at IS NULL AND d.next_attempt_at <= CURRENT_TIMESTAMP AND w.active
+			ORDER BY d.next_attempt_at
+			LIMIT $1
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)
+		FROM due, webhooks w
+		WHERE d.id = due.id AND w.id = d.webhook_id
+		RETURNING d.id, d.webhook_id, d.event_type, d.payload, d.attempts, d.created_at, w.url, w.secret
+	`
+	rows, err := d.db.Query(query, webhookBatchSize, webhookLease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var pending []pendingDelivery
+	for rows.Next() {
+		var p pendingDelivery
+		if err := rows.Scan(&p.id, &p.webhookID, &p.eventType, &p.payload, &p.attempts, &p.createdAt, &p.url, &p.secret); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		pending = append(pending, p)
+	}
+
+	return pending, rows.Err()
+}
+
+// deliver POSTs the event to the webhook with its signature headers
+func (d *WebhookDispatcher) deliver(ctx context.Context, p pendingDelivery) deliveryResult {
+	body, err := json.Marshal(models.WebhookEvent{ID: p.id, Type: p.eventType, CreatedAt: p.createdAt, Data: p.payload})
+	if err != nil {
+		return deliveryResult{err: fmt.Errorf("failed to encode event: %w", err)}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return deliveryResult{err: err}
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Minify-Webhooks/1.0")
+	req.Header.Set("X-Minify-Event", p.eventType)
+	req.Header.Set("X-Minify-Delivery", strconv.FormatInt(p.id, 10))
+	req.Header.Set("X-Minify-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Minify-Signature", "sha256="+SignWebhook(p.secret, timestamp, body))
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	result := deliveryResult{err: err, duration: time.Since(start)}
+	if err != nil {
+		return result
+	}
+	defer resp.Body.Close()
+	// drain a little so the connection can be reused, receivers have nothing to tell us
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	result.statusCode = resp.StatusCode
+
+	return result
+}
+
+// record logs the attempt, then marks the delivery done, or schedules its retry with exponential
+// backoff until it runs out of attempts. Failures count towards disabling the webhook
+func (d *WebhookDispatcher) record(p pendingDelivery, result deliveryResult) error {
+	attempt := p.attempts + 1
+	success := result.success()
+	metrics.RecordWebhookDelivery(success)
+
+	var statusCode *int
+	if result.statusCode != 0 {
+		statusCode = &result.statusCode
+	}
+	var errMsg *string
+	if result.err != nil {
+		msg := utils.Truncate(result.err.Error(), webhookMaxErrorBytes)
+		errMsg = &msg
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO webhook_attempts (delivery_id, webhook_id, attempt, status_code, error, duration_ms, success)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	if _, err := tx.Exec(query, p.id, p.webhookID, attempt, statusCode, errMsg, result.duration.Milliseconds(), success); err != nil {
+		return fmt.Errorf("failed to log attempt: %w", err)
+	}
+
+	if success {
+		if _, err := tx.Exec(`UPDATE webhook_deliveries SET attempts = $2, delivered_at = CURRENT_TIMESTAMP WHERE id = $1`,
+			p.id, attempt); err != nil {
+			return fmt.Errorf("failed to mark delivery done: %w", err)
+		}
+		if _, err := tx.Exec(`UPDATE webhooks SET consecutive_failures = 0 WHERE id = $1 AND consecutive_failures > 0`,
+			p.webhookID); err != nil {
+			return fmt.Errorf("failed to reset webhook failures: %w", err)
+		}
+
+		return tx.Commit()
+	}
+
+	query = `
+		UPDATE webhook_deliveries
+		SET attempts = $2,
+			next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $3),
+			failed_at = CASE WHEN $2 >= $4 THEN CURRENT_TIMESTAMP END
+		WHERE id = $1
+	`
+	if _, err := tx.Exec(query, p.id, attempt, webhookBackoff(attempt).Seconds(), webhookMaxAttempts); err != nil {
+		return fmt.Errorf("failed to schedule retry: %w", err)
+	}
+
+	query = `
+		UPDATE webhooks
+		SET consecutive_failures = consecutive_failures + 1,
+			active = active AND consecutive_failures + 1 < $2,
+			disabled_at = CASE WHEN active AND consecutive_failures + 1 >= $2 THEN CURRENT_TIMESTAMP ELSE disabled_at END
+		WHERE id = $1
+		RETURNING active
+	`
+	var active bool
+	if err := tx.QueryRow(query, p.webhookID, webhookDisableAfter).Scan(&active); err != nil {
+		return fmt.Errorf("failed to count webhook failure: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit attempt: %w", err)
+	}
+	if !active {
+		log.Printf("[WebhookDispatcher] Webhook %d is disabled after %d consecutive failures\n", p.webhookID, webhookDisableAfter)
+	}
+
+	return nil
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of "<timestamp>.<body>" under the webhook's secret. Receivers
+// recompute it from the X-Minify-Timestamp header and raw body, and compare it to X-Minify-Signature
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff is the wait before retrying after the given failed attempt: doubling from
+// webhookBaseBackoff up to webhookMaxBackoff, plus up to 20% jitter so retries don't line up
+func webhookBackoff(attempt int) time.Duration {
+	backoff := webhookMaxBackoff
+	if attempt < 20 {
+		if d := webhookBaseBackoff << (attempt - 1); d < webhookMaxBackoff {
+			backoff = d
+		}
+	}
+
+	return backoff + time.Duration(rand.Int63n(int64(backoff/5)+1))
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
@@ -0,0 +1,326 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"minify/internal/models"
+	"minify/internal/utils"
+
+	"github.com/lib/pq"
+)
+
+// webhook event types
+const (
+	EventLinkCreated   = "link.created"
+	EventLinkUpdated   = "link.updated"
+	EventLinkDeleted   = "link.deleted"
+	EventClickRecorded = "click.recorded"
+)
+
+// WebhookEvents lists the event types a webhook can subscribe to
+var WebhookEvents = []string{EventLinkCreated, EventLinkUpdated, EventLinkDeleted, EventClickRecorded}
+
+const (
+	maxWebhooksPerUser  = 10
+	minWebhookSecretLen = 16
+	maxWebhookAttempts  = 50 // delivery log entries returned at most
+)
+
+var (
+	ErrWebhookNotFound = errors.New("webhook not found")
+	ErrInvalidWebhook  = errors.New("invalid webhook")
+	ErrTooManyWebhooks = fmt.Errorf("at most %d webhooks are allowed", maxWebhooksPerUser)
+)
+
+// webhookColumns lists the columns scanned by scanWebhook, in order
+const webhookColumns = `id, user_id, url, events, active, consecutive_failures, disabled_at, created_at, updated_at`
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+type WebhookService struct {
+	db *sql.DB
+}
+
+func NewWebhookService(db *sql.DB) *WebhookService {
+	return &WebhookService{db: db}
+}
+
+// CreateWebhook subscribes url to the owner's events, generating a secret if none is given.
+// The secret is only returned here, later reads leave it out
+func (s *WebhookService) CreateWebhook(userID int, req models.CreateWebhookRequest) (*models.Webhook, error) {
+	if err := validateWebhook(req.URL, req.Events); err != nil {
+		return nil, err
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+		}
+		secret = hex.EncodeToString(b)
+	} else if len(secret) < minWebhookSecretLen {
+		return nil, fmt.Errorf("%w: secret must be at least %d characters", ErrInvalidWebhook, minWebhookSecretLen)
+	}
+
+	var count int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM webhooks WHERE user_id = $1`, userID).Scan(&count); err != nil {
+		return nil, fmt.Errorf("failed to count webhooks: %w", err)
+	}
+	if count >= maxWebhooksPerUser {
+		return nil, ErrTooManyWebhooks
+	}
+
+	query := `
+		INSERT INTO webhooks (user_id, url, events, secret)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + webhookColumns
+	webhook, err := scanWebhook(s.db.QueryRow(query, userID, req.URL, pq.Array(req.Events), secret))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+	webhook.Secret = secret
+	log.Printf("[WebhookService] Created webhook %d for user %d\n", webhook.ID, userID)
+
+	return webhook, nil
+}
+
+// GetWebhooks returns the owner's webhooks, oldest first
+func (s *WebhookService) GetWebhooks(userID int) ([]*models.Webhook, error) {
+	rows, err := s.db.Query(`SELECT `+webhookColumns+` FROM webhooks WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	webhooks := []*models.Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// GetWebhook returns one of the owner's webhooks, other users' webhooks are reported as not found
+func (s *WebhookService) GetWebhook(id, userID int) (*models.Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE id = $1 AND user_id = $2`
+	webhook, err := scanWebhook(s.db.QueryRow(query, id, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+
+	return webhook, nil
+}
+
+// UpdateWebhook changes a webhook's url, events or active flag. Activating it also clears its
+// failure count, so a webhook disabled by failures gets a fresh start
+func (s *WebhookService) UpdateWebhook(id, userID int, req models.UpdateWebhookRequest) (*models.Webhook, error) {
+	current, err := s.GetWebhook(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	url, events := current.URL, current.Events
+	if req.URL != nil {
+		url = *req.URL
+	}
+	if req.Events != nil {
+		events = *req.Events
+	}
+	if err := validateWebhook(url, events); err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE webhooks
+		SET url = $2,
+			events = $3,
+			active = COALESCE($4, active),
+			consecutive_failures = CASE WHEN $4 THEN 0 ELSE consecutive_failures END,
+			disabled_at = CASE WHEN $4 THEN NULL ELSE disabled_at END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING ` + webhookColumns
+	webhook, err := scanWebhook(s.db.QueryRow(query, id, url, pq.Array(events), req.Active))
+	if err != nil {
+		return nil, fmt.Errorf("failed to update webhook: %w", err)
+	}
+
+	return webhook, nil
+}
+
+// DeleteWebhook removes a webhook along with its pending deliveries and delivery log
+func (s *WebhookService) DeleteWebhook(id, userID int) error {
+	res, err := s.db.Exec(`DELETE FROM webhooks WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+// GetWebhookAttempts returns the most recent delivery attempts of one of the owner's webhooks
+func (s *WebhookService) GetWebhookAttempts(id, userID, limit int) ([]*models.WebhookAttempt, error) {
+	if _, err := s.GetWebhook(id, userID); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > maxWebhookAttempts {
+		limit = maxWebhookAttempts
+	}
+
+	query := `
+		SELECT a.id, a.delivery_id, d.event_type, a.attempt, a.success, a.status_code, COALESCE(a.error, ''),
+			a.duration_ms, a.attempted_at
+		FROM webhook_attempts a
+		JOIN webhook_deliveries d ON d.id = a.delivery_id
+		WHERE a.webhook_id = $1
+		ORDER BY a.attempted_at DESC, a.id DESC
+		LIMIT $2
+	`
+	rows, err := s.db.Query(query, id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook attempts: %w", err)
+	}
+	defer rows.Close()
+
+	attempts := []*models.WebhookAttempt{}
+	for rows.Next() {
+		var a models.WebhookAttempt
+		if err := rows.Scan(&a.ID, &a.DeliveryID, &a.EventType, &a.Attempt, &a.Success, &a.StatusCode, &a.Error,
+			&a.DurationMS, &a.AttemptedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook attempt: %w", err)
+		}
+		attempts = append(attempts, &a)
+	}
+
+	return attempts, rows.Err()
+}
+
+func validateWebhook(target string, events []string) error {
+	if !utils.IsValidURL(target) || !(strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")) {
+		return fmt.Errorf("%w: url must be an absolute http(s) URL", ErrInvalidWebhook)
+	}
+	if len(events) == 0 {
+		return fmt.Errorf("%w: subscribe to at least one of %v", ErrInvalidWebhook, WebhookEvents)
+	}
+	for _, event := range events {
+		if !contains(WebhookEvents, event) {
+			return fmt.Errorf("%w: unknown event %q", ErrInvalidWebhook, event)
+		}
+	}
+
+	return nil
+}
+
+func scanWebhook(row rowScanner) (*models.Webhook, error) {
+	var w models.Webhook
+	if err := row.Scan(&w.ID, &w.UserID, &w.URL, pq.Array(&w.Events), &w.Active, &w.ConsecutiveFailures,
+		&w.DisabledAt, &w.CreatedAt, &w.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	return &w, nil
+}
+
+// enqueueLinkEvent adds a link event to the outbox of each of the owner's webhooks subscribed to it.
+// It runs in the transaction changing the link, so an event is queued exactly when the change commits
+func enqueueLinkEvent(q execer, eventType string, url *models.URL) error {
+	if url.UserID == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(url)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", eventType, err)
+	}
+
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event_type, payload)
+		SELECT id, $2::text, $3 FROM webhooks
+		WHERE user_id = $1 AND active AND $2::text = ANY(events)
+	`
+	if _, err := q.Exec(query, *url.UserID, eventType, string(payload)); err != nil {
+		return fmt.Errorf("failed to queue %s event: %w", eventType, err)
+	}
+
+	return nil
+}
+
+// clickEventData is the payload of a click.recorded event, short_code is added from the link
+type clickEventData struct {
+	ClickedAt   time.Time `json:"clicked_at"`
+	Country     string    `json:"country,omitempty"`
+	Region      string    `json:"region,omitempty"`
+	Device      string    `json:"device,omitempty"`
+	OS          string    `json:"os,omitempty"`
+	Browser     string    `json:"browser,omitempty"`
+	IsBot       bool      `json:"is_bot"`
+	Referrer    string    `json:"referrer,omitempty"`
+	UTMSource   string    `json:"utm_source,omitempty"`
+	UTMMedium   string    `json:"utm_medium,omitempty"`
+	UTMCampaign string    `json:"utm_campaign,omitempty"`
+}
+
+// enqueueClickEvents adds click.recorded events for a batch of clicks to the outbox of each of the
+// link owners' webhooks subscribed to them
+func enqueueClickEvents(q execer, clicks []models.Click) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(clicks))
+	payloads := make([]string, len(clicks))
+	for i, click := range clicks {
+		payload, err := json.Marshal(clickEventData{
+			ClickedAt:   click.ClickedAt,
+			Country:     click.Country,
+			Region:      click.Region,
+			Device:      click.Device,
+			OS:          click.OS,
+			Browser:     click.Browser,
+			IsBot:       click.IsBot,
+			Referrer:    click.Referrer,
+			UTMSource:   click.UTMSource,
+			UTMMedium:   click.UTMMedium,
+			UTMCampaign: click.UTMCampaign,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to encode click event: %w", err)
+		}
+		ids[i], payloads[i] = int64(click.URLID), string(payload)
+	}
+
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event_type, payload)
+		SELECT w.id, $3::text, c.payload || jsonb_build_object('short_code', u.short_code)
+		FROM unnest($1::int[], $2::jsonb[]) AS c(url_id, payload)
+		JOIN urls u ON u.id = c.url_id
+		JOIN webhooks w ON w.user_id = u.user_id AND w.active AND $3::text = ANY(w.events)
+	`
+	if _, err := q.Exec(query, pq.Array(ids), pq.Array(payloads), EventClickRecorded); err != nil {
+		return fmt.Errorf("failed to queue click events: %w", err)
+	}
+
+	return nil
+}
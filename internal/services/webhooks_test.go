@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"minify/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestValidateWebhook(t *testing.T) {
+	if err := validateWebhook("https://hooks.example.com/minify", []string{EventLinkCreated, EventClickRecorded}); err != nil {
+		t.Fatalf("Expected a valid webhook, got %v", err)
+	}
+
+	invalid := []struct {
+		url    string
+		events []string
+	}{
+		{"not a url", []string{EventLinkCreated}},
+		{"ftp://example.com/hook", []string{EventLinkCreated}},
+		{"https://example.com/hook", nil},
+		{"https://example.com/hook", []string{"link.exploded"}},
+	}
+	for _, tt := range invalid {
+		if err := validateWebhook(tt.url, tt.events); !errors.Is(err, ErrInvalidWebhook) {
+			t.Errorf("Expected ErrInvalidWebhook for %q %v, got %v", tt.url, tt.events, err)
+		}
+	}
+}
+
+func TestWebhookDeliverSignsEvent(t *testing.T) {
+	const secret = "0123456789abcdef"
+	var got struct {
+		headers http.Header
+		body    []byte
+	}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.headers = r.Header.Clone()
+		got.body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	d := NewWebhookDispatcher(nil, true, 0)
+	p := pendingDelivery{
+		id:        42,
+		eventType: EventLinkCreated,
+		payload:   []byte(`{"short_code":"abc"}`),
+		createdAt: time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC),
+		url:       receiver.URL,
+		secret:    secret,
+	}
+
+	result := d.deliver(context.Background(), p)
+	if !result.success() || result.statusCode != http.StatusNoContent {
+		t.Fatalf("Expected a successful delivery, got %d %v", result.statusCode, result.err)
+	}
+
+	t.Log("The signature covers the timestamp header and the raw body")
+	timestamp, err := strconv.ParseInt(got.headers.Get("X-Minify-Timestamp"), 10, 64)
+	if err != nil {
+		t.Fatalf("Missing timestamp header: %v", err)
+	}
+	if want := "sha256=" + SignWebhook(secret, timestamp, got.body); got.headers.Get("X-Minify-Signature") != want {
+		t.Fatalf("Signature mismatch: got %s, want %s", got.headers.Get("X-Minify-Signature"), want)
+	}
+	if SignWebhook("another secret!!", timestamp, got.body) == SignWebhook(secret, timestamp, got.body) {
+		t.Fatal("Expected the signature to depend on the secret")
+	}
+	if got.headers.Get("X-Minify-Event") != EventLinkCreated || got.headers.Get("X-Minify-Delivery") != "42" {
+		t.Fatalf("Unexpected event headers: %v", got.headers)
+	}
+
+	var event models.WebhookEvent
+	if err := json.Unmarshal(got.body, &event); err != nil {
+		t.Fatalf("Failed to decode body: %v", err)
+	}
+	if event.ID != 42 || event.Type != EventLinkCreated || string(event.Data) != `{"short_code":"abc"}` {
+		t.Fatalf("Unexpected event: %+v", event)
+	}
+}
+
+func TestWebhookDeliverFailures(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	d := NewWebhookDispatcher(nil, true, 0)
+	for path, status := range map[string]int{"/broken": http.StatusInternalServerError, "/moved": http.StatusFound} {
+		result := d.deliver(context.Background(), pendingDelivery{id: 1, payload: []byte(`{}`), url: receiver.URL + path})
+		if result.success() || result.statusCode != status {
+			t.Errorf("Expected %s to fail with %d, got %d %v", path, status, result.statusCode, result.err)
+		}
+	}
+
+	t.Log("Private addresses are refused unless allowed")
+	strict := NewWebhookDispatcher(nil, false, 0)
+	result := strict.deliver(context.Background(), pendingDelivery{id: 1, payload: []byte(`{}`), url: receiver.URL})
+	if result.success() || !errors.Is(result.err, ErrPrivateAddress) {
+		t.Fatalf("Expected ErrPrivateAddress, got %d %v", result.statusCode, result.err)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	for attempt, base := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		5:  8 * time.Minute,
+		12: webhookMaxBackoff,
+		64: webhookMaxBackoff,
+	} {
+		got := webhookBackoff(attempt)
+		if got < base || got > base+base/5 {
+			t.Errorf("Attempt %d: expected %s plus up to 20%% jitter, got %s", attempt, base, got)
+		}
+	}
+}
+
+func TestWebhookSweep(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock db: %v", err)
+	}
+	defer db.Close()
+	cutoff := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	t.Log("Nothing is deleted without a retention")
+	if n, err := NewWebhookDispatcher(db, true, 0).Sweep(context.Background(), cutoff); err != nil || n != 0 {
+		t.Fatalf("Expected no sweep, got %d, %v", n, err)
+	}
+
+	t.Log("Finished deliveries before the cutoff are deleted a chunk at a time")
+	query := `DELETE FROM webhook_deliveries\s+WHERE id IN \(\s+SELECT id FROM webhook_deliveries\s+WHERE COALESCE\(delivered_at, failed_at\) < \$1`
+	mock.ExpectExec(query).WithArgs(cutoff, webhookSweepChunk).WillReturnResult(sqlmock.NewResult(0, webhookSweepChunk))
+	mock.ExpectExec(query).WithArgs(cutoff, webhookSweepChunk).WillReturnResult(sqlmock.NewResult(0, 3))
+
+	n, err := NewWebhookDispatcher(db, true, 24*time.Hour).Sweep(context.Background(), cutoff)
+	if err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+	if n != webhookSweepChunk+3 {
+		t.Fatalf("Expected %d deliveries deleted, got %d", webhookSweepChunk+3, n)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("Unmet db expectations: %v", err)
+	}
+}
+
+func TestRestoreURLQueuesEvent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock db: %v", err)
+	}
+	defer db.Close()
+	userID := 7
+	now := time.Now()
+	deletedAt := now.Add(-time.Hour)
+	row := func(deletedAt interface{}) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "short_code", "original_url", "user_id", "clicks", "expires_at", "max_clicks",
+			"expired", "active", "deleted_at", "password_hash", "redirect_type", "forward_query", "forward_path",
+			"targeting_rules", "country_overrides", "sticky_variants", "destinations", "created_at", "updated_at"}).
+			AddRow(3, "abc1234", "https://example.com", userID, 0, nil, nil, false, true, deletedAt, "", 302, "", false,
+				nil, nil, false, nil, now, now)
+	}
+
+	mock.ExpectQuery(`FROM urls WHERE short_code = \$1`).WithArgs("abc1234").WillReturnRows(row(deletedAt))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE urls SET deleted_at = NULL`).WithArgs(3).WillReturnRows(row(nil))
+	mock.ExpectExec(`INSERT INTO webhook_deliveries`).WithArgs(userID, EventLinkUpdated, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	url, err := NewURLService(db, nil, nil).RestoreURL("abc1234", userID, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("RestoreURL failed: %v", err)
+	}
+	if url.DeletedAt != nil {
+		t.Fatalf("Expected the link to be restored, got deleted_at %v", url.DeletedAt)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("Unmet db expectations: %v", err)
+	}
+}
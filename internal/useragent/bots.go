@@ -0,0 +1,82 @@
+package useragent
+
+import "strings"
+
+// knownBots maps a lowercase User-Agent token to the crawler's name. Link-preview crawlers
+// come first since they hit links as soon as they're shared; add new ones here as they show up.
+// Tokens that also appear in in-app browsers used by people (e.g. LINE, Snapchat) are left out
+var knownBots = []struct {
+	token string
+	name  string
+}{
+	// link previews / unfurlers
+	{"slackbot", "Slackbot"},
+	{"slack-imgproxy", "Slackbot"},
+	{"facebookexternalhit", "facebookexternalhit"},
+	{"facebot", "Facebot"},
+	{"twitterbot", "Twitterbot"},
+	{"linkedinbot", "LinkedInBot"},
+	{"discordbot", "Discordbot"},
+	{"telegrambot", "TelegramBot"},
+	{"whatsapp", "WhatsApp"},
+	{"skypeuripreview", "SkypeUriPreview"},
+	{"microsoftpreview", "MicrosoftPreview"},
+	{"redditbot", "redditbot"},
+	{"pinterestbot", "Pinterestbot"},
+	{"embedly", "Embedly"},
+	{"iframely", "Iframely"},
+	{"vkshare", "vkShare"},
+	{"mastodon", "Mastodon"},
+	{"bluesky", "Bluesky"},
+	{"kakaotalk-scrap", "KakaoTalk"},
+	{"google-pagerenderer", "Google PageRenderer"},
+
+	// search engines and archivers
+	{"googlebot", "Googlebot"},
+	{"google-inspectiontool", "Google-InspectionTool"},
+	{"adsbot-google", "AdsBot-Google"},
+	{"bingbot", "bingbot"},
+	{"bingpreview", "BingPreview"},
+	{"applebot", "Applebot"},
+	{"duckduckbot", "DuckDuckBot"},
+	{"yandexbot", "YandexBot"},
+	{"baiduspider", "Baiduspider"},
+	{"petalbot", "PetalBot"},
+	{"ia_archiver", "ia_archiver"},
+	{"archive.org_bot", "archive.org_bot"},
+
+	// http clients and monitoring
+	{"curl/", "curl"},
+	{"wget/", "Wget"},
+	{"python-requests", "python-requests"},
+	{"python-urllib", "Python-urllib"},
+	{"go-http-client", "Go-http-client"},
+	{"okhttp", "okhttp"},
+	{"headlesschrome", "HeadlessChrome"},
+	{"uptimerobot", "UptimeRobot"},
+	{"pingdom", "Pingdom"},
+}
+
+// genericBotTokens catch crawlers missing from knownBots
+var genericBotTokens = []string{"bot/", "bot;", "crawler", "spider", "preview"}
+
+// detectBot returns the crawler's name if ua belongs to a known or self-identified bot.
+// An empty User-Agent is treated as a bot since browsers always send one
+func detectBot(lower string) (string, bool) {
+	if lower == "" {
+		return "unknown", true
+	}
+
+	for _, bot := range knownBots {
+		if strings.Contains(lower, bot.token) {
+			return bot.name, true
+		}
+	}
+	for _, token := range genericBotTokens {
+		if strings.Contains(lower, token) {
+			return "other", true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,115 @@
+package useragent
+
+import (
+	"regexp"
+	"strings"
+)
+
+// operating systems
+const (
+	OSiOS      = "ios"
+	OSAndroid  = "android"
+	OSWindows  = "windows"
+	OSMacOS    = "macos"
+	OSLinux    = "linux"
+	OSChromeOS = "chromeos"
+	OSUnknown  = "unknown"
+)
+
+// device classes
+const (
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+	DeviceUnknown = "unknown"
+)
+
+// browsers
+const (
+	BrowserChrome  = "chrome"
+	BrowserSafari  = "safari"
+	BrowserFirefox = "firefox"
+	BrowserEdge    = "edge"
+	BrowserOpera   = "opera"
+	BrowserSamsung = "samsung"
+	BrowserIE      = "ie"
+	BrowserUnknown = "unknown"
+)
+
+// Known lists the valid values of each attribute, e.g. for validating targeting rules
+var Known = struct {
+	OS, Devices, Browsers []string
+}{
+	OS:       []string{OSiOS, OSAndroid, OSWindows, OSMacOS, OSLinux, OSChromeOS},
+	Devices:  []string{DeviceMobile, DeviceTablet, DeviceDesktop},
+	Browsers: []string{BrowserChrome, BrowserSafari, BrowserFirefox, BrowserEdge, BrowserOpera, BrowserSamsung, BrowserIE},
+}
+
+// Info holds the attributes parsed from a User-Agent header
+type Info struct {
+	Browser        string
+	BrowserVersion string
+	OS             string
+	Device         string
+	IsBot          bool   // crawler, link-preview fetcher or scripted client
+	Bot            string // crawler name if IsBot
+}
+
+// browserPatterns are checked in order, since most browsers also claim to be Chrome and/or Safari
+var browserPatterns = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{BrowserEdge, regexp.MustCompile(`(?:Edge|Edg|EdgA|EdgiOS)/([\d.]+)`)},
+	{BrowserOpera, regexp.MustCompile(`(?:OPR|Opera|OPT)/([\d.]+)`)},
+	{BrowserSamsung, regexp.MustCompile(`SamsungBrowser/([\d.]+)`)},
+	{BrowserFirefox, regexp.MustCompile(`(?:Firefox|FxiOS)/([\d.]+)`)},
+	{BrowserChrome, regexp.MustCompile(`(?:Chrome|CriOS)/([\d.]+)`)},
+	{BrowserSafari, regexp.MustCompile(`Version/([\d.]+).*Safari/`)},
+	{BrowserIE, regexp.MustCompile(`(?:MSIE |Trident/.*rv:)([\d.]+)`)},
+}
+
+// Parse extracts the browser, OS, device class and bot status from a User-Agent string
+func Parse(ua string) Info {
+	info := Info{Browser: BrowserUnknown, OS: OSUnknown, Device: DeviceUnknown}
+	lower := strings.ToLower(ua)
+	info.Bot, info.IsBot = detectBot(lower)
+	if ua == "" {
+		return info
+	}
+
+	for _, b := range browserPatterns {
+		if m := b.pattern.FindStringSubmatch(ua); m != nil {
+			info.Browser = b.name
+			info.BrowserVersion = m[1]
+			break
+		}
+	}
+
+	switch {
+	case strings.Contains(lower, "iphone"), strings.Contains(lower, "ipod"):
+		info.OS, info.Device = OSiOS, DeviceMobile
+	case strings.Contains(lower, "ipad"):
+		info.OS, info.Device = OSiOS, DeviceTablet
+	case strings.Contains(lower, "android"):
+		info.OS = OSAndroid
+		// android tablets leave "mobile" out of their UA
+		if strings.Contains(lower, "mobile") {
+			info.Device = DeviceMobile
+		} else {
+			info.Device = DeviceTablet
+		}
+	case strings.Contains(lower, "windows phone"):
+		info.OS, info.Device = OSWindows, DeviceMobile
+	case strings.Contains(lower, "windows"):
+		info.OS, info.Device = OSWindows, DeviceDesktop
+	case strings.Contains(lower, "; cros"): // the platform token, a bare "cros" also matches "microsoft"
+		info.OS, info.Device = OSChromeOS, DeviceDesktop
+	case strings.Contains(lower, "macintosh"), strings.Contains(lower, "mac os x"):
+		info.OS, info.Device = OSMacOS, DeviceDesktop
+	case strings.Contains(lower, "linux"), strings.Contains(lower, "x11"):
+		info.OS, info.Device = OSLinux, DeviceDesktop
+	}
+
+	return info
+}
@@ -0,0 +1,60 @@
+package useragent
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		ua      string
+		browser string
+		os      string
+		device  string
+	}{
+		{"iPhone Safari", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1", BrowserSafari, OSiOS, DeviceMobile},
+		{"iPad Chrome", "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/118.0.5993.92 Mobile/15E148 Safari/604.1", BrowserChrome, OSiOS, DeviceTablet},
+		{"Android phone", "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Mobile Safari/537.36", BrowserChrome, OSAndroid, DeviceMobile},
+		{"Android tablet", "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/22.0 Chrome/111.0.5563.116 Safari/537.36", BrowserSamsung, OSAndroid, DeviceTablet},
+		{"Windows Edge", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36 Edg/118.0.2088.46", BrowserEdge, OSWindows, DeviceDesktop},
+		{"macOS Firefox", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:119.0) Gecko/20100101 Firefox/119.0", BrowserFirefox, OSMacOS, DeviceDesktop},
+		{"ChromeOS Chrome", "Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36", BrowserChrome, OSChromeOS, DeviceDesktop},
+		{"Outlook for Mac", "Microsoft Office/16.0 (Macintosh; Mac OS X 10.15; Microsoft Outlook 16.78.23100802; Pro)", BrowserUnknown, OSMacOS, DeviceDesktop},
+		{"Linux Opera", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36 OPR/104.0.0.0", BrowserOpera, OSLinux, DeviceDesktop},
+		{"empty", "", BrowserUnknown, OSUnknown, DeviceUnknown},
+		{"curl", "curl/8.4.0", BrowserUnknown, OSUnknown, DeviceUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := Parse(tt.ua)
+			if info.Browser != tt.browser || info.OS != tt.os || info.Device != tt.device {
+				t.Fatalf("Parse() = %s/%s/%s, want %s/%s/%s", info.Browser, info.OS, info.Device, tt.browser, tt.os, tt.device)
+			}
+		})
+	}
+}
+
+func TestParseBots(t *testing.T) {
+	tests := []struct {
+		ua    string
+		isBot bool
+		bot   string
+	}{
+		{"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", true, "Slackbot"},
+		{"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", true, "facebookexternalhit"},
+		{"Twitterbot/1.0", true, "Twitterbot"},
+		{"Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)", true, "Discordbot"},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", true, "Googlebot"},
+		{"curl/8.4.0", true, "curl"},
+		{"Mozilla/5.0 (compatible; SomeNewCrawler/1.0)", true, "other"},
+		{"", true, "unknown"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36", false, ""},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 Line/13.16.0", false, ""},
+	}
+
+	for _, tt := range tests {
+		info := Parse(tt.ua)
+		if info.IsBot != tt.isBot || info.Bot != tt.bot {
+			t.Fatalf("Parse(%q) bot = %t/%q, want %t/%q", tt.ua, info.IsBot, info.Bot, tt.isBot, tt.bot)
+		}
+	}
+}
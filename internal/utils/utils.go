@@ -8,7 +8,9 @@ import (
 	"net/url"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
+	"unicode/utf8"
 
 	"minify/internal/config"
 )
@@ -36,6 +38,70 @@ func IsValidURL(str string) bool {
 	return u.Scheme != "" && u.Host != ""
 }
 
+// CanonicalizeURL normalizes a URL so equivalent destinations compare equal: the scheme and host
+// are lowercased, default ports dropped, query params sorted and tracking fragments removed. Query
+// params are all kept, utm_* and click ids included, since they tell links to the same page apart
+func CanonicalizeURL(str string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(str))
+	if err != nil {
+		return "", err
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]" // ipv6
+	}
+	u.Host = host
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	query := u.Query()
+	for _, values := range query {
+		sort.Strings(values)
+	}
+	u.RawQuery = query.Encode() // Encode sorts by key
+
+	if strings.HasPrefix(u.Fragment, "utm_") || strings.HasPrefix(u.Fragment, ":~:") {
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+
+	return u.String(), nil
+}
+
+// ReferrerDomain reduces a Referer header to its lowercased host without "www." or a port,
+// e.g. "https://www.Google.com/search?q=x" -> "google.com". Returns "" if there's no usable host
+func ReferrerDomain(referer string) string {
+	u, err := url.Parse(strings.TrimSpace(referer))
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+
+	return Truncate(host, 255)
+}
+
+// Truncate shortens s to at most n bytes without splitting a UTF-8 character
+func Truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
+
 // GetBaseURL extracts the base URL from request or config
 func GetBaseURL(r *http.Request) string {
 	cfg := config.Load()
@@ -70,10 +136,17 @@ func GetClientIP(r *http.Request) string {
 	}
 
 	// last fallback to remote address
+	return RemoteIP(r)
+}
+
+// RemoteIP returns the IP of the connection's peer. Unlike GetClientIP it ignores forwarding
+// headers, which clients can set to anything, so it's safe to key rate limits on
+func RemoteIP(r *http.Request) string {
 	ip, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
 		return r.RemoteAddr
 	}
+
 	return ip
 }
 
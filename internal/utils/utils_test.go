@@ -0,0 +1,54 @@
+package utils
+
+import "testing"
+
+func TestCanonicalizeURL(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"HTTPS://Example.COM", "https://example.com/"},
+		{"https://example.com:443/a", "https://example.com/a"},
+		{"http://example.com:80/a", "http://example.com/a"},
+		{"http://example.com:8080/a", "http://example.com:8080/a"},
+		{"https://example.com/a?b=2&a=1", "https://example.com/a?a=1&b=2"},
+		{"https://example.com/a?utm_source=x&id=5&fbclid=abc", "https://example.com/a?fbclid=abc&id=5&utm_source=x"},
+		{"https://example.com/a#utm_campaign=spring", "https://example.com/a"},
+		{"https://example.com/a#section-2", "https://example.com/a#section-2"},
+		{"https://example.com/Path", "https://example.com/Path"},
+	}
+
+	for _, c := range cases {
+		got, err := CanonicalizeURL(c.in)
+		if err != nil {
+			t.Fatalf("CanonicalizeURL(%q) failed: %v", c.in, err)
+		}
+		if got != c.want {
+			t.Errorf("CanonicalizeURL(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestReferrerDomain(t *testing.T) {
+	tests := map[string]string{
+		"https://www.Google.com/search?q=minify": "google.com",
+		"http://news.ycombinator.com:8080/item":  "news.ycombinator.com",
+		"android-app://com.slack/":               "com.slack",
+		"":                                       "",
+		"not a url":                              "",
+	}
+
+	for referer, want := range tests {
+		if got := ReferrerDomain(referer); got != want {
+			t.Fatalf("ReferrerDomain(%q) = %q, want %q", referer, got, want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := Truncate("héllo", 2); got != "h" {
+		t.Fatalf("Expected truncation to keep whole characters, got %q", got)
+	}
+	if got := Truncate("short", 10); got != "short" {
+		t.Fatalf("Expected short string unchanged, got %q", got)
+	}
+}
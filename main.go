@@ -9,8 +9,11 @@ import (
 	"syscall"
 	"time"
 
+	"minify/internal/cache"
+	"minify/internal/codegen"
 	"minify/internal/config"
 	"minify/internal/database"
+	"minify/internal/geo"
 	"minify/internal/handlers"
 	"minify/internal/limiter"
 	"minify/internal/metrics"
@@ -22,7 +25,11 @@ import (
 )
 
 func main() {
-	const maxBuckets = 100000
+	const (
+		maxBuckets        = 100000
+		maxClickStreams   = 1000 // open live click streams
+		clickStreamBuffer = 256  // clicks a stream can fall behind by before it's evicted
+	)
 
 	// load + validate configs from .env
 	cfg := config.Load()
@@ -41,19 +48,87 @@ func main() {
 		log.Fatal("Failed to run migrations:", err)
 	}
 
+	rollupAggregator := services.NewRollupAggregator(db, cfg.RollupLag)
+	retentionService := services.NewRetentionService(db, time.Duration(cfg.ClickRetentionDays)*24*time.Hour, cfg.ClickArchiveDir)
+
+	// clicks can't be written without a partition for the current month
+	if err := retentionService.EnsurePartitions(context.Background()); err != nil {
+		log.Fatal("Failed to create click partitions:", err)
+	}
+
+	// one-off commands, run instead of the server
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "backfill-rollups":
+			if err := rollupAggregator.Backfill(context.Background()); err != nil {
+				log.Fatal("Failed to backfill rollups:", err)
+			}
+			log.Println("Rollups backfilled")
+		default:
+			log.Fatalf("Unknown command %q, available: backfill-rollups", os.Args[1])
+		}
+
+		return
+	}
+
 	// Prometheus metrics
 	metrics.Init()
 
+	// short code generation strategy
+	codeGen, err := codegen.New(cfg.ShortCodeStrategy, cfg.ShortCodeLength, cfg.ShortCodeSalt, db)
+	if err != nil {
+		log.Fatal("Failed to set up short code generator:", err)
+	}
+
+	// optional GeoIP database for country targeting and stats
+	geoResolver, err := geo.Open(cfg.GeoIPDBPath)
+	if err != nil {
+		log.Fatal("Failed to load GeoIP database:", err)
+	}
+	defer geoResolver.Close()
+
+	// redirect cache, optionally backed by a shared redis tier
+	var urlCache *services.URLCache
+	if cfg.CacheSize > 0 {
+		var remote cache.Store
+		if cfg.RedisURL != "" {
+			redisStore, err := cache.NewRedisStore(context.Background(), cfg.RedisURL, "minify:url:")
+			if err != nil {
+				log.Fatal("Failed to set up redis cache:", err)
+			}
+			defer redisStore.Close()
+			remote = redisStore
+		}
+		urlCache = services.NewURLCache(cfg.CacheSize, cfg.CacheTTL, cfg.CacheNegativeTTL, remote)
+	}
+
 	// services
-	urlService := services.NewURLService(db)
+	urlService := services.NewURLService(db, codeGen, urlCache)
 	userService := services.NewUserService(db)
-	analyticsService := services.NewAnalyticsService(db)
+	clickPipeline := services.NewClickPipeline(db, cfg.ClickQueueSize, cfg.ClickWorkers, cfg.ClickBatchSize, cfg.ClickFlushInterval, cfg.CountBots, cfg.VisitorSalt)
+	clickPipeline.Start()
+	clickStream := services.NewClickStream(clickStreamBuffer, maxClickStreams)
+	analyticsService := services.NewAnalyticsService(db, clickPipeline, clickStream, cfg.CountBots)
 	limiterService := limiter.NewLimiter(maxBuckets)
+	webhookService := services.NewWebhookService(db)
+	webhookDispatcher := services.NewWebhookDispatcher(db, cfg.WebhookAllowPrivate, time.Duration(cfg.WebhookRetentionDays)*24*time.Hour)
+	exportService := services.NewExportService(db, cfg.VisitorSalt)
+
+	// background jobs, stopped once the server exits
+	ctx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	go urlService.RunReaper(ctx, cfg.ReaperInterval, cfg.RestoreWindow)
+	go webhookDispatcher.Run(ctx, cfg.WebhookPollInterval)
+	go rollupAggregator.Run(ctx, cfg.RollupInterval)
+	go retentionService.Run(ctx, cfg.RetentionInterval)
 
 	// handlers
-	urlHandler := handlers.NewURLHandler(urlService, analyticsService, limiterService)
+	urlHandler := handlers.NewURLHandler(urlService, analyticsService, limiterService, geoResolver, cfg)
 	userHandler := handlers.NewUserHandler(userService)
 	analyticsHandler := handlers.NewAnalyticsHandler(analyticsService)
+	webhookHandler := handlers.NewWebhookHandler(webhookService)
+	exportHandler := handlers.NewExportHandler(exportService)
 
 	router := mux.NewRouter()
 
@@ -61,7 +136,7 @@ func main() {
 	router.Use(middleware.Logging)
 	router.Use(middleware.Metrics)
 
-	setupRoutes(router, urlHandler, userHandler, analyticsHandler)
+	setupRoutes(router, cfg, urlHandler, userHandler, analyticsHandler, webhookHandler, exportHandler)
 	router.Handle("/metrics", promhttp.Handler())
 
 	server := &http.Server{
@@ -72,33 +147,46 @@ func main() {
 		IdleTimeout:  15 * time.Second,
 	}
 
-	log.Printf("Server starting on port %s", cfg.Port)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatal("Failed to start server:", err)
-	}
+	// live click streams never go idle, so end them when shutdown starts
+	server.RegisterOnShutdown(clickStream.Close)
+
+	go func() {
+		log.Printf("Server starting on port %s", cfg.Port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Failed to start server:", err)
+		}
+	}()
 
-	// try to shutdown gracefully on termination signal if running interactively
-	if os.Getenv("INTERACTIVE") == "1" {
-		quit := make(chan os.Signal, 1)
-		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-		<-quit
+	// shutdown gracefully on termination signal
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
 
-		log.Println("Shutting down server...")
+	log.Println("Shutting down server...")
 
-		// graceful shutdown with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
+	// graceful shutdown with timeout
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-		if err := server.Shutdown(ctx); err != nil {
-			log.Fatal("Server forced to shutdown:", err)
-		}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("Server forced to shutdown:", err)
+	}
+	stopJobs()
+
+	// redirects have stopped, so write out the clicks still queued
+	if err := clickPipeline.Shutdown(shutdownCtx); err != nil {
+		log.Println("Failed to drain click queue:", err)
 	}
 	log.Println("Server exited")
 }
 
 // setupRoutes connects handlers to their endpoints
-func setupRoutes(router *mux.Router, urlHandler *handlers.URLHandler, userHandler *handlers.UserHandler, analyticsHandler *handlers.AnalyticsHandler) {
+func setupRoutes(router *mux.Router, cfg *config.Config, urlHandler *handlers.URLHandler, userHandler *handlers.UserHandler, analyticsHandler *handlers.AnalyticsHandler, webhookHandler *handlers.WebhookHandler, exportHandler *handlers.ExportHandler) {
 	api := router.PathPrefix("/api/v1").Subrouter()
+	api.Use(middleware.Authenticate(cfg.JWTSecret))
+
+	authed := func(h http.HandlerFunc) http.Handler { return middleware.RequireAuth(h) }
+	admin := func(h http.HandlerFunc) http.Handler { return middleware.RequireAdmin(cfg.AdminUserIDs)(h) }
 
 	api.Methods(http.MethodOptions).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -106,7 +194,31 @@ func setupRoutes(router *mux.Router, urlHandler *handlers.URLHandler, userHandle
 
 	// URL shortening
 	api.HandleFunc("/minify", urlHandler.MinifyURL).Methods("POST")
-	api.HandleFunc("/urls", urlHandler.GetUserURLs).Methods("GET")
+	api.Handle("/minify/bulk", authed(urlHandler.BulkMinifyURLs)).Methods("POST")
+	api.Handle("/urls", authed(urlHandler.GetUserURLs)).Methods("GET")
+	api.Handle("/urls/{shortCode}", authed(urlHandler.GetURL)).Methods("GET")
+	api.Handle("/urls/{shortCode}", authed(urlHandler.UpdateURL)).Methods("PATCH")
+	api.Handle("/urls/{shortCode}", authed(urlHandler.DeleteURL)).Methods("DELETE")
+	api.Handle("/urls/{shortCode}/restore", authed(urlHandler.RestoreURL)).Methods("POST")
+	api.Handle("/urls/{shortCode}/qr", authed(urlHandler.GetOwnedQRCode)).Methods("GET")
+	api.Handle("/urls/{shortCode}/stats", authed(urlHandler.GetURLStats)).Methods("GET")
+	api.Handle("/urls/{shortCode}/countries", authed(urlHandler.GetURLCountries)).Methods("GET")
+	api.Handle("/urls/{shortCode}/variants", authed(urlHandler.GetURLVariants)).Methods("GET")
+	api.Handle("/urls/{shortCode}/bots", authed(urlHandler.GetURLBots)).Methods("GET")
+	api.Handle("/urls/{shortCode}/referrers", authed(urlHandler.GetURLReferrers)).Methods("GET")
+	api.Handle("/urls/{shortCode}/utm", authed(urlHandler.GetURLUTM)).Methods("GET")
+
+	// webhooks
+	api.Handle("/webhooks", authed(webhookHandler.CreateWebhook)).Methods("POST")
+	api.Handle("/webhooks", authed(webhookHandler.GetWebhooks)).Methods("GET")
+	api.Handle("/webhooks/{id}", authed(webhookHandler.GetWebhook)).Methods("GET")
+	api.Handle("/webhooks/{id}", authed(webhookHandler.UpdateWebhook)).Methods("PATCH")
+	api.Handle("/webhooks/{id}", authed(webhookHandler.DeleteWebhook)).Methods("DELETE")
+	api.Handle("/webhooks/{id}/deliveries", authed(webhookHandler.GetWebhookDeliveries)).Methods("GET")
+
+	// bulk exports
+	api.Handle("/export/links", authed(exportHandler.ExportLinks)).Methods("GET")
+	api.Handle("/export/clicks", authed(exportHandler.ExportClicks)).Methods("GET")
 
 	// user
 	api.HandleFunc("/users", userHandler.CreateUser).Methods("POST")
@@ -115,7 +227,14 @@ func setupRoutes(router *mux.Router, urlHandler *handlers.URLHandler, userHandle
 	// analytics
 	api.HandleFunc("/analytics/overview", analyticsHandler.GetOverview).Methods("GET")
 	api.HandleFunc("/analytics/popular", analyticsHandler.GetPopularURLs).Methods("GET")
+	api.HandleFunc("/analytics/timeframe", analyticsHandler.GetTimeframe).Methods("GET")
 	api.HandleFunc("/analytics/timeframe/{period}", analyticsHandler.GetTimeframeStats).Methods("GET")
+	api.HandleFunc("/analytics/countries", analyticsHandler.GetCountryStats).Methods("GET")
+	api.HandleFunc("/analytics/bots", analyticsHandler.GetBotStats).Methods("GET")
+	api.HandleFunc("/analytics/referrers", analyticsHandler.GetReferrerStats).Methods("GET")
+	api.HandleFunc("/analytics/utm", analyticsHandler.GetUTMStats).Methods("GET")
+	api.Handle("/analytics/stream", admin(analyticsHandler.StreamClicks)).Methods("GET")
+	api.Handle("/analytics/stream/mine", authed(analyticsHandler.StreamOwnClicks)).Methods("GET")
 
 	// healthcheck
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -123,6 +242,11 @@ func setupRoutes(router *mux.Router, urlHandler *handlers.URLHandler, userHandle
 		w.Write([]byte("OK"))
 	}).Methods("GET")
 
+	// QR codes, ahead of the path passthrough so a bare "qr" segment is never forwarded
+	router.HandleFunc("/{shortCode}/"+services.QRPathSegment, urlHandler.GetQRCode).Methods("GET")
+
 	// redirect
 	router.HandleFunc("/{shortCode}", urlHandler.RedirectURL).Methods("GET")
+	router.HandleFunc("/{shortCode}", urlHandler.UnlockURL).Methods("POST")
+	router.HandleFunc("/{shortCode}/{path:.+}", urlHandler.RedirectURL).Methods("GET") // path passthrough
 }